@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Where --append continues the JSON array of an existing file. A zero offset means that there's nothing to
+// continue, so the file is written from the start
+type appendPoint struct {
+	offset int64  // Size of the file kept, which ends right after the last record of the array
+	tail   []byte // What came after the last record, like "]\n", which a failed conversion puts back
+}
+
+// Validates if a byte is whitespace between JSON values
+func isJSONSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
+
+// Returns the position of the last byte of f before end that's not whitespace, or -1 when there's none.
+// The file is read backwards, so only its end is read
+func lastNonSpace(f *os.File, end int64) (int64, byte, error) {
+	buf := make([]byte, 4096)
+	for end > 0 {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			return 0, 0, err
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if !isJSONSpace(chunk[i]) {
+				return start + int64(i), chunk[i], nil
+			}
+		}
+		end = start
+	}
+
+	return -1, 0, nil
+}
+
+// Finds where --append continues the JSON array of an existing file, which is right after its last record.
+// Only the ends of the array are looked at, so the file isn't read again, and the records in it are not checked.
+// A missing or empty file, or an empty array, is written from the start like a new one
+func findAppendPoint(path string) (appendPoint, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return appendPoint{}, nil
+	}
+	if err != nil {
+		return appendPoint{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return appendPoint{}, err
+	}
+
+	// The array must start the file
+	start := bufio.NewReader(f)
+	var first byte
+	for err == nil && (first == 0 || isJSONSpace(first)) {
+		first, err = start.ReadByte()
+	}
+	if err == io.EOF {
+		return appendPoint{}, nil
+	}
+	if err != nil {
+		return appendPoint{}, err
+	}
+	if first != '[' {
+		return appendPoint{}, fmt.Errorf("%s can't be appended to, since it's not a JSON array", path)
+	}
+
+	// And it must end it too, after a value
+	closing, last, err := lastNonSpace(f, info.Size())
+	if err != nil {
+		return appendPoint{}, err
+	}
+	if last != ']' {
+		return appendPoint{}, fmt.Errorf("%s can't be appended to, since its JSON array is not complete", path)
+	}
+	end, last, err := lastNonSpace(f, closing)
+	if err != nil {
+		return appendPoint{}, err
+	}
+	if last == '[' {
+		return appendPoint{}, nil
+	}
+	if last == ',' || end < 0 {
+		return appendPoint{}, fmt.Errorf("%s can't be appended to, since its JSON array is not complete", path)
+	}
+
+	tail := make([]byte, info.Size()-end-1)
+	if _, err := f.ReadAt(tail, end+1); err != nil {
+		return appendPoint{}, err
+	}
+	return appendPoint{offset: end + 1, tail: tail}, nil
+}
+
+// Copies the existing array up to the append point into the temporary file of --atomic, and into the checksum
+func copyArrayStart(path string, offset int64, w io.Writer, checksum hash.Hash) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if checksum != nil {
+		w = io.MultiWriter(w, checksum)
+	}
+	_, err = io.CopyN(w, f, offset)
+	return err
+}
+
+// Puts the end of the array back after a failed --append, so the file has the records it had before
+func restoreArrayEnd(path string, point appendPoint) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(point.offset); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteAt(point.tail, point.offset); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_findAppendPoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    appendPoint
+		wantErr bool
+	}{
+		{"Compact array", `[{"id":"1"}]` + "\n", appendPoint{offset: 11, tail: []byte("]\n")}, false},
+		{"Pretty array", "[\n   {\n      \"id\": \"1\"\n   }\n]\n", appendPoint{offset: 27, tail: []byte("\n]\n")}, false},
+		{"Spaces around", "  \n[1, 2] \r\n", appendPoint{offset: 8, tail: []byte("] \r\n")}, false},
+		{"Empty array", "[ \n]\n", appendPoint{}, false},
+		{"Empty file", "", appendPoint{}, false},
+		{"Only spaces", " \n", appendPoint{}, false},
+		{"Object", `{"id":"1"}`, appendPoint{}, true},
+		{"Missing closing bracket", `[{"id":"1"},`, appendPoint{}, true},
+		{"Trailing comma", `[{"id":"1"},]`, appendPoint{}, true},
+		{"Only a closing bracket", `]`, appendPoint{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonPath := filepath.Join(t.TempDir(), "data.json")
+			check(ioutil.WriteFile(jsonPath, []byte(tt.content), 0666))
+
+			got, err := findAppendPoint(jsonPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findAppendPoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.offset != tt.want.offset || string(got.tail) != string(tt.want.tail) {
+				t.Errorf("findAppendPoint() = %d, %q, want %d, %q", got.offset, got.tail, tt.want.offset, tt.want.tail)
+			}
+		})
+	}
+
+	if got, err := findAppendPoint(filepath.Join(t.TempDir(), "missing.json")); err != nil || got.offset != 0 {
+		t.Errorf("findAppendPoint() of a missing file = %d, %v, want 0, nil", got.offset, err)
+	}
+}
+
+func Test_appendRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		fileData inputFile
+		want     string
+	}{
+		{"Compact", `[{"id":"1"}]` + "\n", defaultFileData, `[{"id":"1"},{"id":"2"},{"id":"3"}]` + "\n"},
+		{"Pretty", "[\n   {\n      \"id\": \"1\"\n   }\n]\n", withOptions(func(f *inputFile) { f.pretty = true }), "[\n   {\n      \"id\": \"1\"\n   },\n   {\n      \"id\": \"2\"\n   },\n   {\n      \"id\": \"3\"\n   }\n]\n"},
+		{"Atomic with a checksum", `[{"id":"1"}]` + "\n", withOptions(func(f *inputFile) { f.atomic, f.checksum = true, "sha256" }), `[{"id":"1"},{"id":"2"},{"id":"3"}]` + "\n"},
+		{"Empty array", "[]\n", defaultFileData, `[{"id":"2"},{"id":"3"}]` + "\n"},
+		{"No file yet", "", defaultFileData, `[{"id":"2"},{"id":"3"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			csvPath := filepath.Join(dir, "data.csv")
+			check(ioutil.WriteFile(csvPath, []byte("id\n2\n3\n"), 0666))
+			jsonPath := filepath.Join(dir, "data.json")
+			if tt.existing != "" {
+				check(ioutil.WriteFile(jsonPath, []byte(tt.existing), 0666))
+			}
+
+			tt.fileData.filepath, tt.fileData.appendRecords = csvPath, true
+			if got := mustConvertFile(t, tt.fileData); got != 2 {
+				t.Errorf("convertFile() = %d, want the 2 records appended", got)
+			}
+			got, err := ioutil.ReadFile(jsonPath)
+			check(err)
+			if string(got) != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if !json.Valid(got) {
+				t.Errorf("output is not valid JSON")
+			}
+		})
+	}
+}
+
+func Test_restoreArrayEnd(t *testing.T) {
+	// A conversion that failed halfway leaves some records after the ones of the array
+	jsonPath := filepath.Join(t.TempDir(), "data.json")
+	check(ioutil.WriteFile(jsonPath, []byte(`[{"id":"1"}]`+"\n"), 0666))
+	point, err := findAppendPoint(jsonPath)
+	check(err)
+	f, err := os.OpenFile(jsonPath, os.O_WRONLY, 0)
+	check(err)
+	check(f.Truncate(point.offset))
+	_, err = f.WriteAt([]byte(`,{"id":`), point.offset)
+	check(err)
+	check(f.Close())
+
+	check(restoreArrayEnd(jsonPath, point))
+	got, err := ioutil.ReadFile(jsonPath)
+	check(err)
+	if want := `[{"id":"1"}]` + "\n"; string(got) != want {
+		t.Errorf("restored file = %q, want %q", got, want)
+	}
+}
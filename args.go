@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Short forms of some options, by the name of the long one. They share the value of the long option
+var shortFlags = map[string]string{"separator": "s", "pretty": "p"}
+
+// Validates if a flag is the short form of another one
+func isShortFlag(name string) bool {
+	for _, short := range shortFlags {
+		if name == short {
+			return true
+		}
+	}
+	return false
+}
+
+// Defines the short forms of the options of a flag set, once the long ones are defined
+func defineShortFlags(flags *flag.FlagSet) {
+	for long, short := range shortFlags {
+		flags.Var(flags.Lookup(long).Value, short, "Short for --"+long)
+	}
+}
+
+// Parses the options wherever they are among the locations of the files, like GNU tools do. The flag package stops
+// at the first argument that's not an option, so the parsing goes on after every location, and an unknown option
+// after a location is an error too. Everything after "--" is a location, even when it starts with a dash
+func parseInterleaved(flags *flag.FlagSet, args []string) ([]string, error) {
+	var locations, rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, rest = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if args = flags.Args(); len(args) == 0 {
+			return append(locations, rest...), nil
+		}
+		locations = append(locations, args[0])
+		args = args[1:]
+	}
+}
+
+// Prints the options of a flag set sorted by their long name, like flag.PrintDefaults does, with the short form
+// of the ones that have one, like "-s, --separator"
+func printUsage(out io.Writer, flags *flag.FlagSet) {
+	var names []string
+	flags.VisitAll(func(f *flag.Flag) {
+		if !isShortFlag(f.Name) {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := flags.Lookup(name)
+		line := "  --" + name
+		if alias, found := shortFlags[name]; found {
+			line = "  -" + alias + ", --" + name
+		}
+
+		typeName, usage := flag.UnquoteUsage(f)
+		if typeName != "" {
+			line += " " + typeName
+		}
+		line += "\n    \t" + strings.ReplaceAll(usage, "\n", "\n    \t")
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+			if typeName == "string" {
+				line += fmt.Sprintf(" (default %q)", f.DefValue)
+			} else {
+				line += fmt.Sprintf(" (default %v)", f.DefValue)
+			}
+		}
+		fmt.Fprintln(out, line)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseInterleaved(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		wantLocations []string
+		wantSeparator string
+		wantPretty    bool
+		wantErr       bool
+	}{
+		{"Options before the files", []string{"--pretty", "a.csv", "b.csv"}, []string{"a.csv", "b.csv"}, "comma", true, false},
+		{"Options after the files", []string{"a.csv", "--separator=tab", "b.csv", "--pretty"}, []string{"a.csv", "b.csv"}, "tab", true, false},
+		{"Short options", []string{"-s", "semicolon", "a.csv", "-p"}, []string{"a.csv"}, "semicolon", true, false},
+		{"Locations after --", []string{"a.csv", "--", "--pretty", "-b.csv"}, []string{"a.csv", "--pretty", "-b.csv"}, "comma", false, false},
+		{"Unknown option after a file", []string{"a.csv", "--prettty"}, nil, "comma", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("cmd", flag.ContinueOnError)
+			flags.SetOutput(ioutil.Discard)
+			separator := flags.String("separator", "comma", "")
+			pretty := flags.Bool("pretty", false, "")
+			defineShortFlags(flags)
+
+			got, err := parseInterleaved(flags, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseInterleaved() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.wantLocations) || *separator != tt.wantSeparator || *pretty != tt.wantPretty {
+				t.Errorf("parseInterleaved() = %v with separator %s and pretty %v, want %v with %s and %v",
+					got, *separator, *pretty, tt.wantLocations, tt.wantSeparator, tt.wantPretty)
+			}
+		})
+	}
+}
+
+func Test_printUsage(t *testing.T) {
+	flags := flag.NewFlagSet("cmd", flag.ContinueOnError)
+	flags.String("separator", "comma", "Column separator")
+	flags.Bool("pretty", false, "Generate pretty JSON")
+	flags.Int("limit", 0, "Stop after N records")
+	defineShortFlags(flags)
+
+	var out bytes.Buffer
+	printUsage(&out, flags)
+	want := strings.Join([]string{
+		"  --limit int",
+		"    \tStop after N records",
+		"  -p, --pretty",
+		"    \tGenerate pretty JSON",
+		"  -s, --separator string",
+		"    \tColumn separator (default \"comma\")",
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Errorf("printUsage() = %q, want %q", out.String(), want)
+	}
+}
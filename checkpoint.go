@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Default number of records written between two checkpoints
+const defaultCheckpointEvery = 100000
+
+// What --checkpoint records about a conversion in progress, so it can be continued later with --resume.
+// The zero value means starting from the beginning of the file
+type checkpoint struct {
+	InputOffset  int64  `json:"input_offset"`  // Position in the CSV file right after the last line converted
+	Records      int64  `json:"records"`       // Number of records already in the output
+	OutputBytes  int64  `json:"output_bytes"`  // Size of the output up to the last record
+	OutputSHA256 string `json:"output_sha256"` // Checksum of those OutputBytes bytes
+}
+
+// Reads a checkpoint file. When the file doesn't exist, the conversion starts from the beginning
+func loadCheckpoint(path string) (checkpoint, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		logger.infof("No checkpoint found at %s. Starting from the beginning", path)
+		return checkpoint{}, nil
+	}
+	if err != nil {
+		return checkpoint{}, err
+	}
+
+	var state checkpoint
+	if err := json.Unmarshal(content, &state); err != nil {
+		return checkpoint{}, fmt.Errorf("Checkpoint %s is not valid: %v", path, err)
+	}
+
+	return state, nil
+}
+
+// Writes the checkpoint into a temporary file first, so a crash in the middle never leaves a broken checkpoint
+func saveCheckpoint(path string, state checkpoint) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// Validates that the first OutputBytes bytes of the output are the ones the checkpoint was made with.
+// Those bytes are fed into outputHash, so it can keep hashing what gets appended afterwards
+func verifyPartialOutput(jsonPath string, state checkpoint, outputHash hash.Hash) error {
+	f, err := os.Open(jsonPath)
+	if err != nil {
+		return fmt.Errorf("Output %s can't be resumed: %v", jsonPath, err)
+	}
+	defer f.Close()
+
+	if n, err := io.CopyN(outputHash, f, state.OutputBytes); err != nil {
+		return fmt.Errorf("Output %s is shorter than the %d bytes in the checkpoint (%d bytes)", jsonPath, state.OutputBytes, n)
+	}
+
+	if hex.EncodeToString(outputHash.Sum(nil)) != state.OutputSHA256 {
+		return fmt.Errorf("Output %s was modified after the checkpoint was made", jsonPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_resumeFromCheckpoint(t *testing.T) {
+	csvString := "id,name\n1,a\n2,b\n3,c\n4,d\n"
+	tests := []struct {
+		name   string
+		pretty bool
+		cutAt  string // The output is cut right before this text, just like if the crash happened there
+	}{
+		{"Compact JSON", false, `,{"id":"3"`},
+		{"Pretty JSON", true, ",\n   {\n      \"id\": \"3\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			csvPath := filepath.Join(dir, "data.csv")
+			jsonPath := filepath.Join(dir, "data.json")
+			checkpointPath := filepath.Join(dir, "progress.json")
+			check(ioutil.WriteFile(csvPath, []byte(csvString), 0666))
+
+			fileData := withOptions(func(f *inputFile) {
+				f.filepath, f.pretty, f.checkpoint, f.checksum = csvPath, tt.pretty, checkpointPath, "sha256"
+			})
+
+			// Converting the whole file first, to know what the output should look like
+			mustConvertFile(t, fileData)
+			want, err := ioutil.ReadFile(jsonPath)
+			check(err)
+
+			// Leaving the output and the checkpoint as they would be after crashing in the middle of the third record
+			partial := string(want[:strings.Index(string(want), tt.cutAt)])
+			check(ioutil.WriteFile(jsonPath, []byte(partial+tt.cutAt[:4]), 0666))
+			sum := sha256.Sum256([]byte(partial))
+			fileData.resume = checkpoint{
+				InputOffset:  int64(len("id,name\n1,a\n2,b\n")),
+				Records:      2,
+				OutputBytes:  int64(len(partial)),
+				OutputSHA256: hex.EncodeToString(sum[:]),
+			}
+			check(saveCheckpoint(checkpointPath, fileData.resume))
+
+			mustConvertFile(t, fileData)
+
+			got, err := ioutil.ReadFile(jsonPath)
+			check(err)
+			if string(got) != string(want) {
+				t.Errorf("resumed output = %q, want %q", got, want)
+			}
+			// The checksum must also cover what was written before the checkpoint
+			sum = sha256.Sum256(got)
+			if checksum, _ := ioutil.ReadFile(jsonPath + ".sha256"); string(checksum) != hex.EncodeToString(sum[:])+"  data.json\n" {
+				t.Errorf("checksum file = %q, want the checksum of the resumed output", checksum)
+			}
+			if _, err := os.Stat(checkpointPath); !os.IsNotExist(err) {
+				t.Errorf("checkpoint %s should be removed once the conversion is done", checkpointPath)
+			}
+		})
+	}
+}
+
+func Test_periodicCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	jsonPath := filepath.Join(dir, "data.json")
+	checkpointPath := filepath.Join(dir, "progress.json")
+	fileData := withOptions(func(f *inputFile) { f.filepath, f.checkpoint, f.checkpointEvery = csvPath, checkpointPath, 2 })
+
+	headers := []string{"id"}
+	writerChannel := make(chan recordBatch)
+	done := make(chan error)
+	go func() {
+		_, err := writeJSONFile(context.Background(), fileData, writerChannel)
+		done <- err
+	}()
+
+	// The third send only goes through once the writer is done with the second batch, checkpoint included.
+	// The third record alone isn't enough for another checkpoint, so the file stays as it is while we check it
+	writerChannel <- recordBatch{records: []record{{headers, []string{"1"}, nil}}, offset: 5}
+	writerChannel <- recordBatch{records: []record{{headers, []string{"2"}, nil}}, offset: 7}
+	writerChannel <- recordBatch{records: []record{{headers, []string{"3"}, nil}}, offset: 9}
+
+	state, err := loadCheckpoint(checkpointPath)
+	check(err)
+	output, err := ioutil.ReadFile(jsonPath)
+	check(err)
+	partial := `[{"id":"1"},{"id":"2"}`
+	sum := sha256.Sum256([]byte(partial))
+	want := checkpoint{InputOffset: 7, Records: 2, OutputBytes: int64(len(partial)), OutputSHA256: hex.EncodeToString(sum[:])}
+	if state != want {
+		t.Errorf("checkpoint = %+v, want %+v", state, want)
+	}
+	if !strings.HasPrefix(string(output), partial) {
+		t.Errorf("output at the checkpoint = %q, want it to start with %q", output, partial)
+	}
+
+	close(writerChannel)
+	check(<-done)
+}
+
+func Test_verifyPartialOutput(t *testing.T) {
+	jsonPath := filepath.Join(t.TempDir(), "data.json")
+	check(ioutil.WriteFile(jsonPath, []byte(`[{"id":"1"},{"id":"2"`), 0666))
+	sum := sha256.Sum256([]byte(`[{"id":"1"}`))
+
+	tests := []struct {
+		name    string
+		state   checkpoint
+		wantErr bool
+	}{
+		{"Output matches the checkpoint", checkpoint{OutputBytes: 11, OutputSHA256: hex.EncodeToString(sum[:])}, false},
+		{"Output was modified", checkpoint{OutputBytes: 11, OutputSHA256: strings.Repeat("0", 64)}, true},
+		{"Output is too short", checkpoint{OutputBytes: 100, OutputSHA256: hex.EncodeToString(sum[:])}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyPartialOutput(jsonPath, tt.state, sha256.New())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyPartialOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Files bigger than this are only converted once the user confirms, unless --confirm-above says otherwise
+const defaultConfirmAbove = 1024 * 1024 * 1024
+
+// Number of rows read to estimate how many rows a file has
+const sampleRows = 1000
+
+// What the confirmation shows about a file before converting it
+type fileSample struct {
+	size          int64
+	separator     string
+	headers       []string
+	estimatedRows int64
+	exact         bool // The whole file was read, so estimatedRows is the real number of rows
+	compressed    bool // The size of the file says nothing about its rows, so they are not estimated
+}
+
+// Validates if a file is a terminal, so there's somebody to answer our questions
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Reads the headers and the first rows of a file. The number of rows is estimated by dividing
+// the rest of the file by the average size of those first rows
+func sampleFile(fileData inputFile) (fileSample, error) {
+	file, err := os.Open(fileData.filepath)
+	if err != nil {
+		return fileSample{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fileSample{}, err
+	}
+
+	input, compressed, err := openContent(file)
+	if err != nil {
+		return fileSample{}, err
+	}
+	if !fileData.noSniff {
+		if input, err = sniffContent(fileData.filepath, input); err != nil {
+			return fileSample{}, err
+		}
+	}
+
+	guard := &fieldGuard{r: input, remaining: -1}
+	bufReader := bufio.NewReaderSize(guard, minReadBuffer)
+	offset := func() int64 {
+		return guard.read - int64(bufReader.Buffered())
+	}
+
+	if err := skipBOM(bufReader); err != nil {
+		return fileSample{}, err
+	}
+	var declaredSeparator rune
+	declared := false
+	if fileData.fixedWidths == nil {
+		if declaredSeparator, declared, err = readSepDirective(bufReader); err != nil {
+			return fileSample{}, err
+		}
+	}
+	if fileData.extractMeta {
+		if _, err := readMetaLines(bufReader); err != nil {
+			return fileSample{}, err
+		}
+	}
+
+	separator := multiSeparator(fileData)
+	comma := fileSeparator(fileData, declaredSeparator, declared)
+	if separator == "" {
+		separator = string(comma)
+	}
+	reader := newRecordReader(bufReader, comma, fileData)
+	headers := fileData.givenHeaders
+	if headers == nil {
+		if headers, err = reader.Read(); err != nil {
+			return fileSample{}, err
+		}
+	}
+
+	// The reader reuses the memory of its records, so the headers are copied
+	sample := fileSample{size: info.Size(), separator: separator, headers: append([]string(nil), headers...), compressed: compressed}
+	start := offset()
+	for sample.estimatedRows < sampleRows {
+		if _, err := reader.Read(); err == io.EOF {
+			sample.exact = true
+			return sample, nil
+		} else if err != nil {
+			return fileSample{}, err
+		}
+		sample.estimatedRows++
+	}
+
+	if sampled := offset() - start; sampled > 0 && !compressed {
+		sample.estimatedRows = (sample.size - start) * sample.estimatedRows / sampled
+	}
+
+	return sample, nil
+}
+
+// Shows the settings the file is going to be converted with and asks the user to confirm them
+func confirmConversion(fileData inputFile, in *bufio.Reader, out io.Writer) (bool, error) {
+	// Only files bigger than the threshold are worth asking about
+	info, err := os.Stat(fileData.filepath)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() <= fileData.confirmAbove {
+		return true, nil
+	}
+
+	sample, err := sampleFile(fileData)
+	if err != nil {
+		return false, err
+	}
+
+	rows := fmt.Sprintf("%d", sample.estimatedRows)
+	if sample.compressed && !sample.exact {
+		rows = "unknown, the file is compressed"
+	} else if !sample.exact {
+		rows = "about " + rows
+	}
+
+	fmt.Fprintf(out, "%s is %d bytes big. It's going to be converted with these settings:\n", fileData.filepath, sample.size)
+	fmt.Fprintf(out, "  Separator: %q\n", sample.separator)
+	fmt.Fprintf(out, "  Columns (%d): %s\n", len(sample.headers), strings.Join(sample.headers, ", "))
+	fmt.Fprintf(out, "  Output: %s\n", outputLocation(fileData, fileData.outputSuffix))
+	fmt.Fprintf(out, "  Rows: %s\n", rows)
+
+	return askToProceed(in, out)
+}
+
+// Asks the user whether to convert the file, which only happens when the answer is yes
+func askToProceed(in *bufio.Reader, out io.Writer) (bool, error) {
+	fmt.Fprint(out, "Proceed? [y/N] ")
+	answer, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// Prints the first records of --preview-confirm into out, pretty whatever the output is going to be, and then asks
+// whether to convert the file when ask says there's somebody to answer. Otherwise the file is always converted
+func previewConversion(fileData inputFile, ask bool, in *bufio.Reader, out io.Writer) (bool, error) {
+	previewData := fileData
+	previewData.preview = fileData.previewFirst
+	previewData.pretty = fileData.indent != ""
+	previewData.recordPerLine = !previewData.pretty
+	// The lines skipped are written into the error file by the conversion itself
+	previewData.errorFile = ""
+
+	if err := convertTo(previewData, out); err != nil {
+		return false, err
+	}
+	fmt.Fprintln(out)
+
+	if !ask {
+		return true, nil
+	}
+	return askToProceed(in, out)
+}
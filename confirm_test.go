@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_sampleFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		rows      int
+		wantRows  int64
+		wantExact bool
+	}{
+		{"Small file is read completely", 10, 10, true},
+		{"Big file is estimated from the first rows", 5000, 5000, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every row has the same size, so the estimate is exact
+			var csvString strings.Builder
+			csvString.WriteString("sep=;\nid;name\n")
+			for i := 0; i < tt.rows; i++ {
+				fmt.Fprintf(&csvString, "%06d;abc\n", i)
+			}
+			csvPath := filepath.Join(t.TempDir(), "data.csv")
+			check(ioutil.WriteFile(csvPath, []byte(csvString.String()), 0666))
+
+			sample, err := sampleFile(withOptions(func(f *inputFile) { f.filepath = csvPath }))
+			if err != nil {
+				t.Fatalf("sampleFile() error = %v", err)
+			}
+			if sample.separator != ";" || strings.Join(sample.headers, ",") != "id,name" {
+				t.Errorf("sampleFile() found separator %q and headers %v, want \";\" and [id name]", sample.separator, sample.headers)
+			}
+			if sample.estimatedRows != tt.wantRows || sample.exact != tt.wantExact {
+				t.Errorf("sampleFile() estimated %d rows (exact %v), want %d", sample.estimatedRows, sample.exact, tt.wantRows)
+			}
+		})
+	}
+}
+
+func Test_confirmConversion(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id,name\n1,a\n2,b\n"), 0666))
+
+	tests := []struct {
+		name         string
+		confirmAbove int64
+		answer       string
+		want         bool
+		wantAsked    bool
+	}{
+		{"Small file is not asked about", 1000, "", true, false},
+		{"Yes", 10, "y\n", true, true},
+		{"Yes in capitals", 10, " YES\r\n", true, true},
+		{"No", 10, "n\n", false, true},
+		{"No answer", 10, "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			fileData := withOptions(func(f *inputFile) { f.filepath, f.confirmAbove = csvPath, tt.confirmAbove })
+
+			got, err := confirmConversion(fileData, bufio.NewReader(strings.NewReader(tt.answer)), &out)
+			if err != nil {
+				t.Fatalf("confirmConversion() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("confirmConversion() = %v, want %v", got, tt.want)
+			}
+			if asked := strings.Contains(out.String(), "Proceed? [y/N]"); asked != tt.wantAsked {
+				t.Errorf("confirmConversion() printed %q, want asking %v", out.String(), tt.wantAsked)
+			}
+			if tt.wantAsked && !strings.Contains(out.String(), "Columns (2): id, name") {
+				t.Errorf("confirmConversion() printed %q, want the columns in it", out.String())
+			}
+		})
+	}
+}
+
+func Test_previewConversion(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id,name\n1,a\n2,b\n3,c\n"), 0666))
+
+	tests := []struct {
+		name      string
+		ask       bool
+		answer    string
+		want      bool
+		wantAsked bool
+	}{
+		{"Without a terminal the file is converted", false, "n\n", true, false},
+		{"Yes", true, "y\n", true, true},
+		{"No", true, "n\n", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			fileData := withOptions(func(f *inputFile) { f.filepath, f.previewFirst = csvPath, 2 })
+
+			got, err := previewConversion(fileData, tt.ask, bufio.NewReader(strings.NewReader(tt.answer)), &out)
+			if err != nil {
+				t.Fatalf("previewConversion() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("previewConversion() = %v, want %v", got, tt.want)
+			}
+			if asked := strings.Contains(out.String(), "Proceed? [y/N]"); asked != tt.wantAsked {
+				t.Errorf("previewConversion() printed %q, want asking %v", out.String(), tt.wantAsked)
+			}
+			// Only the first records are printed, and pretty even though the output is not
+			wantPreview := "[\n   {\n      \"id\": \"1\",\n      \"name\": \"a\"\n   },\n   {\n      \"id\": \"2\",\n      \"name\": \"b\"\n   }\n]\n"
+			if !strings.HasPrefix(out.String(), wantPreview) {
+				t.Errorf("previewConversion() printed %q, want it to start with %q", out.String(), wantPreview)
+			}
+		})
+	}
+}
@@ -1,215 +1,3934 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"math"
+	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
-type inputFile struct {
-	filepath  string
-	separator string
-	pretty    bool
+// The line breaks --line-ending can write, besides the native one
+var lineEndings = map[string]string{"lf": "\n", "crlf": "\r\n"}
+
+// Number of records the reader groups together before handing them to the writer.
+// Sending whole batches instead of single records keeps channel synchronization cheap on narrow files
+const defaultBatchSize = 512
+
+// Number of batches that can be waiting in the writer channel before the reader blocks
+const writerChannelBuffer = 4
+
+// Size in bytes of the buffer gathering the JSON fragments before they are written to the file
+const defaultWriteBuffer = 64 * 1024
+
+// Size in bytes of the buffer used to read the CSV file
+const defaultReadBuffer = 64 * 1024
+
+// The CSV reader puts its own buffer over readers smaller than this, which would hide how much of the file was consumed
+const minReadBuffer = 4096
+
+// Biggest field we accept by default. Well-formed files never get close to it,
+// while a malformed one (like an unterminated quote) is stopped before taking all the memory
+const defaultMaxFieldBytes = 64 * 1024 * 1024
+
+// Bytes a record can take on top of --max-field-bytes, for its separators, its quotes and its other small fields
+const recordSlackBytes = 64 * 1024
+
+// Maximum number of columns of the headers by default. A file with more is most likely read with the wrong separator
+const defaultMaxColumns = 10000
+
+// Excel files can start with a line like "sep=;" declaring the separator used in the rest of the file
+var sepDirective = regexp.MustCompile(`^sep=(.)\r?$`)
+
+// Metadata lines like "# author: Jane" that some files have before the headers
+var metaLine = regexp.MustCompile(`^#\s*(\w+):\s*(.*)$`)
+
+// Characters that --normalize-keys replaces in the headers, which is anything but letters and digits
+var keyPunctuation = regexp.MustCompile(`[^\pL\pN]+`)
+
+// Indentation of one level of the pretty output, unless --indent or --tabs say otherwise
+const defaultIndent = "   "
+
+type inputFile struct {
+	filepath          string
+	separator         string
+	pretty            bool
+	align             bool
+	batchSize         int
+	separatorGiven    bool
+	separatorFallback []string // Separators tried in order when the headers come out as a single column
+	logLevel          logLevel
+	logFormat         string
+	writeBuffer       int
+	readBuffer        int
+	maxFieldBytes     int64
+	maxColumns        int
+	rangeFilters      []rangeFilter
+	columns           []string
+	columnsFromSchema bool
+	checkpoint        string
+	checkpointEvery   int64
+	flushEvery        int64
+	resume            checkpoint
+	timing            bool
+	bytesRead         *int64 // Bytes read from the file, counted with --timing
+	filepaths         []string
+	filesFromStdin    bool
+	retries           int
+	retryDelay        time.Duration
+	ifNewer           bool
+	force             bool
+	atomic            bool
+	appendRecords     bool
+	appendAt          appendPoint // Where --append continues the existing JSON file
+	rootKey           string
+	extractMeta       bool
+	extractColumn     string
+	checksum          string
+	indent            string
+	preview           int
+	previewFirst      int // Records printed to stderr by --preview-confirm before converting the file
+	confirmAbove      int64
+	yes               bool
+	redactions        []redaction
+	redactMask        string
+	hashColumns       []string
+	hashSalt          string
+	profile           bool
+	diffAgainst       string // Previous JSON file the records are compared with
+	keyColumn         string
+	profileCap        int
+	emitSchema        string
+	uniqueColumns     []string
+	uniqueKey         []string
+	uniqueStrict      bool
+	uniqueSkipEmpty   bool
+	template          bool
+	nullValue         string
+	nullValueGiven    bool
+	forceExtension    bool
+	scalar            bool
+	allowEmpty        bool
+	typed             bool
+	typeMap           map[string]valueType
+	booleanTokens     map[string]bool
+	floatFormat       floatFormat
+	percentMode       string
+	decimalComma      bool
+	currencySymbols   map[string]string
+	currencyAsNumber  bool
+	stringify         bool
+	outputSuffix      string
+	outputDir         string
+	outputTemplate    string
+	runDate           string // Date of the {date} placeholder of --output-template
+	shard             int    // Number of the file being written by --split-records, from 1
+	createDirs        bool
+	outputMode        fileMode
+	recordPerLine     bool
+	noTrailingNewline bool
+	escaping          jsonEscaping
+	ragged            string
+	defaults          []columnDefault
+	sortBy            sortOrder
+	keyOrder          string
+	keyOrderList      []string
+	sampleEvery       int
+	limit             int
+	failOnEmpty       bool
+	splitRecords      int64
+	manifest          string
+	sampleRate        float64
+	seed              int64
+	keyPrefix         string
+	wrapKey           string
+	newlineHandling   string
+	schema            *jsonSchema
+	dryValidate       bool
+	normalize         string
+	normalizeKeys     bool
+	headerRenames     []headerRename
+	fuzzyColumns      bool
+	valueCases        []valueCase
+	errorFile         string
+	skipBlankRows     bool
+	skipAllEmptyRows  bool
+	trailingSeparator bool
+	givenHeaders      []string
+	fixedWidths       []int
+	widthUnit         string
+	reverse           bool
+	quoteMode         string
+	lineEnding        string
+	fieldsPerRecord   int
+	sourceField       string
+	concatFields      []concatField
+	concatSep         string
+	excluded          []string
+	geoPoints         []geoPoint
+	geoInvalid        string
+	decodings         []columnDecoding
+	decodeInvalid     string
+	decodeBinary      string
+	parseJSON         []string
+	parseJSONInvalid  string
+	parseJSONDepth    int
+	parseJSONBytes    int
+	dedupeKey         string
+	dedupeKeep        string
+	lazyQuotes        bool
+	trimLeadingSpace  bool
+	comment           rune
+	reuseRecord       bool
+	noSniff           bool
+	noColor           bool
+	report            string        // File where --report writes what happened to every file
+	skipped           *skippedLines // Where the lines skipped are counted for the report
+	silent            bool          // Set for the records encoded a second time, like by --emit-schema, so nothing is warned twice
+}
+
+// A flag that can be given several times, keeping every value in order
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// A --filter-range option. Only the rows where the column holds a number between min and max (both included) are kept
+type rangeFilter struct {
+	column string
+	min    float64
+	max    float64
+}
+
+// A column masked with --redact. keep is the number of characters left visible at the end of the values
+type redaction struct {
+	column string
+	keep   int
+}
+
+// A value given with --default for the empty cells of a column
+type columnDefault struct {
+	column string
+	value  string
+}
+
+// A --rename-regex option. The matches of the pattern in every header are replaced, with $1 standing for a group
+type headerRename struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// Parses a rename written as PATTERN=replacement. The pattern ends at the first =, so it can't have one of its own
+// but the replacement can
+func parseHeaderRename(value string) (headerRename, error) {
+	separatorIndex := strings.Index(value, "=")
+	if separatorIndex <= 0 {
+		return headerRename{}, fmt.Errorf("Rename %s must be written as PATTERN=replacement", value)
+	}
+
+	pattern, err := regexp.Compile(value[:separatorIndex])
+	if err != nil {
+		return headerRename{}, fmt.Errorf("Rename %s has an invalid pattern: %v", value, err)
+	}
+	return headerRename{pattern, value[separatorIndex+1:]}, nil
+}
+
+// A --geo option. The latitude and longitude columns are replaced by a GeoJSON point under the key
+type geoPoint struct {
+	lat string
+	lon string
+	key string
+}
+
+// Parses a point written as LAT,LON>KEY
+func parseGeoPoint(value string) (geoPoint, error) {
+	separatorIndex := strings.LastIndex(value, ">")
+	if separatorIndex < 0 {
+		return geoPoint{}, fmt.Errorf("Point %s must be written as LAT,LON>KEY", value)
+	}
+
+	columns := splitColumnList(value[:separatorIndex], ",")
+	key := strings.TrimSpace(value[separatorIndex+1:])
+	if len(columns) != 2 || key == "" {
+		return geoPoint{}, fmt.Errorf("Point %s must be written as LAT,LON>KEY", value)
+	}
+	return geoPoint{columns[0], columns[1], key}, nil
+}
+
+// A key added to every record with --concat, whose value is the values of some columns joined by --concat-sep
+type concatField struct {
+	key     string
+	columns []string
+}
+
+// Parses a concatenation written as KEY=COLUMN+COLUMN
+func parseConcatField(value string) (concatField, error) {
+	separatorIndex := strings.Index(value, "=")
+	if separatorIndex < 0 || strings.TrimSpace(value[:separatorIndex]) == "" {
+		return concatField{}, fmt.Errorf("Concatenation %s must be written as KEY=COLUMN+COLUMN", value)
+	}
+
+	columns := splitColumnList(value[separatorIndex+1:], "+")
+	if columns == nil {
+		return concatField{}, fmt.Errorf("Concatenation %s has no columns to join", value)
+	}
+	return concatField{strings.TrimSpace(value[:separatorIndex]), columns}, nil
+}
+
+// Parses a default written as COLUMN:value. The value may have colons of its own, like a time
+func parseColumnDefault(value string) (columnDefault, error) {
+	separatorIndex := strings.Index(value, ":")
+	if separatorIndex <= 0 {
+		return columnDefault{}, fmt.Errorf("Default %s must be written as COLUMN:value", value)
+	}
+
+	return columnDefault{value[:separatorIndex], value[separatorIndex+1:]}, nil
+}
+
+// Parses an indentation given as a number of spaces, as tab, or as the spaces and tabs to use
+func parseIndent(value string) (string, error) {
+	if strings.EqualFold(value, "tab") {
+		return "\t", nil
+	}
+
+	if spaces, err := strconv.Atoi(value); err == nil {
+		if spaces < 0 {
+			return "", errors.New("The indentation can't be a negative number of spaces")
+		}
+		return strings.Repeat(" ", spaces), nil
+	}
+
+	if strings.Trim(value, " \t") != "" {
+		return "", errors.New("The indentation can only be a number of spaces, tab, or spaces and tabs")
+	}
+
+	return value, nil
+}
+
+// Parses a redaction written as COLUMN, or as COLUMN:N to keep the last N characters
+func parseRedaction(value string) (redaction, error) {
+	if separatorIndex := strings.LastIndex(value, ":"); separatorIndex > 0 {
+		if keep, err := strconv.Atoi(value[separatorIndex+1:]); err == nil {
+			if keep < 0 {
+				return redaction{}, fmt.Errorf("Redaction %s can't keep a negative number of characters", value)
+			}
+			return redaction{value[:separatorIndex], keep}, nil
+		}
+	}
+
+	if value == "" {
+		return redaction{}, errors.New("A redaction needs a column")
+	}
+
+	return redaction{value, 0}, nil
+}
+
+// Masks a value, leaving its last keep characters visible. Values that aren't longer than that are masked completely
+func redactValue(value string, mask string, keep int) string {
+	if keep == 0 || utf8.RuneCountInString(value) <= keep {
+		return mask
+	}
+
+	runes := []rune(value)
+	return mask + string(runes[len(runes)-keep:])
+}
+
+// Replaces a value with the SHA-256 of the salt followed by the value. The same value always gets the same hash
+func hashValue(value string, salt string) string {
+	sum := sha256.Sum256([]byte(salt + value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Changes the line breaks of a value for --newline-handling. Carriage returns are handled like newlines,
+// and with space a whole run of them becomes a single space
+func handleNewlines(value string, handling string) string {
+	if handling == "keep" || !strings.ContainsAny(value, "\r\n") {
+		return value
+	}
+
+	var b strings.Builder
+	b.Grow(len(value) + 1)
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '\r' && c != '\n' {
+			b.WriteByte(c)
+			continue
+		}
+
+		switch handling {
+		case "escape":
+			if c == '\r' {
+				b.WriteString(`\r`)
+			} else {
+				b.WriteString(`\n`)
+			}
+		case "space":
+			if i == 0 || (value[i-1] != '\r' && value[i-1] != '\n') {
+				b.WriteByte(' ')
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// A --value-case option, changing the case of the values of a column
+type valueCase struct {
+	column string
+	mode   string
+}
+
+// Parses a list of value cases written as COLUMN:case,COLUMN:case
+func parseValueCases(list string) ([]valueCase, error) {
+	var cases []valueCase
+	for _, entry := range splitColumnList(list, ",") {
+		separatorIndex := strings.LastIndex(entry, ":")
+		if separatorIndex <= 0 {
+			return nil, fmt.Errorf("Value case %s must be written as COLUMN:case", entry)
+		}
+
+		mode := strings.TrimSpace(entry[separatorIndex+1:])
+		if !(mode == "lower" || mode == "upper" || mode == "title") {
+			return nil, fmt.Errorf("Unknown case %s. Use lower, upper or title", mode)
+		}
+		cases = append(cases, valueCase{strings.TrimSpace(entry[:separatorIndex]), mode})
+	}
+
+	return cases, nil
+}
+
+// Changes the case of a value. The mappings are the ones of unicode, which don't depend on the language,
+// so a Turkish "I" becomes "i" and not a dotless "ı"
+func changeCase(value string, mode string) string {
+	switch mode {
+	case "lower":
+		return strings.ToLower(value)
+	case "upper":
+		return strings.ToUpper(value)
+	}
+
+	// With title, every word starts with a title case letter and goes on in lower case
+	runes := []rune(value)
+	wordStart := true
+	for i, r := range runes {
+		if wordStart {
+			runes[i] = unicode.ToTitle(r)
+		} else {
+			runes[i] = unicode.ToLower(r)
+		}
+		wordStart = !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}
+
+	return string(runes)
+}
+
+// A change made to the values of a column of every line
+type columnTransform struct {
+	index int
+	apply func(string) string
+}
+
+// Parses a range filter written as COLUMN:min..max. Any of the bounds can be left empty to leave that side open
+func parseRangeFilter(value string) (rangeFilter, error) {
+	separatorIndex := strings.LastIndex(value, ":")
+	if separatorIndex <= 0 {
+		return rangeFilter{}, fmt.Errorf("Range filter %s must be written as COLUMN:min..max", value)
+	}
+
+	bounds := strings.SplitN(value[separatorIndex+1:], "..", 2)
+	if len(bounds) != 2 {
+		return rangeFilter{}, fmt.Errorf("Range filter %s must be written as COLUMN:min..max", value)
+	}
+
+	filter := rangeFilter{value[:separatorIndex], math.Inf(-1), math.Inf(1)}
+	for i, limit := range []*float64{&filter.min, &filter.max} {
+		if bounds[i] == "" {
+			continue
+		}
+
+		number, err := strconv.ParseFloat(bounds[i], 64)
+		if err != nil {
+			return rangeFilter{}, fmt.Errorf("Range filter %s has a bound that is not a number: %s", value, bounds[i])
+		}
+		*limit = number
+	}
+
+	if filter.min > filter.max {
+		return rangeFilter{}, fmt.Errorf("Range filter %s has a minimum bigger than its maximum", value)
+	}
+
+	return filter, nil
+}
+
+// Functions run by exitGracefully before the program exits, like removing half-written files
+var cleanups = struct {
+	sync.Mutex
+	next      int
+	functions map[int]func()
+}{functions: make(map[int]func())}
+
+// Registers a function to run if the program exits with an error. The returned function unregisters it
+func addCleanup(cleanup func()) func() {
+	cleanups.Lock()
+	defer cleanups.Unlock()
+
+	id := cleanups.next
+	cleanups.next++
+	cleanups.functions[id] = cleanup
+
+	return func() {
+		cleanups.Lock()
+		defer cleanups.Unlock()
+		delete(cleanups.functions, id)
+	}
+}
+
+func runCleanups() {
+	cleanups.Lock()
+	defer cleanups.Unlock()
+
+	for id, cleanup := range cleanups.functions {
+		cleanup()
+		delete(cleanups.functions, id)
+	}
+}
+
+// The error that ends the program, for the cleanups that report it
+var fatalError error
+
+func exitGracefully(err error) {
+	logger.errorf("%v", err)
+	fatalError = err
+	runCleanups()
+	os.Exit(1)
+}
+
+func check(err error) {
+	if err != nil {
+		exitGracefully(err)
+	}
+}
+
+func getFileData() (inputFile, error) {
+	// We need to validate that we're getting the correct number of arguments
+	if len(os.Args) < 2 {
+		return inputFile{}, errors.New("A filepath arguement is required")
+	}
+
+	// Defining option flags. For this, we're using the Flag package from the standard library
+	// We need to define three arguments: the flag's name, the default value,
+	// and a short description (displayed whith the option --help)
+	separator := flag.String("separator", "comma", "Column Separator: comma, semicolon, tab (the default for .tsv files) or the text between the fields, like ||")
+	separatorFallback := flag.String("separator-fallback", "", "Comma-separated list of separators tried in order when the separator reads the headers as a single column, like semicolon,tab")
+	pretty := flag.Bool("pretty", false, "Generate pretty JSON")
+	align := flag.Bool("align", false, "Pad the keys of the pretty output so the values of each record line up")
+	recordPerLine := flag.Bool("record-per-line", false, "Write each record of the compact JSON on its own line")
+	noEscapeHTML := flag.Bool("no-escape-html", false, "Write <, > and & as they are instead of escaping them")
+	ascii := flag.Bool("ascii", false, "Escape every non-ASCII character, so the output is pure ASCII")
+	noTrailingNewline := flag.Bool("no-trailing-newline", false, "Don't end the output with a newline")
+	batchSize := flag.Int("batch-size", defaultBatchSize, "Number of records processed together")
+	logLevelName := flag.String("log-level", "info", "Minimum level of the logged messages: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Format of the logged messages: text or json")
+	report := flag.String("report", "", "Write a JSON report of the conversion of every file, with its records, skipped lines, duration and options, even when it fails")
+	noColor := flag.Bool("no-color", false, "Don't color the logged messages, which are only colored on a terminal. NO_COLOR does the same")
+	writeBuffer := flag.Int("write-buffer", defaultWriteBuffer, "Size in bytes of the output buffer")
+	flushEvery := flag.Int64("flush-every", 0, "Number of records written between two flushes of the output buffer (0 only flushes the full buffer and the end)")
+	readBuffer := flag.Int("read-buffer", defaultReadBuffer, "Size in bytes of the input buffer")
+	maxColumns := flag.Int("max-columns", defaultMaxColumns, "Maximum number of columns of the headers (0 means no limit). --force converts the files with more anyway")
+	maxFieldBytes := flag.Int64("max-field-bytes", defaultMaxFieldBytes, "Maximum size in bytes of a single field (0 means no limit)")
+	var filterRanges, redactColumns, hashColumns, uniqueColumns, uniqueKey, defaultValues, concatValues, renameRegexes, geoValues, decodeValues repeatedFlag
+	flag.Var(&filterRanges, "filter-range", "Only keep the rows where a numeric column is in a range, as COLUMN:min..max (can be repeated)")
+	columns := flag.String("columns", "", "Comma separated list of the columns to include")
+	columnsFile := flag.String("columns-file", "", "File with the columns to include, one per line")
+	columnsFromSchema := flag.String("columns-from-schema", "", "JSON Schema whose properties are the columns to include")
+	checkpointPath := flag.String("checkpoint", "", "File where the progress is periodically saved")
+	checkpointEvery := flag.Int64("checkpoint-every", defaultCheckpointEvery, "Number of records written between two checkpoints")
+	resume := flag.Bool("resume", false, "Continue the conversion from the checkpoint")
+	ifNewer := flag.Bool("if-newer", false, "Skip the files whose JSON file is newer than them")
+	retries := flag.Int("retries", defaultRetries, "Times the download of a URL given as a file is tried again after a connection error or a 5xx response")
+	retryDelay := flag.Duration("retry-delay", defaultRetryDelay, "Wait before the first retry of a download, doubling with every retry")
+	force := flag.Bool("force", false, "Convert every file, even the ones skipped by --if-newer or with more columns than --max-columns")
+	appendRecords := flag.Bool("append", false, "Add the records to the JSON array of the existing JSON file, instead of writing a new one")
+	atomic := flag.Bool("atomic", false, "Write into a temporary file that replaces the JSON file once it's complete")
+	rootKey := flag.String("root-key", "", "Wrap the records into an object, under this key")
+	checksum := flag.String("checksum", "", "Write the checksum of the JSON file next to it. Only sha256 is allowed")
+	indent := flag.String("indent", defaultIndent, "Indentation of one level of the pretty output, as a number of spaces, tab, or the spaces and tabs themselves. 0 writes a compact record per line")
+	tabs := flag.Bool("tabs", false, "Indent the pretty output with tabs")
+	preview := flag.Int("preview", 0, "Print the first N records to the terminal instead of writing the JSON file")
+	previewConfirm := flag.Bool("preview-confirm", false, "With --preview, print the records to stderr and then write the JSON file, asking first when there's a terminal")
+	sampleEvery := flag.Int("sample-every", 1, "Only convert every Nth line of data")
+	sampleRate := flag.Float64("sample-rate", 1, "Keep each line of data with this probability, bigger than 0 and at most 1. The number of records kept is only approximate")
+	seed := flag.Int64("seed", 0, "Seed of the random sampling, to keep the same lines every time (random by default)")
+	limit := flag.Int("limit", 0, "Stop after converting N records (0 means no limit)")
+	dedupeKey := flag.String("dedupe-key", "", "Collapse the lines with the same value in this column into a single record")
+	dedupeKeep := flag.String("keep", "first", "Which of the lines with the same --dedupe-key is kept: first or last. Keeping the last one holds every record in memory")
+	sourceField := flag.String("add-source-field", "", "Add the name of the file of every record to it, under this key")
+	flag.Var(&concatValues, "concat", "Add a key joining the values of some columns to every record, as KEY=COLUMN+COLUMN (can be repeated)")
+	concatSep := flag.String("concat-sep", " ", "Text between the values joined by --concat")
+	exclude := flag.String("exclude", "", "Comma separated list of the columns left out of the records")
+	flag.Var(&geoValues, "geo", "Replace a latitude and a longitude column with a GeoJSON point, as LAT,LON>KEY (can be repeated)")
+	geoInvalid := flag.String("geo-invalid", "null", "What --geo does with the rows whose coordinates are empty or out of range: null writes a null point, and skip skips the row")
+	flag.Var(&decodeValues, "decode", "Decode the values of a column, as COLUMN:ENCODING with base64 or hex (can be repeated)")
+	decodeInvalid := flag.String("decode-invalid", "error", "What --decode does with the values that are not valid in their encoding: error skips the row, keep writes them as they are, and null writes null")
+	parseJSON := flag.String("parse-json", "", "Comma separated list of the columns holding JSON, which is written as nested values instead of as strings")
+	parseJSONInvalid := flag.String("parse-json-invalid", "error", "What --parse-json does with the values that are not valid JSON or go over its limits: error skips the row, keep writes them as strings, and null writes null")
+	parseJSONDepth := flag.Int("parse-json-max-depth", defaultParseJSONDepth, "Deepest nesting of the objects and arrays of the values of --parse-json. 0 means no limit")
+	parseJSONBytes := flag.Int("parse-json-max-bytes", defaultParseJSONBytes, "Size in bytes of the biggest value of --parse-json. 0 means no limit")
+	decodeBinary := flag.String("decode-binary", "keep", "What --decode does with the decoded values that are not UTF-8 text: keep writes them encoded like in the file, and replace writes the text with U+FFFD for the bytes that are not UTF-8")
+	splitRecords := flag.Int64("split-records", 0, "Write the records into files of at most N records each, numbered like data.0001.json (0 means a single file)")
+	manifest := flag.String("manifest", "", "File where --split-records lists the files written, with their number of records")
+	timing := flag.Bool("timing", false, "Log how long each conversion took, with its records and MB per second")
+	failOnEmpty := flag.Bool("fail-on-empty-output", false, "Exit with an error when a file has no records to write")
+	confirmAbove := flag.Int64("confirm-above", defaultConfirmAbove, "Ask before converting files bigger than this size in bytes (0 never asks)")
+	yes := flag.Bool("yes", false, "Convert without asking for confirmation")
+	keyPrefix := flag.String("key-prefix", "", "Text added before every key of the records. Options taking columns still use the headers as they are")
+	wrapKey := flag.String("wrap-key", "", "Nest every record inside an object, under this key")
+	keyOrder := flag.String("key-order", "alpha", "Order of the keys in the objects: alpha, csv for the order of the columns, or custom to follow --key-order-list")
+	keyOrderList := flag.String("key-order-list", "", "Comma separated list of the keys that go first with --key-order=custom. The rest follow in the order of the columns")
+	sortByValue := flag.String("sort-by", "", "Sort the records by a column, as COLUMN, COLUMN:asc or COLUMN:desc. Every record is kept in memory until the file is read")
+	valueCaseList := flag.String("value-case", "", "Comma separated list of COLUMN:case, with case being lower, upper or title, changing the case of the values before they are checked")
+	normalize := flag.String("normalize", "", "Unicode normalization applied to the headers and values before anything else, nfc or nfkc (off by default)")
+	normalizeKeys := flag.Bool("normalize-keys", false, "Replace the characters of the headers that are not letters or digits with underscores, like Total_USD for \"Total (USD)\". Options taking columns use these names")
+	flag.Var(&renameRegexes, "rename-regex", "Rename the headers matching a regular expression, as PATTERN=replacement, like ^col_= to strip a prefix. Can be repeated, and they apply in order")
+	fuzzyColumns := flag.Bool("fuzzy-columns", false, "Match the columns given to the options with the headers regardless of case, spaces, dashes and underscores, like email for \"E-mail \"")
+	emitSchema := flag.String("emit-schema", "", "Write a JSON Schema inferred from the values of the columns into this file")
+	schemaPath := flag.String("schema", "", "JSON Schema file the records are validated against. Records that don't match it are reported")
+	dryValidate := flag.Bool("dry-validate", false, "Only validate the records against --schema, without writing any JSON, and fail if some don't match")
+	newlineHandling := flag.String("newline-handling", "keep", "What to do with the line breaks inside values: keep, escape as \\n, replace by a space, or strip")
+	newlineInField := flag.String("newline-in-field", "", "Same as --newline-handling, taking keep, escape or replace by a space")
+	reverse := flag.Bool("reverse", false, "Convert JSON files, each an array of flat objects or NDJSON, back into CSV")
+	lineEnding := flag.String("line-ending", "lf", "Line break of the files written: lf, crlf, or native to use the one of the operating system")
+	quoteMode := flag.String("quote-mode", "minimal", "Fields quoted in the CSV written by --reverse: minimal, all or nonnumeric")
+	headerList := flag.String("headers", "", "Comma separated list of the headers, for files without a line of headers")
+	fixedWidths := flag.String("fixed-widths", "", "Comma separated list of the widths of the columns of a fixed-width file, which is read instead of a CSV file")
+	widthSpec := flag.String("fixed-widths-file", "", "File with the columns of a fixed-width file, each on a line with its name and its width")
+	widthUnit := flag.String("fixed-width-unit", "rune", "What the fixed widths count: rune or byte")
+	trailingSeparator := flag.Bool("tolerate-trailing-separator", false, "Drop the last field of the rows with one more field than the headers when it's empty, instead of handling them with --ragged")
+	allowTrailingDelimiter := flag.Bool("allow-trailing-delimiter", false, "Same as --tolerate-trailing-separator")
+	skipBlankRows := flag.Bool("skip-blank-rows", true, "Skip the rows without any value, like the lines of separators only. Empty lines are always skipped")
+	skipAllEmptyRows := flag.Bool("skip-all-empty-rows", false, "Also skip the rows whose values are all empty once their spaces are trimmed")
+	errorFile := flag.String("error-file", "", "File where the lines skipped by --ragged are written as they are, each after a comment with its line and the reason")
+	ragged := flag.String("ragged", "error", "What to do with the lines that don't have as many fields as the headers: error, skip or pad the missing ones")
+	fieldsPerRecord := flag.Int("csv-fields-per-record", 0, "Advanced: number of fields every line must have, or 0 for the number of fields of the first line")
+	lazyQuotes := flag.Bool("csv-lazy-quotes", false, "Advanced: allow quotes inside unquoted fields, and quotes that are not doubled inside quoted fields")
+	trimLeadingSpace := flag.Bool("csv-trim-leading-space", false, "Advanced: ignore the spaces at the start of the fields")
+	commentValue := flag.String("csv-comment", "", "Advanced: character starting the lines that are comments, which are skipped")
+	reuseRecord := flag.Bool("csv-reuse-record", true, "Advanced: reuse the memory of the last line read for the next one")
+	noSniff := flag.Bool("no-sniff", false, "Read the files even when their first bytes say they are not text, like a ZIP archive or a PDF")
+	flag.Var(&defaultValues, "default", "Value written for the empty or missing cells of a column, as COLUMN:value (can be repeated)")
+	flag.Var(&redactColumns, "redact", "Replace the values of a column with a mask, as COLUMN or as COLUMN:N to keep the last N characters (can be repeated)")
+	redactMask := flag.String("redact-mask", "***", "Mask used by --redact")
+	flag.Var(&hashColumns, "hash", "Replace the values of a column with their SHA-256, so they can still be joined (can be repeated)")
+	hashSalt := flag.String("hash-salt", "", "Salt added before the values hashed by --hash")
+	profile := flag.Bool("profile", false, "Write statistics about every column next to the JSON file")
+	profileCap := flag.Int("profile-distinct-cap", defaultProfileDistinctCap, "Maximum number of distinct values counted per column by --profile")
+	diffAgainst := flag.String("diff-against", "", "Compare the records with the ones of a previous JSON file, writing the added, removed and changed ones next to the JSON file")
+	keyColumn := flag.String("key-column", "", "Field that identifies the records compared by --diff-against")
+	flag.Var(&uniqueColumns, "unique-check", "Report the values repeated in a column (can be repeated)")
+	flag.Var(&uniqueKey, "unique-key", "Report the combinations of values repeated in the columns of a composite key, giving one of its columns each time (can be repeated)")
+	uniqueStrict := flag.Bool("unique-strict", false, "Fail when --unique-check or --unique-key find repeated values")
+	uniqueSkipEmpty := flag.Bool("unique-skip-empty", false, "Don't check the lines where a value of --unique-check or --unique-key is empty, warning about them instead")
+	template := flag.Bool("template", false, "Only read the headers, and write a single object with an empty value for each of them")
+	nullValue := flag.String("null-value", "", "Write the cells with this value as null")
+	forceExtension := flag.Bool("force-extension", false, "Convert the files whatever their extension is")
+	extractColumn := flag.String("extract-column", "", "Write the values of this column as lines of text instead of JSON, like cut does")
+	scalar := flag.Bool("scalar", false, "Write the values of a single column as an array of values instead of objects")
+	allowEmpty := flag.Bool("allow-empty", false, "Convert empty files into an empty array instead of failing")
+	typed := flag.Bool("typed", false, "Write the values that look like numbers or booleans without quotes")
+	typeMapList := flag.String("type-map", "", "Comma separated list of COLUMN:type, with type being "+typeNames()+". Takes precedence over --typed")
+	outputTemplate := flag.String("output-template", "", "Name of the files written, like {name}_{date}.json, with {name} the name of the CSV file without its extension, {date} the date of the run, {sep} the separator and {n} the number of the file of --split-records")
+	outputDir := flag.String("output-dir", "", "Directory where the files are written, keeping the directories of the paths of the CSV files under it")
+	outputModeValue := flag.String("output-mode", "", "Permissions of the files written, in octal like 0640. By default they go by the umask, like with any other file")
+	createDirs := flag.Bool("create-dirs", false, "Create the directories of the files written when they don't exist")
+	outputSuffix := flag.String("output-suffix", ".json", "Replaces the extension of the CSV files to name the files written, like .ndjson")
+	bigNumberColumns := flag.String("big-number-columns", "", "Comma separated list of columns always written as strings, even with --typed, like ids that JSON readers would round")
+	boolTrue := flag.String("bool-true", "", "Comma separated list of values that --typed writes as true, like yes,Y. Case doesn't matter")
+	boolFalse := flag.String("bool-false", "", "Comma separated list of values that --typed writes as false, like no,N. Case doesn't matter")
+	percentMode := flag.String("percent-mode", "fraction", "How the humannumber type of --type-map writes a percent like 12%: fraction for 0.12, or raw for 12")
+	decimalComma := flag.Bool("decimal-comma", false, "Read the numbers of --typed and --type-map with a decimal comma and dots between the thousands, like 1.234,56")
+	currencySymbolList := flag.String("currency-symbols", "", "Comma separated list of SYMBOL:CODE for the currency type of --type-map, like kr:SEK, besides $, €, £, ¥ and ₹")
+	currencyAsNumber := flag.Bool("currency-as-number", false, "Write the values of the currency type as their amount only, instead of an object with the amount and the currency")
+	floatFormatValue := flag.String("float-format", "", "Format of the numbers with a fraction or an exponent written by --typed, as %.2f, %e, %g or fixed:N. By default they are written as they are in the file")
+	stringify := flag.Bool("stringify", false, "Write every value as a string, even with --typed. Values are still checked against --type-map")
+	extractMeta := flag.Bool("extract-meta", false, "Add the \"# key: value\" lines before the headers as a meta object (needs --root-key)")
+
+	defineShortFlags(flag.CommandLine)
+
+	// The -@ argument reads the locations of the files from stdin. The flag package would take it as an unknown option
+	var args []string
+	filesFromStdin := false
+	for i, arg := range os.Args[1:] {
+		if arg == "--" {
+			args = append(args, os.Args[i+1:]...)
+			break
+		}
+		if arg == "-@" {
+			filesFromStdin = true
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	// This will parse all the arguments from the terminal. The arguments that are not flag options are the
+	// locations of the CSV files to convert, and the options can come before or after them
+	fileLocations, err := parseInterleaved(flag.CommandLine, args)
+	if err != nil {
+		return inputFile{}, err
+	}
+
+	// We need to know if the separator was explicitly given, because otherwise the file can declare its own
+	separatorGiven, indentGiven, nullValueGiven, seedGiven, newlineHandlingGiven := false, false, false, false, false
+	outputSuffixGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "output-suffix":
+			outputSuffixGiven = true
+		case "separator", shortFlags["separator"]:
+			separatorGiven = true
+		case "indent":
+			indentGiven = true
+		case "null-value":
+			nullValueGiven = true
+		case "seed":
+			seedGiven = true
+		case "newline-handling":
+			newlineHandlingGiven = true
+		}
+	})
+
+	if filesFromStdin {
+		stdinLocations, err := readFileList(os.Stdin)
+		if err != nil {
+			return inputFile{}, err
+		}
+		if len(stdinLocations) == 0 && len(fileLocations) == 0 {
+			return inputFile{}, errors.New("No filepaths were read from stdin")
+		}
+		fileLocations = append(fileLocations, stdinLocations...)
+	}
+	if len(fileLocations) == 0 {
+		return inputFile{}, errors.New("A filepath arguement is required")
+	}
+
+	// A separator of letters or digits only is taken as the misspelled name of one
+	if _, named := separatorNames[*separator]; !named {
+		if strings.TrimFunc(*separator, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) == "" {
+			return inputFile{}, fmt.Errorf("Unknown separator %s. Use comma, semicolon, tab or the text between the fields", *separator)
+		}
+		if strings.ContainsAny(*separator, "\"\r\n") || !utf8.ValidString(*separator) {
+			return inputFile{}, errors.New("The separator can't have quotes or line breaks")
+		}
+	}
+
+	if *batchSize < 1 {
+		return inputFile{}, errors.New("The batch size must be at least 1")
+	}
+	if *retries < 0 || *retryDelay < 0 {
+		return inputFile{}, errors.New("The retries and the retry delay can't be negative")
+	}
+
+	level, err := parseLogLevel(*logLevelName)
+	if err != nil {
+		return inputFile{}, err
+	}
+
+	if !(*logFormat == "text" || *logFormat == "json") {
+		return inputFile{}, errors.New("Only text or json log formats are allowed")
+	}
+
+	if *writeBuffer < 1 {
+		return inputFile{}, errors.New("The write buffer size must be at least 1 byte")
+	}
+
+	if *readBuffer < 1 {
+		return inputFile{}, errors.New("The read buffer size must be at least 1 byte")
+	}
+
+	if *maxFieldBytes < 0 {
+		return inputFile{}, errors.New("The maximum field size can't be negative")
+	}
+
+	if *maxColumns < 0 {
+		return inputFile{}, errors.New("The maximum number of columns can't be negative")
+	}
+
+	var rangeFilters []rangeFilter
+	for _, value := range filterRanges {
+		filter, err := parseRangeFilter(value)
+		if err != nil {
+			return inputFile{}, err
+		}
+		rangeFilters = append(rangeFilters, filter)
+	}
+
+	valueCases, err := parseValueCases(*valueCaseList)
+	if err != nil {
+		return inputFile{}, err
+	}
+
+	if !(*normalize == "" || *normalize == "nfc" || *normalize == "nfkc") {
+		return inputFile{}, errors.New("Only nfc or nfkc normalizations are allowed")
+	}
+
+	var schema *jsonSchema
+	if *schemaPath != "" {
+		if schema, err = loadSchema(*schemaPath); err != nil {
+			return inputFile{}, err
+		}
+	}
+
+	if *dryValidate && schema == nil {
+		return inputFile{}, errors.New("The dry-validate option needs a schema")
+	}
+
+	// A dry validation doesn't write anything, so there's nothing to preview, resume or write statistics next to
+	if *dryValidate && (*preview > 0 || *checkpointPath != "" || *profile) {
+		return inputFile{}, errors.New("The dry-validate option can't be used with the preview, checkpoint or profile options")
+	}
+
+	if *newlineInField != "" {
+		if newlineHandlingGiven {
+			return inputFile{}, errors.New("The newline-handling and newline-in-field options can't be used together")
+		}
+		switch *newlineInField {
+		case "keep", "escape":
+			*newlineHandling = *newlineInField
+		case "replace":
+			*newlineHandling = "space"
+		default:
+			return inputFile{}, errors.New("Only keep, escape or replace are allowed for the newlines in fields")
+		}
+	}
+
+	if !(*newlineHandling == "keep" || *newlineHandling == "escape" || *newlineHandling == "space" || *newlineHandling == "strip") {
+		return inputFile{}, errors.New("Only keep, escape, space or strip are allowed for the newline handling")
+	}
+
+	if !(*ragged == "error" || *ragged == "skip" || *ragged == "pad") {
+		return inputFile{}, errors.New("Only error, skip or pad are allowed for ragged lines")
+	}
+
+	if !(*quoteMode == "minimal" || *quoteMode == "all" || *quoteMode == "nonnumeric") {
+		return inputFile{}, errors.New("Only minimal, all or nonnumeric quote modes are allowed")
+	}
+
+	lineBreak, known := lineEndings[*lineEnding]
+	if *lineEnding == "native" {
+		lineBreak, known = "\n", true
+		if runtime.GOOS == "windows" {
+			lineBreak = "\r\n"
+		}
+	}
+	if !known {
+		return inputFile{}, errors.New("Only lf, crlf or native line endings are allowed")
+	}
+
+	if *timing && *reverse {
+		return inputFile{}, errors.New("The timing option can't be used with --reverse")
+	}
+
+	if *quoteMode != "minimal" && !*reverse {
+		return inputFile{}, errors.New("The quote-mode option needs --reverse")
+	}
+
+	if *reverse && (*checkpointPath != "" || *dryValidate) {
+		return inputFile{}, errors.New("The reverse option can't be used with --checkpoint or --dry-validate")
+	}
+
+	// The files written by --reverse are CSV files
+	if *reverse && !outputSuffixGiven {
+		*outputSuffix = ".csv"
+	}
+
+	// The values of --extract-column are written as they are, one per line, so none of the options shaping
+	// the JSON apply to them
+	if *extractColumn != "" {
+		if *rootKey != "" || *wrapKey != "" || *template || *scalar || *typed || *typeMapList != "" || *stringify || *sourceField != "" || len(concatValues) > 0 || *exclude != "" || len(geoValues) > 0 || *parseJSON != "" || *appendRecords || *diffAgainst != "" || *reverse {
+			return inputFile{}, errors.New("The extract-column option can't be used with the root-key, wrap-key, template, scalar, typed, type-map, stringify, add-source-field, concat, exclude, geo, parse-json, append, diff-against or reverse options")
+		}
+		if !outputSuffixGiven {
+			*outputSuffix = ".txt"
+		}
+	}
+
+	// The names of the columns of a spec file are headers too
+	givenHeaders := splitColumnList(*headerList, ",")
+	if *headerList != "" && givenHeaders == nil {
+		return inputFile{}, errors.New("The headers option needs at least one header")
+	}
+
+	if *fixedWidths != "" && *widthSpec != "" {
+		return inputFile{}, errors.New("The fixed-widths and fixed-widths-file options can't be used together")
+	}
+
+	widths, err := parseWidths(*fixedWidths)
+	if err != nil {
+		return inputFile{}, err
+	}
+	if *widthSpec != "" {
+		if givenHeaders != nil {
+			return inputFile{}, errors.New("The headers option can't be used with fixed-widths-file, which names the columns")
+		}
+		content, err := os.ReadFile(*widthSpec)
+		if err != nil {
+			return inputFile{}, fmt.Errorf("Spec file %s can't be read: %v", *widthSpec, err)
+		}
+		if givenHeaders, widths, err = parseWidthSpec(string(content)); err != nil {
+			return inputFile{}, fmt.Errorf("Spec file %s is not valid: %v", *widthSpec, err)
+		}
+	}
+
+	if widths != nil && givenHeaders != nil && len(givenHeaders) != len(widths) {
+		return inputFile{}, fmt.Errorf("There are %d headers for %d fixed widths", len(givenHeaders), len(widths))
+	}
+
+	if widths != nil && separatorGiven {
+		return inputFile{}, errors.New("The separator option can't be used with fixed widths")
+	}
+
+	if !(*widthUnit == "rune" || *widthUnit == "byte") {
+		return inputFile{}, errors.New("Only rune or byte fixed width units are allowed")
+	}
+
+	if *widthUnit != "rune" && widths == nil {
+		return inputFile{}, errors.New("The fixed-width-unit option needs fixed widths")
+	}
+
+	// The options of the CSV reader only work with encoding/csv, and they must make sense with the separator
+	comment, err := parseReaderComment(*commentValue)
+	if err != nil {
+		return inputFile{}, err
+	}
+	given := inputFile{separator: *separator, separatorGiven: true}
+	if (*lazyQuotes || *trimLeadingSpace || comment != 0) && (widths != nil || multiSeparator(given) != "") {
+		return inputFile{}, errors.New("The csv-lazy-quotes, csv-trim-leading-space and csv-comment options only work with a separator of a single character")
+	}
+	if *fieldsPerRecord < 0 {
+		return inputFile{}, errors.New("The number of fields per record can't be negative. Use --ragged for lines with any number of fields")
+	}
+	if *fieldsPerRecord > 0 && *ragged != "error" {
+		return inputFile{}, errors.New("The csv-fields-per-record option can't be used with --ragged=skip or --ragged=pad")
+	}
+	if columns := len(givenHeaders) + len(widths); *fieldsPerRecord > 0 && columns > 0 && columns != *fieldsPerRecord {
+		return inputFile{}, fmt.Errorf("There are %d fields per record for %d columns", *fieldsPerRecord, columns)
+	}
+	if separatorGiven && widths == nil && multiSeparator(given) == "" {
+		if err := checkReaderOptions(*trimLeadingSpace, comment, fileSeparator(given, 0, false)); err != nil {
+			return inputFile{}, err
+		}
+	}
+
+	// Only the headers read from the file tell that the separator is wrong
+	fallbacks := splitColumnList(*separatorFallback, ",")
+	if fallbacks != nil && (widths != nil || givenHeaders != nil || *reverse) {
+		return inputFile{}, errors.New("The separator-fallback option can't be used with fixed widths, --headers or --reverse")
+	}
+	for _, fallback := range fallbacks {
+		candidate := inputFile{separator: fallback, separatorGiven: true}
+		if (*lazyQuotes || *trimLeadingSpace || comment != 0) && multiSeparator(candidate) != "" {
+			return inputFile{}, fmt.Errorf("The fallback separator %q has more than one character, so it can't be used with the csv-lazy-quotes, csv-trim-leading-space and csv-comment options", fallback)
+		}
+		if multiSeparator(candidate) == "" {
+			if err := checkReaderOptions(*trimLeadingSpace, comment, fileSeparator(candidate, 0, false)); err != nil {
+				return inputFile{}, err
+			}
+		}
+	}
+
+	if *errorFile != "" && *ragged == "error" {
+		return inputFile{}, errors.New("The error-file option needs --ragged=skip or --ragged=pad, otherwise no line is skipped")
+	}
+
+	if !(*keyOrder == "alpha" || *keyOrder == "csv" || *keyOrder == "custom") {
+		return inputFile{}, errors.New("Only alpha, csv or custom key orders are allowed")
+	}
+
+	orderedKeys := splitColumnList(*keyOrderList, ",")
+	if (*keyOrder == "custom") != (len(orderedKeys) > 0) {
+		return inputFile{}, errors.New("The custom key order needs a key order list, which is only used by it")
+	}
+
+	var sortBy sortOrder
+	if *sortByValue != "" {
+		if sortBy, err = parseSortOrder(*sortByValue); err != nil {
+			return inputFile{}, err
+		}
+	}
+
+	// Sorted records are only written once all of them are read, so there's nothing to checkpoint or preview along the way
+	if sortBy.column != "" && (*checkpointPath != "" || *preview > 0) {
+		return inputFile{}, errors.New("The sort-by option can't be used with the checkpoint or preview options")
+	}
+
+	var defaults []columnDefault
+	for _, value := range defaultValues {
+		columnDefault, err := parseColumnDefault(value)
+		if err != nil {
+			return inputFile{}, err
+		}
+		defaults = append(defaults, columnDefault)
+	}
+
+	// The keys of the concatenations go after the columns, so they can't be the same as one another
+	// or as the source field
+	var concatFields []concatField
+	for _, value := range concatValues {
+		field, err := parseConcatField(value)
+		if err != nil {
+			return inputFile{}, err
+		}
+		if field.key == *sourceField {
+			return inputFile{}, fmt.Errorf("Key %s of the concatenation is the source field too", field.key)
+		}
+		for _, previous := range concatFields {
+			if previous.key == field.key {
+				return inputFile{}, fmt.Errorf("Key %s is given to more than one concatenation", field.key)
+			}
+		}
+		concatFields = append(concatFields, field)
+	}
+	excluded := splitColumnList(*exclude, ",")
+
+	var headerRenames []headerRename
+	for _, value := range renameRegexes {
+		rename, err := parseHeaderRename(value)
+		if err != nil {
+			return inputFile{}, err
+		}
+		headerRenames = append(headerRenames, rename)
+	}
+
+	var redactions []redaction
+	for _, value := range redactColumns {
+		redaction, err := parseRedaction(value)
+		if err != nil {
+			return inputFile{}, err
+		}
+		redactions = append(redactions, redaction)
+	}
+
+	if *columns != "" && *columnsFile != "" {
+		return inputFile{}, errors.New("The columns and columns-file options can't be used together")
+	}
+
+	includedColumns := splitColumnList(*columns, ",")
+	if *columnsFile != "" {
+		content, err := os.ReadFile(*columnsFile)
+		if err != nil {
+			return inputFile{}, fmt.Errorf("Columns file %s can't be read: %v", *columnsFile, err)
+		}
+		includedColumns = splitColumnList(string(content), "\n")
+	}
+
+	// The properties of a schema are the columns to include, but only the ones in the file
+	if *columnsFromSchema != "" {
+		if *columns != "" || *columnsFile != "" {
+			return inputFile{}, errors.New("The columns-from-schema option can't be used with columns or columns-file")
+		}
+		schema, err := readSchema(*columnsFromSchema)
+		if err != nil {
+			return inputFile{}, err
+		}
+		if includedColumns = schema.propertyNames(); includedColumns == nil {
+			return inputFile{}, fmt.Errorf("Schema %s has no properties to take the columns from", *columnsFromSchema)
+		}
+	}
+
+	// With --extract-column, that column is the only one included
+	if *extractColumn != "" {
+		if *columns != "" || *columnsFile != "" || *columnsFromSchema != "" {
+			return inputFile{}, errors.New("The extract-column option can't be used with columns, columns-file or columns-from-schema")
+		}
+		includedColumns = []string{*extractColumn}
+	}
+
+	if *checkpointEvery < 1 {
+		return inputFile{}, errors.New("The checkpoint interval must be at least 1 record")
+	}
+
+	if *flushEvery < 0 {
+		return inputFile{}, errors.New("The flush interval can't be negative")
+	}
+
+	if *resume && *checkpointPath == "" {
+		return inputFile{}, errors.New("The resume option needs a checkpoint file")
+	}
+
+	if *checkpointPath != "" && len(fileLocations) > 1 {
+		return inputFile{}, errors.New("A checkpoint can only be used when converting a single file")
+	}
+
+	// A checkpoint resumes the output file itself, which an atomic write only creates at the very end
+	if *checkpointPath != "" && *atomic {
+		return inputFile{}, errors.New("The atomic and checkpoint options can't be used together")
+	}
+
+	// Without an object around the records there is nowhere to put the metadata
+	if *extractMeta && *rootKey == "" {
+		return inputFile{}, errors.New("The extract-meta option needs a root key")
+	}
+
+	if *extractMeta && *rootKey == "meta" {
+		return inputFile{}, errors.New("The root key can't be meta when extracting the metadata")
+	}
+
+	if !(*checksum == "" || *checksum == "sha256") {
+		return inputFile{}, errors.New("Only sha256 checksums are allowed")
+	}
+
+	if *indent, err = parseIndent(*indent); err != nil {
+		return inputFile{}, err
+	}
+
+	if *tabs {
+		if indentGiven {
+			return inputFile{}, errors.New("The indent and tabs options can't be used together")
+		}
+		*indent = "\t"
+	}
+
+	if *preview < 0 {
+		return inputFile{}, errors.New("The number of records to preview can't be negative")
+	}
+
+	if *sampleEvery < 1 {
+		return inputFile{}, errors.New("The sample interval must be at least 1")
+	}
+
+	// A rate of 0 would keep nothing at all
+	if !(*sampleRate > 0 && *sampleRate <= 1) {
+		return inputFile{}, errors.New("The sample rate must be bigger than 0 and at most 1")
+	}
+
+	// Without a seed every run keeps different lines. The seed is logged, so a run can still be repeated
+	if !seedGiven && *sampleRate < 1 {
+		*seed = time.Now().UnixNano()
+	}
+
+	if *limit < 0 {
+		return inputFile{}, errors.New("The limit can't be negative")
+	}
+
+	// Resuming starts counting the lines again from the checkpoint, so the sampled lines and the limit would be off
+	if (*sampleEvery > 1 || *sampleRate < 1 || *limit > 0) && *checkpointPath != "" {
+		return inputFile{}, errors.New("The sample-every, sample-rate and limit options can't be used with the checkpoint option")
+	}
+
+	if !(*dedupeKeep == "first" || *dedupeKeep == "last") {
+		return inputFile{}, errors.New("Only the first or the last duplicate can be kept")
+	}
+	if *dedupeKeep != "first" && *dedupeKey == "" {
+		return inputFile{}, errors.New("The keep option needs --dedupe-key")
+	}
+	// The keys seen before a checkpoint are not saved, and the last duplicates are only known once the file is read,
+	// after the records a limit or a preview stops at, and after the profile and the schema have seen the records
+	if *dedupeKey != "" && *checkpointPath != "" {
+		return inputFile{}, errors.New("The dedupe-key option can't be used with the checkpoint option")
+	}
+	if *dedupeKeep == "last" && (*limit > 0 || *preview > 0 || *profile || *schemaPath != "" || *emitSchema != "") {
+		return inputFile{}, errors.New("The keep=last option can't be used with the limit, preview, profile, schema or emit-schema options")
+	}
+
+	// A preview doesn't write the JSON file, so there would be nothing to resume
+	if *recordPerLine && (*pretty || *preview > 0) {
+		return inputFile{}, errors.New("The record-per-line option is only for the compact output, so it can't be used with the pretty or preview options")
+	}
+
+	if *preview > 0 && *checkpointPath != "" {
+		return inputFile{}, errors.New("The preview and checkpoint options can't be used together")
+	}
+
+	// The preview is taken from the records as they are converted, so it has the same limits as any other preview
+	if *previewConfirm && (*preview == 0 || *reverse) {
+		return inputFile{}, errors.New("The preview-confirm option needs --preview, and it can't be used with --reverse")
+	}
+
+	if *confirmAbove < 0 {
+		return inputFile{}, errors.New("The confirmation size can't be negative")
+	}
+
+	if *profileCap < 1 {
+		return inputFile{}, errors.New("The profile distinct cap must be at least 1")
+	}
+
+	// The statistics need every record, and these options only go through some of them
+	if *profile && (*preview > 0 || *resume) {
+		return inputFile{}, errors.New("The profile option can't be used with the preview or resume options")
+	}
+	// So does the inferred schema, which is the one of a single file
+	if *emitSchema != "" && (*preview > 0 || *resume || *reverse || *extractColumn != "" || len(fileLocations) > 1 || filesFromStdin) {
+		return inputFile{}, errors.New("The emit-schema option can only be used with a single file, and not with the preview, resume, reverse or extract-column options")
+	}
+
+	// The names are taken as they are, so a header with a comma can be checked too
+	for _, column := range append(append([]string(nil), uniqueColumns...), uniqueKey...) {
+		if column == "" {
+			return inputFile{}, errors.New("A unique check needs a column")
+		}
+	}
+
+	if (*uniqueStrict || *uniqueSkipEmpty) && len(uniqueColumns) == 0 && len(uniqueKey) == 0 {
+		return inputFile{}, errors.New("The unique-strict and unique-skip-empty options need a column to check")
+	}
+
+	// A template is a single object, so it can't be wrapped or previewed as an array of records
+	if *template && (*rootKey != "" || *preview > 0 || *checkpointPath != "") {
+		return inputFile{}, errors.New("The template option can't be used with the root-key, preview or checkpoint options")
+	}
+
+	// The files of --split-records are complete JSON files, so they can't be resumed into, and they are not
+	// the JSON file that a preview prints, a dry validation throws away or --if-newer compares with
+	if *splitRecords < 0 {
+		return inputFile{}, errors.New("The number of records per file can't be negative")
+	}
+	if *splitRecords > 0 && (*checkpointPath != "" || *preview > 0 || *dryValidate || *template || *ifNewer) {
+		return inputFile{}, errors.New("The split-records option can't be used with the checkpoint, preview, dry-validate, template or if-newer options")
+	}
+	if *manifest != "" && *splitRecords == 0 {
+		return inputFile{}, errors.New("The manifest option needs --split-records")
+	}
+	if *manifest != "" && len(fileLocations) > 1 {
+		return inputFile{}, errors.New("The manifest option can only be used with a single file")
+	}
+
+	if *scalar && *template {
+		return inputFile{}, errors.New("The scalar and template options can't be used together")
+	}
+
+	if *outputSuffix == "" || strings.ContainsAny(*outputSuffix, `/\`) {
+		return inputFile{}, errors.New("The output suffix must not be empty nor have path separators")
+	}
+
+	typeMap, err := parseTypeMap(*typeMapList)
+	if err != nil {
+		return inputFile{}, err
+	}
+
+	// Readers of JSON usually turn numbers into float64, which only keeps integers up to 2^53 exact.
+	// The columns of --big-number-columns are strings then, so their digits survive
+	for _, column := range splitColumnList(*bigNumberColumns, ",") {
+		if t, mapped := typeMap[column]; mapped && t != typeString {
+			return inputFile{}, fmt.Errorf("Column %s can't be a big number and a %s", column, t)
+		}
+		if typeMap == nil {
+			typeMap = make(map[string]valueType)
+		}
+		typeMap[column] = typeString
+	}
+
+	// The points are written by the point type, so their keys can't have another type
+	var geoPoints []geoPoint
+	for _, value := range geoValues {
+		point, err := parseGeoPoint(value)
+		if err != nil {
+			return inputFile{}, err
+		}
+		if t, mapped := typeMap[point.key]; mapped && t != typePoint {
+			return inputFile{}, fmt.Errorf("Key %s of the point can't be a %s", point.key, t)
+		}
+		if typeMap == nil {
+			typeMap = make(map[string]valueType)
+		}
+		typeMap[point.key] = typePoint
+		geoPoints = append(geoPoints, point)
+	}
+	if *geoInvalid != "null" && *geoInvalid != "skip" {
+		return inputFile{}, fmt.Errorf("Unknown geo-invalid policy %s. Use null or skip", *geoInvalid)
+	}
+
+	var decodings []columnDecoding
+	for _, value := range decodeValues {
+		decoding, err := parseColumnDecoding(value)
+		if err != nil {
+			return inputFile{}, err
+		}
+		decodings = append(decodings, decoding)
+	}
+	if *decodeInvalid != "error" && *decodeInvalid != "keep" && *decodeInvalid != "null" {
+		return inputFile{}, fmt.Errorf("Unknown decode-invalid policy %s. Use error, keep or null", *decodeInvalid)
+	}
+	if *decodeBinary != "keep" && *decodeBinary != "replace" {
+		return inputFile{}, fmt.Errorf("Unknown decode-binary policy %s. Use keep or replace", *decodeBinary)
+	}
+	if len(decodings) > 0 && *reverse {
+		return inputFile{}, errors.New("The decode option can't be used with --reverse")
+	}
+
+	// The JSON values are written by the json type, so their columns can't have another type
+	jsonColumns := splitColumnList(*parseJSON, ",")
+	for _, column := range jsonColumns {
+		if t, mapped := typeMap[column]; mapped && t != typeJSON {
+			return inputFile{}, fmt.Errorf("Column %s of the JSON values can't be a %s", column, t)
+		}
+		if typeMap == nil {
+			typeMap = make(map[string]valueType)
+		}
+		typeMap[column] = typeJSON
+	}
+	if *parseJSONInvalid != "error" && *parseJSONInvalid != "keep" && *parseJSONInvalid != "null" {
+		return inputFile{}, fmt.Errorf("Unknown parse-json-invalid policy %s. Use error, keep or null", *parseJSONInvalid)
+	}
+	if *parseJSONDepth < 0 || *parseJSONBytes < 0 {
+		return inputFile{}, errors.New("The limits of the JSON values can't be negative")
+	}
+	if len(jsonColumns) > 0 && (*stringify || *reverse) {
+		return inputFile{}, errors.New("The parse-json option can't be used with the stringify or reverse options")
+	}
+
+	booleanTokens, err := parseBooleanTokens(*boolTrue, *boolFalse)
+	if err != nil {
+		return inputFile{}, err
+	}
+	if booleanTokens != nil && !*typed && *typeMapList == "" {
+		return inputFile{}, errors.New("The bool-true and bool-false options need --typed or --type-map")
+	}
+
+	if *percentMode != "fraction" && *percentMode != "raw" {
+		return inputFile{}, fmt.Errorf("Unknown percent mode %s. Use fraction or raw", *percentMode)
+	}
+
+	floatFormat, err := parseFloatFormat(*floatFormatValue)
+	if err != nil {
+		return inputFile{}, err
+	}
+	if floatFormat.verb != 0 && !*typed && *typeMapList == "" {
+		return inputFile{}, errors.New("The float-format option needs --typed or --type-map")
+	}
+	if *decimalComma && !*typed && *typeMapList == "" {
+		return inputFile{}, errors.New("The decimal-comma option needs --typed or --type-map")
+	}
+
+	currencySymbols, err := parseCurrencySymbols(*currencySymbolList)
+	if err != nil {
+		return inputFile{}, err
+	}
+
+	// Only a plain array can be continued, and the checkpoints and the split files have their own idea of what
+	// the output looks like
+	if *appendRecords && (*rootKey != "" || *template || *checkpointPath != "" || *splitRecords > 0 || *preview > 0 || *dryValidate || *reverse) {
+		return inputFile{}, errors.New("The append option can't be used with the root-key, template, checkpoint, split-records, preview, dry-validate or reverse options")
+	}
+
+	var runDate string
+	if *outputTemplate != "" {
+		if err := checkOutputTemplate(*outputTemplate, *splitRecords > 0); err != nil {
+			return inputFile{}, err
+		}
+		runDate = time.Now().Format("2006-01-02")
+	}
+
+	outputMode, err := parseFileMode(*outputModeValue)
+	if err != nil {
+		return inputFile{}, err
+	}
+
+	if *align && !*pretty && (*preview == 0 || *previewConfirm) {
+		return inputFile{}, errors.New("The align option needs --pretty")
+	}
+
+	// Without indentation, the pretty output is written as the compact one, with a record per line to keep it readable
+	isPretty := *pretty || *preview > 0 && !*previewConfirm
+	if isPretty && *indent == "" {
+		isPretty, *recordPerLine = false, true
+	}
+
+	var resumeFrom checkpoint
+	if *resume {
+		if resumeFrom, err = loadCheckpoint(*checkpointPath); err != nil {
+			return inputFile{}, err
+		}
+	}
+
+	fileData := inputFile{
+		filepath:          fileLocations[0],
+		separator:         *separator,
+		pretty:            isPretty,
+		align:             *align,
+		batchSize:         *batchSize,
+		separatorGiven:    separatorGiven,
+		separatorFallback: fallbacks,
+		logLevel:          level,
+		logFormat:         *logFormat,
+		writeBuffer:       *writeBuffer,
+		readBuffer:        *readBuffer,
+		maxFieldBytes:     *maxFieldBytes,
+		maxColumns:        *maxColumns,
+		rangeFilters:      rangeFilters,
+		columns:           includedColumns,
+		columnsFromSchema: *columnsFromSchema != "",
+		checkpoint:        *checkpointPath,
+		checkpointEvery:   *checkpointEvery,
+		flushEvery:        *flushEvery,
+		resume:            resumeFrom,
+		filepaths:         fileLocations,
+		filesFromStdin:    filesFromStdin,
+		retries:           *retries,
+		retryDelay:        *retryDelay,
+		ifNewer:           *ifNewer,
+		force:             *force,
+		atomic:            *atomic,
+		appendRecords:     *appendRecords,
+		rootKey:           *rootKey,
+		extractMeta:       *extractMeta,
+		extractColumn:     *extractColumn,
+		checksum:          *checksum,
+		indent:            *indent,
+		preview:           *preview,
+		confirmAbove:      *confirmAbove,
+		yes:               *yes,
+		redactions:        redactions,
+		redactMask:        *redactMask,
+		hashColumns:       hashColumns,
+		hashSalt:          *hashSalt,
+		profile:           *profile,
+		diffAgainst:       *diffAgainst,
+		keyColumn:         *keyColumn,
+		profileCap:        *profileCap,
+		emitSchema:        *emitSchema,
+		uniqueColumns:     uniqueColumns,
+		uniqueKey:         uniqueKey,
+		uniqueStrict:      *uniqueStrict,
+		uniqueSkipEmpty:   *uniqueSkipEmpty,
+		template:          *template,
+		nullValue:         *nullValue,
+		nullValueGiven:    nullValueGiven,
+		forceExtension:    *forceExtension,
+		scalar:            *scalar,
+		allowEmpty:        *allowEmpty,
+		typed:             *typed,
+		typeMap:           typeMap,
+		booleanTokens:     booleanTokens,
+		floatFormat:       floatFormat,
+		percentMode:       *percentMode,
+		decimalComma:      *decimalComma,
+		currencySymbols:   currencySymbols,
+		currencyAsNumber:  *currencyAsNumber,
+		stringify:         *stringify,
+		outputSuffix:      *outputSuffix,
+		outputDir:         *outputDir,
+		outputTemplate:    *outputTemplate,
+		runDate:           runDate,
+		createDirs:        *createDirs,
+		outputMode:        outputMode,
+		recordPerLine:     *recordPerLine,
+		noTrailingNewline: *noTrailingNewline,
+		escaping:          jsonEscaping{noEscapeHTML: *noEscapeHTML, ascii: *ascii},
+		ragged:            *ragged,
+		defaults:          defaults,
+		sortBy:            sortBy,
+		keyOrder:          *keyOrder,
+		keyOrderList:      orderedKeys,
+		sampleEvery:       *sampleEvery,
+		limit:             *limit,
+		failOnEmpty:       *failOnEmpty,
+		timing:            *timing,
+		splitRecords:      *splitRecords,
+		manifest:          *manifest,
+		sampleRate:        *sampleRate,
+		seed:              *seed,
+		keyPrefix:         *keyPrefix,
+		wrapKey:           *wrapKey,
+		newlineHandling:   *newlineHandling,
+		schema:            schema,
+		dryValidate:       *dryValidate,
+		normalize:         *normalize,
+		normalizeKeys:     *normalizeKeys,
+		headerRenames:     headerRenames,
+		fuzzyColumns:      *fuzzyColumns,
+		valueCases:        valueCases,
+		errorFile:         *errorFile,
+		skipBlankRows:     *skipBlankRows,
+		skipAllEmptyRows:  *skipAllEmptyRows,
+		trailingSeparator: *trailingSeparator || *allowTrailingDelimiter,
+		givenHeaders:      givenHeaders,
+		fixedWidths:       widths,
+		widthUnit:         *widthUnit,
+		reverse:           *reverse,
+		quoteMode:         *quoteMode,
+		lineEnding:        lineBreak,
+		fieldsPerRecord:   *fieldsPerRecord,
+		sourceField:       *sourceField,
+		concatFields:      concatFields,
+		concatSep:         *concatSep,
+		excluded:          excluded,
+		geoPoints:         geoPoints,
+		geoInvalid:        *geoInvalid,
+		decodings:         decodings,
+		decodeInvalid:     *decodeInvalid,
+		decodeBinary:      *decodeBinary,
+		parseJSON:         jsonColumns,
+		parseJSONInvalid:  *parseJSONInvalid,
+		parseJSONDepth:    *parseJSONDepth,
+		parseJSONBytes:    *parseJSONBytes,
+		dedupeKey:         *dedupeKey,
+		dedupeKeep:        *dedupeKeep,
+		lazyQuotes:        *lazyQuotes,
+		trimLeadingSpace:  *trimLeadingSpace,
+		comment:           comment,
+		reuseRecord:       *reuseRecord,
+		noSniff:           *noSniff,
+		noColor:           *noColor,
+		report:            *report,
+	}
+
+	if *report != "" && *reverse {
+		return inputFile{}, errors.New("The report option can't be used with --reverse")
+	}
+
+	// The diff reads the records back from the JSON file, so it must be a single array of records
+	if (*diffAgainst == "") != (*keyColumn == "") {
+		return inputFile{}, errors.New("The diff-against and key-column options must be used together")
+	}
+	if *diffAgainst != "" && (*rootKey != "" || *template || *wrapKey != "" || *preview > 0 || *dryValidate || *splitRecords > 0 || *appendRecords || *reverse) {
+		return inputFile{}, errors.New("The diff-against option can't be used with the root-key, template, wrap-key, preview, dry-validate, split-records, append or reverse options")
+	}
+
+	// With --preview-confirm, the JSON file is still written, so the preview is only printed before converting it
+	if *previewConfirm {
+		fileData.preview, fileData.previewFirst = 0, *preview
+	}
+
+	// Two files written to the same place would overwrite each other
+	if fileData.preview == 0 && !fileData.dryValidate {
+		written := make(map[string]string)
+		for _, path := range fileLocations {
+			pathData := fileData
+			pathData.filepath, pathData.shard = path, 1
+			location := outputLocation(pathData, pathData.outputSuffix)
+			if other, seen := written[location]; seen {
+				return inputFile{}, fmt.Errorf("The files %s and %s would both be written to %s", other, path, location)
+			}
+			written[location] = path
+		}
+	}
+
+	return fileData, nil
+}
+
+// Splits a list of column names, trimming the spaces around every name and ignoring the blank ones
+func splitColumnList(list string, separator string) []string {
+	var names []string
+	for _, name := range strings.Split(list, separator) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Reads the locations of the files to convert, one per line, like the output of find. Empty lines are skipped
+func readFileList(r io.Reader) ([]string, error) {
+	var locations []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if location := strings.TrimRight(scanner.Text(), "\r"); location != "" {
+			locations = append(locations, location)
+		}
+	}
+
+	return locations, scanner.Err()
+}
+
+// Returns the extension of a file in lowercase, so DATA.CSV is a CSV file too
+func fileExtension(filename string) string {
+	return strings.ToLower(filepath.Ext(filename))
+}
+
+// Validates that a file can be converted. Besides .csv files, .tsv files and .txt files (with a warning) are accepted.
+// With forceExtension any name is, since the content gets validated by the parser anyway.
+// A fixed-width file is often a .txt file, and it isn't read as CSV, so there's no warning for it
+func checkIfValidFile(filename string, forceExtension bool, fixedWidth bool) (bool, error) {
+	// A named pipe, like a FIFO another program writes to, can have any name
+	if isNamedPipe(filename) {
+		return checkIfReadable(filename)
+	}
+
+	switch extension := fileExtension(filename); {
+	case forceExtension || extension == ".csv" || extension == ".tsv":
+	case extension == ".txt":
+		if !fixedWidth {
+			logger.warnf("File %s is a text file. Reading it as CSV", filename)
+		}
+	default:
+		return false, fmt.Errorf("File %s is not CSV. Use --force-extension to convert it anyway", filename)
+	}
+
+	return checkIfReadable(filename)
+}
+
+// Validates if a file is a named pipe, like a FIFO or the /dev/fd/63 of a process substitution
+func isNamedPipe(filename string) bool {
+	info, err := os.Stat(filename)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// Validates that a pipe can be converted. A pipe can only be read once, so nothing can look at it before the
+// conversion does, and there's no file next to it that its JSON file would be named after
+func checkPipe(fileData inputFile) error {
+	if fileData.outputDir == "" && fileData.outputTemplate == "" && fileData.preview == 0 && !fileData.dryValidate {
+		return fmt.Errorf("%s is a pipe, so its JSON file must be named with --output-dir or --output-template", fileData.filepath)
+	}
+	if fileData.separatorFallback != nil || fileData.previewFirst > 0 || fileData.checkpoint != "" {
+		return fmt.Errorf("%s is a pipe, which can only be read once, so it can't be converted with the separator-fallback, preview-confirm or checkpoint options", fileData.filepath)
+	}
+
+	return nil
+}
+
+// Validates that a file exists and can be read, whatever its extension
+func checkIfReadable(filename string) (bool, error) {
+	info, err := os.Stat(filename)
+	switch {
+	case os.IsNotExist(err):
+		return false, fmt.Errorf("File %s does not exist", filename)
+	case os.IsPermission(err):
+		return false, fmt.Errorf("File %s can't be accessed: permission denied", filename)
+	case err != nil:
+		return false, fmt.Errorf("File %s can't be accessed: %v", filename, err)
+	}
+
+	// Pipes like the ones of process substitution are fine, but there's nothing to read in a directory or a device
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		return false, fmt.Errorf("%s is a directory, not a file", filename)
+	case mode&os.ModeCharDevice != 0:
+		return false, fmt.Errorf("%s is a character device, not a file", filename)
+	case !mode.IsRegular() && mode&os.ModeNamedPipe == 0:
+		return false, fmt.Errorf("%s is not a regular file", filename)
+	}
+
+	// The permissions are only checked when opening the file. Pipes are not opened here, since that can wait for a writer
+	if mode.IsRegular() {
+		f, err := os.Open(filename)
+		if os.IsPermission(err) {
+			return false, fmt.Errorf("File %s can't be read: permission denied", filename)
+		}
+		if err != nil {
+			return false, fmt.Errorf("File %s can't be read: %v", filename, err)
+		}
+		f.Close()
+	}
+
+	return true, nil
+}
+
+// A single CSV line. The values are aligned with the headers, and every record of a file
+// shares the same headers slice, so the header strings are only stored once.
+// nulls marks the values written as null because they couldn't be decoded. It's nil when there are none
+type record struct {
+	headers []string
+	values  []string
+	nulls   []bool
+}
+
+// The byte order mark some editors write at the start of UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Skips the byte order mark at the start of the file, so it doesn't end up in the first header.
+// It's read through the reader, so the offsets in the file are still right
+func skipBOM(bufReader *bufio.Reader) error {
+	peeked, err := bufReader.Peek(len(utf8BOM))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if bytes.Equal(peeked, utf8BOM) {
+		_, err = bufReader.Discard(len(utf8BOM))
+		return err
+	}
+
+	return nil
+}
+
+// Looks for a "sep=" directive on the first line of the file. When there is one, the line is consumed
+// so it doesn't get read as the headers, and the declared separator is returned
+func readSepDirective(bufReader *bufio.Reader) (rune, bool, error) {
+	// The directive is a short line, so peeking a few bytes is enough to find it
+	peeked, err := bufReader.Peek(16)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return 0, false, err
+	}
+
+	line, lineLength := peeked, len(peeked)
+	if i := bytes.IndexByte(peeked, '\n'); i >= 0 {
+		line, lineLength = peeked[:i], i+1
+	} else if err == nil {
+		// The first line is longer than what we peeked, so it can't be a directive
+		return 0, false, nil
+	}
+
+	match := sepDirective.FindSubmatch(line)
+	if match == nil {
+		return 0, false, nil
+	}
+
+	// Discarding the directive together with its line break
+	if _, err := bufReader.Discard(lineLength); err != nil {
+		return 0, false, err
+	}
+
+	separator, _ := utf8.DecodeRune(match[1])
+	return separator, true, nil
+}
+
+// A "key: value" pair from the metadata lines of a file
+type metaField struct {
+	key   string
+	value string
+}
+
+// Consumes the lines starting with "#" before the headers, keeping the ones with a "key: value" pair.
+// When a key is repeated, the last value is kept in the place of the first one
+func readMetaLines(bufReader *bufio.Reader) ([]metaField, error) {
+	meta := []metaField{}
+	for {
+		next, err := bufReader.Peek(1)
+		if err == io.EOF || (err == nil && next[0] != '#') {
+			return meta, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, err := bufReader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		match := metaLine.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+		if match == nil {
+			continue
+		}
+
+		found := false
+		for i := range meta {
+			if meta[i].key == match[1] {
+				meta[i].value, found = match[2], true
+			}
+		}
+		if !found {
+			meta = append(meta, metaField{match[1], match[2]})
+		}
+	}
+}
+
+// The first bytes of every gzip file
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Returns the content of a file, decompressing it when it's compressed with gzip. Gzip files are recognized
+// by their first bytes, so mislabeled files and pipes work too
+func openContent(r io.Reader) (io.Reader, bool, error) {
+	peekReader := bufio.NewReader(r)
+	magic, err := peekReader.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+
+	if !bytes.Equal(magic, gzipMagic) {
+		return peekReader, false, nil
+	}
+
+	gzipReader, err := gzip.NewReader(peekReader)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return gzipReader, true, nil
+}
+
+// Number of bytes looked at to tell that a file is not text
+const sniffSize = 512
+
+// Formats that are not text, by their first bytes, with what to tell about them
+var binaryFormats = []struct {
+	magic []byte
+	hint  string
+}{
+	{[]byte("PK\x03\x04"), "looks like a ZIP archive. Did you mean an .xlsx file? Save it as CSV first"},
+	{[]byte("%PDF"), "looks like a PDF document, which has no CSV to read"},
+	// Gzip files are decompressed already, so this is a file compressed twice
+	{gzipMagic, "is still compressed with gzip once decompressed. Decompress it first, like with gunzip"},
+}
+
+// Looks at the first bytes of the content of a file, so a file that's not text stops the conversion before
+// its lines are read as records. The bytes are only peeked, so the reader returned still has all of them
+func sniffContent(path string, r io.Reader) (io.Reader, error) {
+	peekReader, ok := r.(*bufio.Reader)
+	if !ok {
+		peekReader = bufio.NewReader(r)
+	}
+	start, err := peekReader.Peek(sniffSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	for _, format := range binaryFormats {
+		if bytes.HasPrefix(start, format.magic) {
+			return nil, fmt.Errorf("File %s %s. Use --no-sniff to read it anyway", path, format.hint)
+		}
+	}
+	if bytes.IndexByte(start, 0) >= 0 {
+		return nil, fmt.Errorf("File %s has NUL bytes, so it's not text, or it's UTF-16 text that must be converted into UTF-8 first. Use --no-sniff to read it anyway", path)
+	}
+
+	return peekReader, nil
+}
+
+// Returns the separator of a file. When none was given in the command line, the one declared by the file is used,
+// and otherwise .tsv files are separated by tabs
+func fileSeparator(fileData inputFile, declaredSeparator rune, declared bool) rune {
+	if !fileData.separatorGiven {
+		if declared {
+			return declaredSeparator
+		}
+		if fileExtension(fileData.filepath) == ".tsv" {
+			return '\t'
+		}
+	}
+
+	if r, named := separatorNames[fileData.separator]; named {
+		return r
+	}
+
+	// Any other separator is given as it is. When it has more than one character, it's not a rune, see multiSeparator
+	if r, size := utf8.DecodeRuneInString(fileData.separator); size == len(fileData.separator) {
+		return r
+	}
+
+	return ','
+}
+
+// Error returned by the fieldGuard once a record has read more bytes than allowed
+var errFieldTooLarge = errors.New("field too large")
+
+// Limits the bytes that can be read from the file for a single record. processCsvFile resets it after every
+// record, so a malformed field can't make the CSV reader load the rest of the file into memory
+type fieldGuard struct {
+	r         io.Reader
+	remaining int64 // Negative when there's no limit
+	read      int64 // Total number of bytes read from r
+}
+
+func (g *fieldGuard) reset(limit int64) {
+	if limit <= 0 {
+		g.remaining = -1
+		return
+	}
+
+	g.remaining = limit
+}
+
+func (g *fieldGuard) Read(p []byte) (int, error) {
+	if g.remaining == 0 {
+		return 0, errFieldTooLarge
+	}
+
+	if g.remaining > 0 && int64(len(p)) > g.remaining {
+		p = p[:g.remaining]
+	}
+
+	n, err := g.r.Read(p)
+	g.read += int64(n)
+	if g.remaining > 0 {
+		g.remaining -= int64(n)
+	}
+
+	return n, err
+}
+
+// Keeps the bytes read through it, so the lines skipped can be written to --error-file as they are in the file.
+// It sits below the buffered reader, so the bytes still buffered are not part of the current line yet
+type lineRecorder struct {
+	r     io.Reader
+	data  []byte
+	start int // Where the current line starts in data
+}
+
+func (l *lineRecorder) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.data = append(l.data, p[:n]...)
+	return n, err
+}
+
+// Starts a new line after everything read except the buffered bytes. The bytes before it are dropped
+// once they are most of the data, so the data doesn't grow with the file
+func (l *lineRecorder) mark(buffered int) {
+	l.start = len(l.data) - buffered
+	if l.start > len(l.data)/2 {
+		n := copy(l.data, l.data[l.start:])
+		l.data, l.start = l.data[:n], 0
+	}
+}
+
+// Returns the bytes of the current line, which ends where the buffered bytes start
+func (l *lineRecorder) line(buffered int) []byte {
+	return l.data[l.start : len(l.data)-buffered]
+}
+
+// Validates if every value of a line is empty. With trim, values of spaces only count as empty too
+func isEmptyLine(line []string, trim bool) bool {
+	for _, value := range line {
+		if trim {
+			value = strings.TrimSpace(value)
+		}
+		if value != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Validates that the headers don't have more than maxColumns columns (0 means no limit). Too many columns
+// usually mean the file is read with the wrong separator, or isn't a CSV file at all
+func checkColumnCount(headers []string, maxColumns int) error {
+	if maxColumns > 0 && len(headers) > maxColumns {
+		return fmt.Errorf("The headers have %d columns, more than the maximum of %d. Check the separator, or raise --max-columns or use --force to convert it anyway", len(headers), maxColumns)
+	}
+
+	return nil
+}
+
+// The separators a file is most likely separated by when its headers are read as a single column that has them
+var commonSeparators = []string{",", ";", "\t", "|"}
+
+// Returns a warning when the headers are a single column with a common separator in it, other than the separator
+// the file is read with, which is what the headers of a file read with the wrong separator look like. Otherwise it's ""
+func singleColumnWarning(headers []string, separator string) string {
+	if len(headers) != 1 {
+		return ""
+	}
+
+	for _, common := range commonSeparators {
+		if common != separator && strings.Contains(headers[0], common) {
+			return fmt.Sprintf("The headers are a single column that has %q in it, so the file is most likely separated by it. Check the separator", common)
+		}
+	}
+	return ""
+}
+
+// Validates that none of the fields of the last line read is bigger than maxFieldBytes (0 means no limit)
+func checkFieldSizes(reader recordReader, line []string, maxFieldBytes int64) error {
+	if maxFieldBytes == 0 {
+		return nil
+	}
+
+	for i, value := range line {
+		if int64(len(value)) > maxFieldBytes {
+			lineNumber, _ := reader.FieldPos(i)
+			return fmt.Errorf("Field %d on line %d is bigger than the maximum of %d bytes", i+1, lineNumber, maxFieldBytes)
+		}
+	}
+
+	return nil
+}
+
+// Returns the number of the line where the last line read ends, since quoted fields can span several lines
+func lastLineNumber(reader recordReader, line []string) int {
+	lineNumber, _ := reader.FieldPos(len(line) - 1)
+	return lineNumber + strings.Count(line[len(line)-1], "\n")
+}
+
+// Returns the position of a column in the headers, or -1 when it isn't there
+func columnIndex(headers []string, name string) int {
+	for i, header := range headers {
+		if header == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Returns a column name without its case, spaces, dashes and underscores, which is how --fuzzy-columns compares them
+func fuzzyColumnName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' || r == '_' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// Returns the position of a column in the headers like columnIndex. With fuzzy, a name that isn't a header matches
+// the header it's the same as for fuzzyColumnName, and it's an error when it's the same as more than one
+func findColumn(headers []string, name string, fuzzy bool) (int, error) {
+	index := columnIndex(headers, name)
+	if index >= 0 || !fuzzy {
+		return index, nil
+	}
+
+	wanted := fuzzyColumnName(name)
+	for i, header := range headers {
+		if fuzzyColumnName(header) != wanted {
+			continue
+		}
+		if index >= 0 {
+			return -1, fmt.Errorf("Column %s matches more than one header: %q and %q", name, headers[index], header)
+		}
+		index = i
+	}
+	if index >= 0 {
+		logger.debugf("Column %s matched header %q", name, headers[index])
+	}
+
+	return index, nil
+}
+
+// Validates if a line passes every range filter. indexes holds the position of the column of each filter
+func matchesRangeFilters(reader recordReader, filters []rangeFilter, indexes []int, line []string) bool {
+	for i, filter := range filters {
+		// A line with missing columns is left for processLine to skip
+		if indexes[i] >= len(line) {
+			return true
+		}
+		value := line[indexes[i]]
+
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			lineNumber, _ := reader.FieldPos(indexes[i])
+			logger.warnf("Line %d: value %q of column %s is not a number. Skipping", lineNumber, value, filter.column)
+			return false
+		}
+
+		if number < filter.min || number > filter.max {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the keys included in the output and the position of the column of each of them.
+// Columns keep the order they have in the file, and every column is included when the list is empty
+func selectColumns(headers []string, included []string) ([]string, []int, error) {
+	if len(included) == 0 {
+		columns := make([]int, len(headers))
+		for i := range headers {
+			columns[i] = i
+		}
+		return headers, columns, nil
+	}
+
+	for _, name := range included {
+		if columnIndex(headers, name) < 0 {
+			return nil, nil, fmt.Errorf("Column %s is not in the headers", name)
+		}
+	}
+
+	var keys []string
+	var columns []int
+	for i, header := range headers {
+		if columnIndex(included, header) >= 0 {
+			keys = append(keys, header)
+			columns = append(columns, i)
+		}
+	}
+
+	return keys, columns, nil
+}
+
+// Returns the keys and the columns of selectColumns without the columns at the excluded positions
+func excludeColumns(keys []string, columns []int, excluded []int) ([]string, []int) {
+	var keptKeys []string
+	var keptColumns []int
+	for i, column := range columns {
+		found := false
+		for _, index := range excluded {
+			found = found || index == column
+		}
+		if !found {
+			keptKeys = append(keptKeys, keys[i])
+			keptColumns = append(keptColumns, column)
+		}
+	}
+
+	return keptKeys, keptColumns
+}
+
+// Returns the headers for --normalize-keys, with every run of characters that are not letters or digits replaced
+// by an underscore, and none at the ends. Headers that end up with the same name get a number after the first one,
+// like id_2, and a header with nothing left is named after its position, like column_3
+func normalizeHeaders(headers []string) []string {
+	normalized := make([]string, len(headers))
+	taken := make(map[string]bool, len(headers))
+	for i, header := range headers {
+		name := strings.Trim(keyPunctuation.ReplaceAllString(header, "_"), "_")
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+
+		unique := name
+		for n := 2; taken[unique]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		taken[unique] = true
+		normalized[i] = unique
+	}
+
+	return normalized
+}
+
+// Returns the headers with the renames of --rename-regex applied to each of them in order. A header renamed into
+// the key of another one, or into nothing, would lose values, so it's an error. Headers that were already the
+// same and aren't renamed are left like they are without --rename-regex
+func renameHeaders(headers []string, renames []headerRename) ([]string, error) {
+	renamed := make([]string, len(headers))
+	taken := make(map[string]int, len(headers))
+	for i, header := range headers {
+		name := header
+		for _, rename := range renames {
+			name = rename.pattern.ReplaceAllString(name, rename.replacement)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("Header %s is renamed to nothing", header)
+		}
+		if previous, found := taken[name]; found && (name != header || name != headers[previous]) {
+			return nil, fmt.Errorf("Headers %s and %s are both renamed to %s", headers[previous], header, name)
+		}
+		if name != header {
+			logger.debugf("Header %s renamed to %s", header, name)
+		}
+		taken[name] = i
+		renamed[i] = name
+	}
+
+	return renamed, nil
+}
+
+// Validates if the JSON file of a CSV file is already up to date: not empty and modified after the CSV file
+func isUpToDate(csvPath string, jsonPath string) (bool, error) {
+	csvInfo, err := os.Stat(csvPath)
+	if err != nil {
+		return false, err
+	}
+
+	jsonInfo, err := os.Stat(jsonPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return jsonInfo.Size() > 0 && jsonInfo.ModTime().After(csvInfo.ModTime()), nil
+}
+
+func processLine(headers []string, dataList []string, decoders []columnDecoder, transforms []columnTransform) (record, error) {
+	// Validating if we're getting the same number of headers and columns. Otherwise, we return an error
+	if len(headers) != len(dataList) {
+		return record{}, errors.New("Line doesn't match headers format. Skipping")
+	}
+
+	// The values are decoded first, so the transforms change the decoded text
+	var nulls []bool
+	for _, decoder := range decoders {
+		decoded, null, err := decoder.apply(dataList[decoder.index])
+		if err != nil {
+			return record{}, err
+		}
+		dataList[decoder.index] = decoded
+		if null {
+			if nulls == nil {
+				nulls = make([]bool, len(dataList))
+			}
+			nulls[decoder.index] = true
+		}
+	}
+
+	for _, transform := range transforms {
+		dataList[transform.index] = transform.apply(dataList[transform.index])
+	}
+
+	return record{headers, dataList, nulls}, nil
+}
+
+// Reads the records of a CSV file and sends them to the writer in batches, closing writerChannel once every one
+// is sent. An error stops the reading without closing it, so whoever waits on writerChannel must be stopped too.
+// A conversion cancelled through ctx just stops, without an error
+func processCsvFile(ctx context.Context, fileData inputFile, writerChannel chan<- recordBatch) error {
+	file, err := os.Open(fileData.filepath)
+	if err != nil {
+		return err
+	}
+
+	// Don't forget to close the file once everything is done
+	defer file.Close()
+
+	return processCsv(ctx, fileData, file, writerChannel)
+}
+
+// Same as processCsvFile, reading the CSV from file, which is only named by fileData.filepath
+func processCsv(ctx context.Context, fileData inputFile, file io.ReadSeeker, writerChannel chan<- recordBatch) (err error) {
+	// Every batch waits for the writer to take it. When the conversion is cancelled instead, the file stops being read
+	send := func(batch recordBatch) bool {
+		select {
+		case writerChannel <- batch:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var headers, line []string
+
+	bufferSize := fileData.readBuffer
+	if bufferSize < minReadBuffer {
+		bufferSize = minReadBuffer
+	}
+
+	// Gzip files are decompressed on the fly. Checkpoints point to a position in the file, which can
+	// only be jumped to when the file is read as it is
+	var content io.Reader = file
+	if fileData.bytesRead != nil {
+		content = &countingReader{r: file, count: fileData.bytesRead}
+	}
+	input, compressed, err := openContent(content)
+	if err != nil {
+		return err
+	}
+	if !fileData.noSniff {
+		input, err = sniffContent(fileData.filepath, input)
+		if err != nil {
+			return err
+		}
+	}
+	if compressed && fileData.checkpoint != "" {
+		return fmt.Errorf("File %s is compressed with gzip, so it can't be converted with a checkpoint", fileData.filepath)
+	}
+
+	// Creates the readers over the file from its current position. Every read from the file goes through the guard,
+	// and the CSV reader hands us the same slice on every Read, so values kept around must be copied first
+	var guard *fieldGuard
+	var recorder *lineRecorder
+	var bufReader *bufio.Reader
+	var reader recordReader
+	openReaders := func() {
+		guard = &fieldGuard{r: input}
+		var source io.Reader = guard
+		if fileData.errorFile != "" {
+			recorder = &lineRecorder{r: guard}
+			source = recorder
+		}
+		bufReader = bufio.NewReaderSize(source, bufferSize)
+	}
+
+	// A record can take a single field at its maximum size, whatever its number of columns, so a runaway quote
+	// reads the same amount of the file in a wide file. When the guard reaches its limit, the buffer may still hold
+	// up to bufferSize bytes of the record, so that amount is added to the allowance
+	resetGuard := func() {
+		if fileData.maxFieldBytes > 0 {
+			guard.reset(fileData.maxFieldBytes + recordSlackBytes + int64(bufferSize))
+		}
+	}
+
+	openReaders()
+	resetGuard()
+	if err := skipBOM(bufReader); err != nil {
+		return err
+	}
+
+	// The records are read once the lines before the headers are consumed. Fixed-width files have no separator to declare
+	var declaredSeparator rune
+	declared := false
+	if fileData.fixedWidths == nil {
+		declaredSeparator, declared, err = readSepDirective(bufReader)
+		if err != nil {
+			return err
+		}
+	}
+	comma := fileSeparator(fileData, declaredSeparator, declared)
+	if fileData.fixedWidths == nil && multiSeparator(fileData) == "" {
+		if err := checkReaderOptions(fileData.trimLeadingSpace, fileData.comment, comma); err != nil {
+			return err
+		}
+	}
+	if separator := multiSeparator(fileData); fileData.fixedWidths != nil {
+		logger.debugf("Reading %s with fixed widths %v", fileData.filepath, fileData.fixedWidths)
+	} else if separator != "" {
+		logger.debugf("Reading %s with separator %q", fileData.filepath, separator)
+	} else {
+		logger.debugf("Reading %s with separator %q", fileData.filepath, comma)
+	}
+
+	// The metadata lines are consumed here, so they don't get read as the headers
+	var meta []metaField
+	if fileData.extractMeta {
+		meta, err = readMetaLines(bufReader)
+		if err != nil {
+			return err
+		}
+		logger.debugf("Found %d metadata fields", len(meta))
+	}
+
+	// Reading the first line, where we will find our headers. With --headers, or the names of a fixed-width spec,
+	// the file has no line of headers and the first line is already a record
+	reader = newRecordReader(bufReader, comma, fileData)
+	if fileData.givenHeaders != nil {
+		headers = append([]string(nil), fileData.givenHeaders...)
+	} else {
+		headers, err = reader.Read()
+		if errors.Is(err, errFieldTooLarge) {
+			return fmt.Errorf("The headers have a field bigger than the maximum of %d bytes", fileData.maxFieldBytes)
+		}
+
+		// A file without headers has nothing to convert. It's only converted into an empty output when allowed
+		if err == io.EOF {
+			if !fileData.allowEmpty {
+				return fmt.Errorf("File %s is empty. Use --allow-empty to convert it anyway", fileData.filepath)
+			}
+			logger.warnf("File %s is empty", fileData.filepath)
+			if fileData.template && !send(recordBatch{records: []record{{}}}) {
+				return nil
+			}
+			close(writerChannel)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkFieldSizes(reader, headers, fileData.maxFieldBytes); err != nil {
+			return err
+		}
+		headers = append([]string(nil), headers...)
+	}
+	// The number of columns is what tells a file read with the wrong separator, unless --force says the file is right
+	if err := checkColumnCount(headers, fileData.maxColumns); err != nil && !fileData.force {
+		return fmt.Errorf("File %s can't be converted: %v", fileData.filepath, err)
+	}
+	if fileData.fixedWidths == nil && fileData.givenHeaders == nil && !fileData.force {
+		separator := multiSeparator(fileData)
+		if separator == "" {
+			separator = string(comma)
+		}
+		if warning := singleColumnWarning(headers, separator); warning != "" {
+			logger.warnf("%s: %s", fileData.filepath, warning)
+		}
+	}
+
+	// With --normalize, the text is turned into a single Unicode form as soon as it's read, so the columns are
+	// matched, checked, filtered and hashed with the same text that gets written
+	normalizeLine := func([]string) {}
+	if fileData.normalize != "" {
+		form := norm.NFC
+		if fileData.normalize == "nfkc" {
+			form = norm.NFKC
+		}
+		normalizeLine = func(line []string) {
+			for i, value := range line {
+				line[i] = form.String(value)
+			}
+		}
+	}
+	normalizeLine(headers)
+	if fileData.normalizeKeys {
+		headers = normalizeHeaders(headers)
+	}
+	if fileData.headerRenames != nil {
+		headers, err = renameHeaders(headers, fileData.headerRenames)
+		if err != nil {
+			return fmt.Errorf("The headers of %s can't be renamed: %v", fileData.filepath, err)
+		}
+	}
+	logger.debugf("Found %d headers: %v", len(headers), headers)
+
+	// Finds the column of a name given to an option, which --fuzzy-columns matches loosely. The role tells what
+	// the option uses the column for, in the error of a column that's not there
+	headerIndex := func(name string) (int, error) {
+		return findColumn(headers, name, fileData.fuzzyColumns)
+	}
+	requireColumn := func(name string, role string) (int, error) {
+		index, err := headerIndex(name)
+		if err == nil && index < 0 {
+			err = fmt.Errorf("Column %s %s is not in the headers", name, role)
+		}
+		return index, err
+	}
+
+	// Finding the columns used by the range filters
+	filterIndexes := make([]int, len(fileData.rangeFilters))
+	for i, filter := range fileData.rangeFilters {
+		if filterIndexes[i], err = requireColumn(filter.column, "of the range filter"); err != nil {
+			return err
+		}
+	}
+
+	// Finding the columns that must be unique, on their own or as the columns of the composite key
+	var uniqueKeys [][]string
+	for _, column := range fileData.uniqueColumns {
+		uniqueKeys = append(uniqueKeys, []string{column})
+	}
+	if len(fileData.uniqueKey) > 0 {
+		uniqueKeys = append(uniqueKeys, fileData.uniqueKey)
+	}
+	var uniqueChecks []*uniqueCheck
+	for _, keyColumns := range uniqueKeys {
+		indexes := make([]int, len(keyColumns))
+		for i, column := range keyColumns {
+			if indexes[i], err = requireColumn(column, "of the unique check"); err != nil {
+				return err
+			}
+		}
+		uniqueChecks = append(uniqueChecks, newUniqueCheck(keyColumns, indexes, fileData.uniqueSkipEmpty))
+	}
+
+	// With --dedupe-key, the lines with a key already seen are collapsed into one record. Keeping the last one
+	// holds every record until the file is read, and drops the records a later line replaces
+	dedupeIndex := -1
+	if fileData.dedupeKey != "" {
+		if dedupeIndex, err = requireColumn(fileData.dedupeKey, "to dedupe by"); err != nil {
+			return err
+		}
+	}
+	keepLast := dedupeIndex >= 0 && fileData.dedupeKeep == "last"
+	seenKeys := make(map[string]bool)
+	lastKeys := make(map[string]int) // With --keep=last, the index of the last record of every key among the ones held
+	var held []record
+	var replaced []bool // With --keep=last, whether each record held was replaced by a later line
+	duplicates := 0
+
+	// Finding the columns whose values change case. Unlike the other transforms, these apply as soon as the line
+	// is read, so the checks and filters already see "Foo@Bar.com" and "foo@bar.com" as the same value
+	var caseTransforms []columnTransform
+	for _, c := range fileData.valueCases {
+		index, err := requireColumn(c.column, "of the value case")
+		if err != nil {
+			return err
+		}
+		mode := c.mode
+		caseTransforms = append(caseTransforms, columnTransform{index, func(value string) string { return changeCase(value, mode) }})
+	}
+
+	// Finding the columns whose values are decoded
+	var decoders []columnDecoder
+	for _, d := range fileData.decodings {
+		index, err := requireColumn(d.column, "to decode")
+		if err != nil {
+			return err
+		}
+		decoders = append(decoders, newColumnDecoder(index, d, fileData.decodeInvalid, fileData.decodeBinary))
+	}
+	// The JSON is checked once the values are decoded, so it can be given in base64 too
+	for _, column := range fileData.parseJSON {
+		index, err := requireColumn(column, "of the JSON values")
+		if err != nil {
+			return err
+		}
+		decoders = append(decoders, newJSONDecoder(index, column, fileData))
+	}
+
+	// Finding the columns whose values get changed. Line breaks are handled in every column, then the defaults
+	// fill the empty values, so the values they give get redacted or hashed too
+	var transforms []columnTransform
+	if fileData.newlineHandling != "" && fileData.newlineHandling != "keep" {
+		handling := fileData.newlineHandling
+		for i := range headers {
+			transforms = append(transforms, columnTransform{i, func(value string) string { return handleNewlines(value, handling) }})
+		}
+	}
+	for _, d := range fileData.defaults {
+		index, err := requireColumn(d.column, "of the default")
+		if err != nil {
+			return err
+		}
+		defaultValue := d.value
+		transforms = append(transforms, columnTransform{index, func(value string) string {
+			if value == "" {
+				return defaultValue
+			}
+			return value
+		}})
+	}
+	for _, r := range fileData.redactions {
+		index, err := requireColumn(r.column, "to redact")
+		if err != nil {
+			return err
+		}
+		mask, keep := fileData.redactMask, r.keep
+		transforms = append(transforms, columnTransform{index, func(value string) string { return redactValue(value, mask, keep) }})
+	}
+	for _, column := range fileData.hashColumns {
+		index, err := requireColumn(column, "to hash")
+		if err != nil {
+			return err
+		}
+		salt := fileData.hashSalt
+		transforms = append(transforms, columnTransform{index, func(value string) string { return hashValue(value, salt) }})
+	}
+
+	// Working out which columns end up in the records. The properties of a schema don't need to be columns of the file
+	included := fileData.columns
+	if fileData.columnsFromSchema {
+		included = nil
+		for _, name := range fileData.columns {
+			index, err := headerIndex(name)
+			if err != nil {
+				return err
+			}
+			if index < 0 {
+				logger.warnf("Column %s of the schema is not in %s", name, fileData.filepath)
+				continue
+			}
+			included = append(included, headers[index])
+		}
+		if included == nil {
+			return fmt.Errorf("None of the columns of the schema are in %s", fileData.filepath)
+		}
+	}
+	if fileData.fuzzyColumns && !fileData.columnsFromSchema {
+		included = make([]string, len(fileData.columns))
+		for i, name := range fileData.columns {
+			included[i] = name
+			index, err := headerIndex(name)
+			if err != nil {
+				return err
+			}
+			if index >= 0 {
+				included[i] = headers[index]
+			}
+		}
+	}
+	// The names of --type-map don't need to be columns, but they can't match more than one
+	if fileData.fuzzyColumns {
+		for name := range fileData.typeMap {
+			if _, err := headerIndex(name); err != nil {
+				return err
+			}
+		}
+	}
+	keys, columns, err := selectColumns(headers, included)
+	if err != nil {
+		return err
+	}
+
+	// --exclude leaves columns out of the records, which can still be joined by --concat
+	if fileData.excluded != nil {
+		excludedIndexes := make([]int, len(fileData.excluded))
+		for i, name := range fileData.excluded {
+			if excludedIndexes[i], err = requireColumn(name, "to exclude"); err != nil {
+				return err
+			}
+		}
+		keys, columns = excludeColumns(keys, columns, excludedIndexes)
+	}
+
+	// With --concat, every record also gets the values of some columns joined under a key of their own, after its columns
+	concatIndexes := make([][]int, len(fileData.concatFields))
+	for i, field := range fileData.concatFields {
+		for _, column := range field.columns {
+			index, err := requireColumn(column, fmt.Sprintf("of the concatenation %s", field.key))
+			if err != nil {
+				return err
+			}
+			concatIndexes[i] = append(concatIndexes[i], index)
+		}
+		if columnIndex(keys, field.key) >= 0 {
+			return fmt.Errorf("Key %s of the concatenation is already a column of %s", field.key, fileData.filepath)
+		}
+		keys = append(keys[:len(keys):len(keys)], field.key)
+	}
+	var joined []string
+
+	// With --geo, the latitude and longitude columns are replaced by a point, after the concatenations
+	geoIndexes := make([][2]int, len(fileData.geoPoints))
+	var pointIndexes []int
+	for i, point := range fileData.geoPoints {
+		for j, column := range []string{point.lat, point.lon} {
+			if geoIndexes[i][j], err = requireColumn(column, fmt.Sprintf("of the point %s", point.key)); err != nil {
+				return err
+			}
+			pointIndexes = append(pointIndexes, geoIndexes[i][j])
+		}
+	}
+	if pointIndexes != nil {
+		keys, columns = excludeColumns(keys, columns, pointIndexes)
+	}
+	for _, point := range fileData.geoPoints {
+		if columnIndex(keys, point.key) >= 0 {
+			return fmt.Errorf("Key %s of the point is already a column of %s", point.key, fileData.filepath)
+		}
+		keys = append(keys[:len(keys):len(keys)], point.key)
+	}
+	points := make([]string, len(geoIndexes))
+
+	// With --add-source-field, every record also gets the name of its file, after its columns
+	source := filepath.Base(fileData.filepath)
+	if fileData.sourceField != "" {
+		if columnIndex(headers, fileData.sourceField) >= 0 {
+			return fmt.Errorf("Source field %s is already a column of %s", fileData.sourceField, fileData.filepath)
+		}
+		keys = append(keys[:len(keys):len(keys)], fileData.sourceField)
+	}
+	for _, key := range fileData.keyOrderList {
+		if columnIndex(keys, key) < 0 {
+			return fmt.Errorf("Key %s of the key order list is not in the records", key)
+		}
+	}
+	if fileData.sortBy.column != "" && columnIndex(keys, fileData.sortBy.column) < 0 {
+		return fmt.Errorf("Column %s to sort by is not in the records", fileData.sortBy.column)
+	}
+	if fileData.scalar && len(keys) != 1 {
+		return fmt.Errorf("Only files with a single column can be converted with the scalar option, and %s has %d. Use --columns to pick one", fileData.filepath, len(keys))
+	}
+
+	// A template only needs the headers, so the rest of the file is never read
+	if fileData.template {
+		value := ""
+		if fileData.nullValueGiven {
+			value = fileData.nullValue
+		}
+		values := make([]string, len(keys))
+		for i := range values {
+			values[i] = value
+		}
+		if fileData.sourceField != "" {
+			values[len(values)-1] = source
+		}
+		if send(recordBatch{records: []record{{headers: keys, values: values}}}) {
+			close(writerChannel)
+		}
+		return nil
+	}
+
+	// A line can't be bigger than all its fields at their maximum size
+	lineNumber := 0
+	if fileData.givenHeaders == nil {
+		lineNumber = lastLineNumber(reader, headers)
+	}
+
+	// When resuming, the headers are still read from the top of the file, and then we jump right after
+	// the last line converted. From there, line numbers in messages are counted from that point
+	start := int64(0)
+	if fileData.resume.InputOffset > 0 {
+		_, err = file.Seek(fileData.resume.InputOffset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		input = content
+		openReaders()
+		reader = newRecordReader(bufReader, comma, fileData)
+		start, lineNumber = fileData.resume.InputOffset, 0
+		logger.infof("Resuming from byte %d of %s", start, fileData.filepath)
+	}
+	resetGuard()
+
+	// Position in the file right after the last line read
+	offset := func() int64 {
+		return start + guard.read - int64(bufReader.Buffered())
+	}
+
+	// The writer needs the metadata before the first record, so it goes in a batch of its own
+	if fileData.extractMeta && !send(recordBatch{meta: meta, offset: offset()}) {
+		return nil
+	}
+
+	// Records are accumulated here and pushed to the writer once the batch is full.
+	// The values of a whole batch live in one slice, so we only allocate once per batch
+	batch, values := newBatch(fileData.batchSize, len(keys))
+	kept, dataLines := 0, 0
+	blankRows, emptyRows, trailingRows := 0, 0, 0
+
+	// The random sampling gets its own source, so the same seed always keeps the same lines
+	var sampler *rand.Rand
+	if fileData.sampleRate > 0 && fileData.sampleRate < 1 {
+		sampler = rand.New(rand.NewSource(fileData.seed))
+		logger.infof("Sampling %g%% of the lines with seed %d", fileData.sampleRate*100, fileData.seed)
+	}
+
+	// The statistics are gathered while reading, so the file is only read once
+	var profile *fileProfile
+	if fileData.profile {
+		profile = newFileProfile(keys, fileData.profileCap)
+	}
+	var schema *schemaCheck
+	if fileData.schema != nil {
+		schema = newSchemaCheck(fileData.schema, keys, fileData)
+	}
+	var inference *schemaInference
+	if fileData.emitSchema != "" {
+		inference = newSchemaInference(keys, fileData)
+	}
+
+	// The skipped lines are added to the error file, which is only created once there's a line to write
+	var errorOutput *os.File
+	defer func() {
+		if errorOutput != nil {
+			if closeErr := errorOutput.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}()
+	skipLine := func(reason string) error {
+		if errorOutput == nil {
+			f, err := fileData.outputMode.open(fileData.errorFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+			if err != nil {
+				return err
+			}
+			errorOutput = f
+		}
+
+		recordLine, _ := reader.FieldPos(0)
+		entry := []byte(fmt.Sprintf("# %s:%d: %s\n", fileData.filepath, recordLine, reason))
+		entry = append(entry, recorder.line(bufReader.Buffered())...)
+		if entry[len(entry)-1] != '\n' {
+			entry = append(entry, '\n')
+		}
+		_, err := errorOutput.Write(entry)
+		return err
+	}
+
+	// Now we're going to iterate over each line from the CSV file
+	for {
+		if recorder != nil {
+			recorder.mark(bufReader.Buffered())
+		}
+
+		// We read one row (line) from the CSV.
+		// This line is a string slice, with each element representing a column
+		line, err = reader.Read()
+
+		// If we get to End of the File, we break the for-loop and finish the file below
+		if err == io.EOF {
+			break
+		}
+
+		// The guard stopped the reader before finding the end of the line
+		if errors.Is(err, errFieldTooLarge) {
+			return fmt.Errorf("The line after line %d has a field bigger than the maximum of %d bytes", lineNumber, fileData.maxFieldBytes)
+		}
+
+		// Rows without any value are not data, so they are left out before anything looks at them. A row of
+		// separators only can have the wrong number of fields, which is not an error then
+		blank := fileData.skipBlankRows && isEmptyLine(line, false)
+		empty := !blank && fileData.skipAllEmptyRows && isEmptyLine(line, true)
+
+		// A separator at the end of a row gives it an empty field more than the headers, which is dropped
+		trailing := fileData.trailingSeparator && len(line) == len(headers)+1 && line[len(headers)] == ""
+
+		// If this happens, we got an unexpected error
+		if err != nil && !((blank || empty || trailing) && errors.Is(err, csv.ErrFieldCount)) {
+			return err
+		}
+
+		if err := checkFieldSizes(reader, line, fileData.maxFieldBytes); err != nil {
+			return err
+		}
+		lineNumber = lastLineNumber(reader, line)
+		resetGuard()
+		if blank {
+			blankRows++
+			continue
+		}
+		if empty {
+			emptyRows++
+			continue
+		}
+		if trailing {
+			line = line[:len(headers)]
+			trailingRows++
+		}
+
+		normalizeLine(line)
+		for _, transform := range caseTransforms {
+			// A short line is left for processLine to skip or pad
+			if transform.index < len(line) {
+				line[transform.index] = transform.apply(line[transform.index])
+			}
+		}
+
+		// The checks and filters look at the values as they are in the file, before processLine changes them
+		for _, u := range uniqueChecks {
+			u.add(reader, line)
+		}
+
+		// With --sample-every, only the lines of data whose 1-based index is a multiple of N are converted
+		if dataLines++; fileData.sampleEvery > 1 && dataLines%fileData.sampleEvery != 0 {
+			continue
+		}
+		if sampler != nil && sampler.Float64() >= fileData.sampleRate {
+			continue
+		}
+
+		if !matchesRangeFilters(reader, fileData.rangeFilters, filterIndexes, line) {
+			continue
+		}
+
+		// Short lines get empty fields for their missing columns, which the defaults can then fill
+		if fileData.ragged == "pad" {
+			for len(line) < len(headers) {
+				line = append(line, "")
+			}
+		}
+
+		// The key is taken before processLine, so a transform like --redact doesn't make different keys the same
+		var dedupeValue string
+		if dedupeIndex >= 0 && dedupeIndex < len(line) {
+			dedupeValue = line[dedupeIndex]
+		}
+
+		// Processiong a CSV line
+		record, err := processLine(headers, line, decoders, transforms)
+
+		// If we get an error here, it means we got a wrong number of columns, or a value that can't be decoded,
+		// so we skip this line
+		if err != nil {
+			logger.warnf("Line: %s Error: %s", line, err)
+			if fileData.skipped != nil {
+				recordLine, _ := reader.FieldPos(0)
+				fileData.skipped.add(recordLine)
+			}
+			reason := err.Error()
+			if len(line) != len(headers) {
+				reason = fmt.Sprintf("%d fields instead of %d", len(line), len(headers))
+			}
+			if recorder != nil {
+				if err := skipLine(reason); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// The points are read before the record is kept, since --geo-invalid=skip skips the rows of the invalid ones
+		invalidPoint := false
+		for i, indexes := range geoIndexes {
+			lat, lon, err := parseCoordinates(record.values[indexes[0]], record.values[indexes[1]])
+			if err == nil {
+				points[i] = lat + "," + lon
+				continue
+			}
+			pointLine, _ := reader.FieldPos(indexes[0])
+			if invalidPoint = fileData.geoInvalid == "skip"; invalidPoint {
+				logger.warnf("Line %d: %v. Skipping", pointLine, err)
+				break
+			}
+			logger.warnf("Line %d: %v. Writing a null %s", pointLine, err, fileData.geoPoints[i].key)
+			points[i] = ""
+		}
+		if invalidPoint {
+			continue
+		}
+
+		// The first record of every key is the one kept, unless it's the last one. The lines without a key are not
+		// duplicates of each other, so they are all kept
+		if dedupeIndex >= 0 && dedupeValue == "" {
+			keyLine, _ := reader.FieldPos(0)
+			logger.warnf("Line %d has no %s. Keeping it without deduping it", keyLine, fileData.dedupeKey)
+			if keepLast {
+				replaced = append(replaced, false)
+			}
+		} else if keepLast {
+			if previous, duplicate := lastKeys[dedupeValue]; duplicate {
+				duplicates++
+				replaced[previous] = true
+			}
+			lastKeys[dedupeValue] = len(replaced)
+			replaced = append(replaced, false)
+		} else if dedupeIndex >= 0 {
+			if seenKeys[dedupeValue] {
+				duplicates++
+				continue
+			}
+			seenKeys[dedupeValue] = true
+		}
+
+		// Only the included columns are copied
+		start := len(values)
+		for _, i := range columns {
+			values = append(values, record.values[i])
+		}
+		for _, indexes := range concatIndexes {
+			joined = joined[:0]
+			for _, i := range indexes {
+				joined = append(joined, record.values[i])
+			}
+			values = append(values, strings.Join(joined, fileData.concatSep))
+		}
+		values = append(values, points...)
+		if fileData.sourceField != "" {
+			values = append(values, source)
+		}
+		if record.nulls != nil {
+			nulls := make([]bool, len(values)-start)
+			for n, i := range columns {
+				nulls[n] = record.nulls[i]
+			}
+			record.nulls = nulls
+		}
+		record.headers = keys
+		record.values = values[start:len(values):len(values)]
+		batch = append(batch, record)
+		if profile != nil {
+			profile.add(record.values)
+		}
+		if inference != nil {
+			if err := inference.add(record); err != nil {
+				return err
+			}
+		}
+		if schema != nil {
+			recordLine, _ := reader.FieldPos(0)
+			schema.add(recordLine, record.values)
+		}
+
+		// A preview or a limit stops reading as soon as it has all its records
+		if kept++; kept == fileData.preview || kept == fileData.limit {
+			break
+		}
+
+		if len(batch) == fileData.batchSize {
+			if keepLast {
+				// The records held for --keep=last are not sent, so the cancellation is checked here instead
+				if ctx.Err() != nil {
+					return nil
+				}
+				held = append(held, batch...)
+			} else if !send(recordBatch{records: batch, offset: offset()}) {
+				return nil
+			}
+			batch, values = newBatch(fileData.batchSize, len(keys))
+		}
+	}
+
+	// The records kept for --keep=last are only sent once every line is read, without the ones replaced
+	if keepLast {
+		held = append(held, batch...)
+		batch = nil
+		for i, record := range held {
+			if replaced[i] {
+				continue
+			}
+			if batch = append(batch, record); len(batch) == fileData.batchSize {
+				if !send(recordBatch{records: batch, offset: offset()}) {
+					return nil
+				}
+				batch = nil
+			}
+		}
+	}
+	if duplicates > 0 {
+		logger.infof("Collapsed %d lines of %s with a %s already seen", duplicates, fileData.filepath, fileData.dedupeKey)
+	}
+
+	// Sending the last (partial) batch, and reporting on the records read before closing the channel
+	if len(batch) > 0 && !send(recordBatch{records: batch, offset: offset()}) {
+		return nil
+	}
+	if blankRows > 0 {
+		logger.infof("Skipped %d blank rows of %s", blankRows, fileData.filepath)
+	}
+	if emptyRows > 0 {
+		logger.infof("Skipped %d rows of %s with only spaces in their values", emptyRows, fileData.filepath)
+	}
+	if trailingRows > 0 {
+		logger.infof("Dropped the empty last field of %d rows of %s", trailingRows, fileData.filepath)
+	}
+	if profile != nil {
+		if err := profile.write(outputLocation(fileData, fileData.outputSuffix), fileData.indent, fileData.escaping, fileData.outputMode); err != nil {
+			return err
+		}
+	}
+	if inference != nil {
+		if err := inference.write(fileData); err != nil {
+			return err
+		}
+	}
+	for _, u := range uniqueChecks {
+		if !u.report() && fileData.uniqueStrict {
+			return fmt.Errorf("Column %s is not unique", u.column)
+		}
+	}
+	if schema != nil && !schema.report(fileData.filepath) && fileData.dryValidate {
+		return fmt.Errorf("File %s doesn't match the schema", fileData.filepath)
+	}
+	close(writerChannel)
+	return nil
+}
+
+// The records sent together to the writer, with the position in the file right after the last of them.
+// With --extract-meta, the first batch of a file carries its metadata instead of records
+type recordBatch struct {
+	records []record
+	offset  int64
+	meta    []metaField
+}
+
+func newBatch(batchSize int, columns int) ([]record, []string) {
+	return make([]record, 0, batchSize), make([]string, 0, batchSize*columns)
+}
+
+// Returns the location of the JSON file generated for a CSV file
+func getJSONPath(csvPath string, suffix string) string {
+	jsonDir := filepath.Dir(csvPath)
+	jsonName := filepath.Base(csvPath)
+	// JSON files are the ones read by --reverse
+	switch fileExtension(jsonName) {
+	case ".csv", ".tsv", ".txt", ".json", ".ndjson", ".jsonl":
+		jsonName = strings.TrimSuffix(jsonName, filepath.Ext(jsonName))
+	}
+	jsonName += suffix
+
+	return filepath.Join(jsonDir, jsonName)
+}
+
+// Returns where the file written for a CSV file goes, with the given suffix, or named by --output-template.
+// It's next to the CSV file, unless --output-dir is given. The directories of a relative path are kept under it then, so files with the same
+// name in different directories don't overwrite each other. Other paths only keep the name of the file
+func outputLocation(fileData inputFile, suffix string) string {
+	jsonPath := getJSONPath(fileData.filepath, suffix)
+	if fileData.outputTemplate != "" {
+		jsonPath = renderOutputTemplate(fileData)
+	}
+	if fileData.outputDir == "" {
+		return jsonPath
+	}
+
+	if filepath.IsAbs(jsonPath) || jsonPath == ".." || strings.HasPrefix(jsonPath, ".."+string(filepath.Separator)) {
+		return filepath.Join(fileData.outputDir, filepath.Base(jsonPath))
+	}
+	return filepath.Join(fileData.outputDir, jsonPath)
+}
+
+// The permissions of the files written. The zero value creates them like os.Create, with 0666 before the umask
+type fileMode struct {
+	perm  os.FileMode
+	given bool // Set by --output-mode, so the files get exactly perm, whatever the umask is
+}
+
+// Parses the mode of --output-mode, written in octal like 0640
+func parseFileMode(value string) (fileMode, error) {
+	if value == "" {
+		return fileMode{}, nil
+	}
+
+	perm, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || perm > 0777 {
+		return fileMode{}, fmt.Errorf("The output mode %s must be permissions in octal, like 0640", value)
+	}
+	return fileMode{perm: os.FileMode(perm), given: true}, nil
+}
+
+// Opens a file written by the conversion like os.OpenFile does. The umask can only take permissions away,
+// so a new file is never more accessible than the mode asks for, and then it gets the mode exactly
+func (m fileMode) open(path string, flag int) (*os.File, error) {
+	if !m.given {
+		return os.OpenFile(path, flag, 0666)
+	}
+
+	f, err := os.OpenFile(path, flag, m.perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(m.perm); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Writes a whole file, like os.WriteFile does, with the permissions of the mode
+func (m fileMode) writeFile(path string, content []byte) error {
+	f, err := m.open(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Creates a new temporary file in the same directory as finalLocation, so it can be renamed into it.
+// The file is created with the mode of the final file, so renaming it never exposes it to anyone else
+func createTempFile(finalLocation string, mode fileMode) (*os.File, error) {
+	for i := 0; ; i++ {
+		tmpName := fmt.Sprintf(".%s.%d-%d.tmp", filepath.Base(finalLocation), os.Getpid(), i)
+		f, err := mode.open(filepath.Join(filepath.Dir(finalLocation), tmpName), os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+// The JSON file of a CSV file. With --atomic, it's a temporary file that only replaces the JSON file when it's closed.
+// With --checksum, everything written goes through the hash on the way
+type jsonFileOutput struct {
+	f             *os.File
+	w             io.Writer
+	finalLocation string
+	atomic        bool
+	mode          fileMode
+	checksum      hash.Hash
+	cleanup       func() // What undoes a failed conversion, like removing the half-written file
+	removeCleanup func()
+}
+
+// Registers what undoes a failed conversion, which also runs when the program exits with an error
+func (o *jsonFileOutput) onFailure(cleanup func()) {
+	o.cleanup = cleanup
+	o.removeCleanup = addCleanup(cleanup)
+}
+
+// Undoes what a failed conversion wrote, like the cleanup of an error would
+func discardOutput(output io.WriteCloser) {
+	if o, ok := output.(*jsonFileOutput); ok && o.cleanup != nil {
+		o.removeCleanup()
+		o.cleanup()
+	}
+}
+
+func (o *jsonFileOutput) Write(p []byte) (int, error) {
+	return o.w.Write(p)
+}
+
+// Lets the buffer hand over big strings without copying them first
+func (o *jsonFileOutput) WriteString(data string) (int, error) {
+	return io.WriteString(o.w, data)
+}
+
+// Saves what was written so far to the disk, which checkpoints rely on
+func (o *jsonFileOutput) Sync() error {
+	return o.f.Sync()
+}
+
+// Closes the file, putting it in its final place and writing its checksum
+func (o *jsonFileOutput) Close() error {
+	if err := o.f.Close(); err != nil {
+		return err
+	}
+
+	if o.atomic {
+		if err := os.Rename(o.f.Name(), o.finalLocation); err != nil {
+			return err
+		}
+	}
+	o.removeCleanup()
+	o.cleanup = nil
+
+	// The checksum is only written once the JSON file is in its final place
+	if o.checksum != nil {
+		return writeChecksumFile(o.finalLocation, o.checksum, o.mode)
+	}
+
+	return nil
+}
+
+// An output that's not ours, like the terminal where --preview writes, so it's never closed
+type stdoutOutput struct {
+	io.Writer
+}
+
+func (stdoutOutput) Close() error {
+	return nil
+}
+
+// Opens where the JSON of the CSV file is written. When resuming, the existing file is kept up to the checkpoint
+// and written from there
+func createOutput(fileData inputFile) (io.WriteCloser, error) {
+	// A preview is only printed, so no file gets created
+	if fileData.preview > 0 {
+		return stdoutOutput{os.Stdout}, nil
+	}
+
+	// A dry validation throws away everything written
+	if fileData.dryValidate {
+		return stdoutOutput{io.Discard}, nil
+	}
+
+	output := &jsonFileOutput{finalLocation: outputLocation(fileData, fileData.outputSuffix), atomic: fileData.atomic, mode: fileData.outputMode, removeCleanup: func() {}}
+	resumeAt := fileData.resume.OutputBytes
+	appendAt := fileData.appendAt.offset
+
+	// With --create-dirs, the directories of the output are created first, like mkdir -p
+	if fileData.createDirs {
+		if err := os.MkdirAll(filepath.Dir(output.finalLocation), 0777); err != nil {
+			return nil, fmt.Errorf("The directory of %s can't be created: %v", output.finalLocation, err)
+		}
+	}
+
+	// With --checksum, everything that reaches the file is hashed on the way
+	if fileData.checksum == "sha256" {
+		output.checksum = sha256.New()
+	}
+
+	var f *os.File
+	var err error
+	if fileData.atomic {
+		// The temporary file only replaces the JSON file once it's complete. Renaming is atomic on the same
+		// filesystem, so nobody ever sees a half-written file. If something goes wrong, the temporary file is deleted
+		f, err = createTempFile(output.finalLocation, output.mode)
+		if err == nil {
+			output.onFailure(func() {
+				f.Close()
+				os.Remove(f.Name())
+			})
+		}
+
+		// The records appended go after a copy of the existing array, which is left as it is until the rename
+		if err == nil && appendAt > 0 {
+			err = copyArrayStart(output.finalLocation, appendAt, f, output.checksum)
+		}
+	} else if appendAt > 0 {
+		// The end of the array is dropped so the records go after the last one. A failed conversion puts it back
+		f, err = os.OpenFile(output.finalLocation, os.O_RDWR, 0666)
+		if err == nil && output.checksum != nil {
+			_, err = io.CopyN(output.checksum, f, appendAt)
+		}
+		if err == nil {
+			err = f.Truncate(appendAt)
+		}
+		if err == nil {
+			_, err = f.Seek(appendAt, io.SeekStart)
+		}
+		if err == nil {
+			output.onFailure(func() {
+				f.Close()
+				if err := restoreArrayEnd(output.finalLocation, fileData.appendAt); err != nil {
+					logger.errorf("The end of %s couldn't be put back: %v", output.finalLocation, err)
+				}
+			})
+		}
+	} else if resumeAt > 0 {
+		// Anything after resumeAt was written after the last checkpoint, so it gets dropped.
+		// What is kept is part of the final file, so it goes into the checksum too
+		f, err = os.OpenFile(output.finalLocation, os.O_RDWR, 0666)
+		if err == nil && output.checksum != nil {
+			_, err = io.CopyN(output.checksum, f, resumeAt)
+		}
+		if err == nil {
+			err = f.Truncate(resumeAt)
+		}
+		if err == nil {
+			_, err = f.Seek(resumeAt, io.SeekStart)
+		}
+	} else {
+		// A failed conversion doesn't leave a broken JSON file behind. With a checkpoint it does, since it's resumed later
+		f, err = output.mode.open(output.finalLocation, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+		if err == nil && fileData.checkpoint == "" {
+			output.onFailure(func() {
+				f.Close()
+				os.Remove(f.Name())
+			})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	output.f, output.w = f, f
+	if output.checksum != nil {
+		output.w = io.MultiWriter(f, output.checksum)
+	}
+
+	return output, nil
+}
+
+// Writes the checksum of a file into <file>.sha256, in the same format as sha256sum
+func writeChecksumFile(location string, outputHash hash.Hash, mode fileMode) error {
+	digest := hex.EncodeToString(outputHash.Sum(nil))
+	content := fmt.Sprintf("%s  %s\n", digest, filepath.Base(location))
+	if err := mode.writeFile(location+".sha256", []byte(content)); err != nil {
+		return err
+	}
+
+	logger.infof("SHA-256 of %s: %s", location, digest)
+
+	return nil
+}
+
+// Returns the order in which the values of a record are written. By default keys are sorted, like encoding/json does
+// with a map. csv keeps the order of the columns, and custom puts the keys of the list first, followed by the rest in
+// the order of the columns. When a header is repeated, only its last column is kept, just like in a map
+func getKeyOrder(headers []string, keyOrder string, keyList []string) []int {
+	last := make(map[string]int, len(headers))
+	for i, name := range headers {
+		last[name] = i
+	}
+
+	order := make([]int, 0, len(last))
+	for i, name := range headers {
+		if last[name] == i {
+			order = append(order, i)
+		}
+	}
+
+	switch keyOrder {
+	case "csv":
+	case "custom":
+		position := make(map[string]int, len(keyList))
+		for i, name := range keyList {
+			if _, listed := position[name]; !listed {
+				position[name] = i
+			}
+		}
+		rank := func(name string) int {
+			if i, listed := position[name]; listed {
+				return i
+			}
+			return len(keyList)
+		}
+		sort.SliceStable(order, func(i, j int) bool { return rank(headers[order[i]]) < rank(headers[order[j]]) })
+	default:
+		sort.Slice(order, func(i, j int) bool { return headers[order[i]] < headers[order[j]] })
+	}
+
+	return order
 }
 
-func exitGracefully(err error) {
-	fmt.Fprintf(os.Stderr, "error: %v\n", err)
-	os.Exit(1)
+// How strings are escaped in the output. The zero value escapes them just like json.Marshal
+type jsonEscaping struct {
+	noEscapeHTML bool // <, > and & are written as they are
+	ascii        bool // Every non-ASCII character is escaped as \uXXXX
 }
 
-func check(err error) {
-	if err != nil {
-		exitGracefully(err)
+// Appends s as a quoted JSON string. Plain text is copied as it is, anything that needs escaping is left to
+// encoding/json so that the output stays identical to json.Marshal
+func (e jsonEscaping) appendString(dst []byte, s string) []byte {
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c < 0x20 || c == '"' || c == '\\' || (!e.noEscapeHTML && (c == '<' || c == '>' || c == '&')) {
+				return e.appendEncoded(dst, s)
+			}
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if e.ascii || (r == utf8.RuneError && size == 1) || r == '\u2028' || r == '\u2029' {
+			return e.appendEncoded(dst, s)
+		}
+		i += size
 	}
+
+	dst = append(dst, '"')
+	dst = append(dst, s...)
+	return append(dst, '"')
 }
 
-func getFileData() (inputFile, error) {
-	// We need to validate that we're getting the correct number of arguments
-	if len(os.Args) < 2 {
-		return inputFile{}, errors.New("A filepath arguement is required")
+// Appends the JSON of any value encoded by encoding/json
+func (e jsonEscaping) appendEncoded(dst []byte, v interface{}) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(!e.noEscapeHTML)
+	if err := encoder.Encode(v); err != nil {
+		panic(err) // Only strings and our own reports are encoded, which can't fail
 	}
+	jsonData := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
 
-	// Defining option flags. For this, we're using the Flag package from the standard library
-	// We need to define three arguments: the flag's name, the default value,
-	// and a short description (displayed whith the option --help)
-	separator := flag.String("separator", "comma", "Column Separator")
-	pretty := flag.Bool("pretty", false, "Generate pretty JSON")
+	if !e.ascii {
+		return append(dst, jsonData...)
+	}
+	return appendASCII(dst, jsonData)
+}
+
+// Appends some JSON escaping its non-ASCII characters. Outside of strings JSON only has ASCII,
+// so every character written this way is part of a string
+func appendASCII(dst []byte, jsonData []byte) []byte {
+	for i := 0; i < len(jsonData); {
+		if jsonData[i] < utf8.RuneSelf {
+			dst = append(dst, jsonData[i])
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRune(jsonData[i:])
+		if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+			dst = append(dst, fmt.Sprintf(`\u%04x\u%04x`, r1, r2)...)
+		} else {
+			dst = append(dst, fmt.Sprintf(`\u%04x`, r)...)
+		}
+		i += size
+	}
+
+	return dst
+}
+
+// With pretty, every record starts with the given prefix, and its fields are indented one more level
+func getJSONFunc(fileData inputFile, prefix string) (func(record) string, string) {
+	// Declaring the variables we're going to return at the end
+	var jsonFunc func(record) string
+	var breakLine string
+	indent := fileData.indent
+
+	// With --wrap-key, every record is written one level deeper, as the only value of an object of its own
+	if fileData.wrapKey != "" {
+		return getWrappedJSONFunc(fileData, prefix)
+	}
+
+	appendValue := getValueEncoder(fileData)
+	appendString := fileData.escaping.appendString
+
+	// Every record of a file has the same headers, so the key order and the encoded keys are only computed
+	// for the first one. The buffer is reused between records to avoid allocating a new one for each
+	var order []int
+	var keys [][]byte
+	var padding []string
+	var nested []bool
+	var buf []byte
+	prepareKeys := func(headers []string) {
+		order = getKeyOrder(headers, fileData.keyOrder, fileData.keyOrderList)
+		keys = make([][]byte, len(headers))
+		for _, i := range order {
+			keys[i] = appendString(nil, fileData.keyPrefix+headers[i])
+		}
+
+		// The JSON values of --parse-json are indented along with the record
+		columnType := getColumnTypes(fileData)
+		nested = make([]bool, len(headers))
+		for _, i := range order {
+			t, mapped := columnType(headers[i])
+			nested[i] = mapped && t == typeJSON
+		}
+
+		// With --align, the values start after the widest key, counted in characters
+		padding = make([]string, len(headers))
+		if fileData.align && fileData.pretty {
+			width := 0
+			for _, i := range order {
+				if n := utf8.RuneCount(keys[i]); n > width {
+					width = n
+				}
+			}
+			for _, i := range order {
+				padding[i] = strings.Repeat(" ", width-utf8.RuneCount(keys[i]))
+			}
+		}
+	}
+
+	// With --extract-column, each record is the text of its only value, on a line of its own
+	if fileData.extractColumn != "" {
+		return func(rec record) string { return rec.values[0] }, "\n"
+	}
+
+	// With --scalar, each record is just the value of its only column
+	if fileData.scalar {
+		if fileData.pretty {
+			breakLine = "\n"
+		} else {
+			prefix = ""
+		}
+		jsonFunc = func(rec record) string {
+			buf = append(buf[:0], prefix...)
+			if rec.nulls != nil && rec.nulls[0] {
+				buf = append(buf, "null"...)
+			} else {
+				buf = appendValue(buf, rec.headers[0], rec.values[0])
+			}
+			return string(buf)
+		}
+	} else if fileData.pretty {
+		breakLine = "\n"
+		jsonFunc = func(rec record) string {
+			if order == nil {
+				prepareKeys(rec.headers)
+			}
+
+			buf = append(append(buf[:0], prefix...), '{')
+			for n, i := range order {
+				if n > 0 {
+					buf = append(buf, ',')
+				}
+				buf = append(append(append(buf, '\n'), prefix...), indent...)
+				buf = append(buf, keys[i]...)
+				buf = append(append(buf, ": "...), padding[i]...)
+				start := len(buf)
+				if rec.nulls != nil && rec.nulls[i] {
+					buf = append(buf, "null"...)
+					continue
+				}
+				buf = appendValue(buf, rec.headers[i], rec.values[i])
+				// The value was written by the json type, which only writes valid JSON
+				var indented bytes.Buffer
+				if nested[i] && (buf[start] == '{' || buf[start] == '[') && json.Indent(&indented, buf[start:], prefix+indent, indent) == nil {
+					buf = append(buf[:start], indented.Bytes()...)
+				}
+			}
+			if len(order) > 0 {
+				buf = append(append(buf, '\n'), prefix...)
+			}
+			buf = append(buf, '}')
+
+			return string(buf)
+		}
+	} else {
+		breakLine = ""
+		jsonFunc = func(rec record) string {
+			if order == nil {
+				prepareKeys(rec.headers)
+			}
 
-	flag.Parse() // This will parse all the arguments from the terminal
+			buf = append(buf[:0], '{')
+			for n, i := range order {
+				if n > 0 {
+					buf = append(buf, ',')
+				}
+				buf = append(buf, keys[i]...)
+				buf = append(buf, ':')
+				if rec.nulls != nil && rec.nulls[i] {
+					buf = append(buf, "null"...)
+				} else {
+					buf = appendValue(buf, rec.headers[i], rec.values[i])
+				}
+			}
+			buf = append(buf, '}')
 
-	fileLocation := flag.Arg(0) // The only argument (that is not a flag option) is the file location (CSV file)
+			return string(buf)
+		}
+	}
 
-	if !(*separator == "comma" || *separator == "semicolon") {
-		return inputFile{}, errors.New("Only comma or semicolon separators are allowed")
+	// The compact records can still be written one per line, which line based tools handle much better
+	if fileData.recordPerLine {
+		breakLine = "\n"
 	}
 
-	return inputFile{fileLocation, *separator, *pretty}, nil
+	return jsonFunc, breakLine
 }
 
-func checkIfValidFile(filename string) (bool, error) {
-	if fileExtension := filepath.Ext(filename); fileExtension != ".csv" {
-		return false, fmt.Errorf("File %s is not CSV", filename)
+// Returns the JSON function of getJSONFunc for records nested inside an object, under the key of --wrap-key
+func getWrappedJSONFunc(fileData inputFile, prefix string) (func(record) string, string) {
+	wrapKey := string(fileData.escaping.appendString(nil, fileData.wrapKey))
+	inner := fileData
+	inner.wrapKey = ""
+
+	if !fileData.pretty {
+		innerFunc, breakLine := getJSONFunc(inner, prefix)
+		return func(rec record) string {
+			return "{" + wrapKey + ":" + innerFunc(rec) + "}"
+		}, breakLine
 	}
 
-	if _, err := os.Stat(filename); err != nil && os.IsNotExist(err) {
-		return false, fmt.Errorf("File %s does not exist", filename)
+	// The record comes with the prefix of its own level, which is replaced by the key
+	innerPrefix := prefix + fileData.indent
+	innerFunc, breakLine := getJSONFunc(inner, innerPrefix)
+	return func(rec record) string {
+		return prefix + "{\n" + innerPrefix + wrapKey + ": " + innerFunc(rec)[len(innerPrefix):] + "\n" + prefix + "}"
+	}, breakLine
+}
+
+// Returns what the JSON file starts with. Usually a "[", since we always generate an array of records,
+// and an object holding the array (and the metadata with --extract-meta) with --root-key
+func getJSONStart(fileData inputFile, meta []metaField, breakLine string) string {
+	// A template is a single object, and the text of --extract-column is only its lines
+	if fileData.template || fileData.extractColumn != "" {
+		return ""
 	}
 
-	return true, nil
+	if fileData.rootKey == "" {
+		return "[" + breakLine
+	}
+
+	indent, colon := "", ":"
+	if fileData.pretty {
+		indent, colon = fileData.indent, ": "
+	}
+
+	appendString := fileData.escaping.appendString
+	buf := []byte("{" + breakLine)
+	if fileData.extractMeta {
+		buf = append(appendString(append(buf, indent...), "meta"), colon+"{"...)
+		for i, field := range meta {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, breakLine+indent+indent...)
+			buf = append(appendString(buf, field.key), colon...)
+			buf = appendString(buf, field.value)
+		}
+		if len(meta) > 0 {
+			buf = append(buf, breakLine+indent...)
+		}
+		buf = append(buf, "},"+breakLine...)
+	}
+	buf = append(appendString(append(buf, indent...), fileData.rootKey), colon+"["+breakLine...)
+
+	return string(buf)
 }
 
-func processLine(headers []string, dataList []string) (map[string]string, error) {
-	// Validating if we're getting the same number of headers and columns. Otherwise, we return an error
-	if len(headers) != len(dataList) {
-		return nil, errors.New("Line doesn't match headers format. Skipping")
+// Returns what the JSON file ends with, closing what getJSONStart opened. When the records are on lines of their
+// own, the closing bracket is too, like the opening one, at the depth of the array
+func getJSONEnd(fileData inputFile, breakLine string, empty bool) string {
+	indent := ""
+	if fileData.pretty {
+		indent = fileData.indent
+	}
+	lastRecord := breakLine
+	if empty {
+		lastRecord = ""
 	}
 
-	recordMap := make(map[string]string)
+	end := lastRecord + indent + "]" + breakLine + "}"
+	if fileData.template || fileData.extractColumn != "" {
+		end = ""
+	} else if fileData.rootKey == "" {
+		end = lastRecord + "]"
+	}
 
-	for i, name := range headers {
-		recordMap[name] = dataList[i]
+	// Like any text file, the output ends with a newline unless asked otherwise
+	if !fileData.noTrailingNewline {
+		end += "\n"
 	}
 
-	return recordMap, nil
+	return end
 }
 
-func processCsvFile(fileData inputFile, writerChannel chan<- map[string]string) {
-	file, err := os.Open(fileData.filepath)
+// Validates if the options keep something of every record in memory until the file is read, so the memory needed
+// grows with the file instead of staying the same. --sort-by and --keep=last keep the records, --diff-against the
+// ones of both files, and --unique-check and --dedupe-key the values of their columns. Profiles are not included,
+// since they stop counting the distinct values at --profile-distinct-cap
+func holdsEveryRecord(fileData inputFile) bool {
+	return fileData.sortBy.column != "" || len(fileData.uniqueColumns) > 0 || len(fileData.uniqueKey) > 0 || fileData.dedupeKey != "" || fileData.diffAgainst != ""
+}
 
-	check(err)
+// Writes the records received through writerChannel as JSON into w. Small fragments like "[" or "," are gathered
+// in a buffer of --write-buffer bytes, so w gets written in big chunks, and the buffer is flushed at the end.
+// With a checkpoint, resumeHash has the output written before it (nil when starting from the beginning),
+// and w is synced to the disk before saving every checkpoint when it has a Sync method.
+// Returns the number of records in the output, counting the ones written before the checkpoint
+func writeJSON(ctx context.Context, fileData inputFile, w io.Writer, writerChannel <-chan recordBatch, resumeHash hash.Hash) (int64, error) {
+	// Everything written is hashed and counted, so checkpoints can tell what the output looked like when they were made
+	state := fileData.resume
+	outputHash := resumeHash
+	if outputHash == nil {
+		outputHash = sha256.New()
+	}
+	out := w
+	if fileData.checkpoint != "" {
+		out = io.MultiWriter(w, outputHash)
+	}
+	bw := bufio.NewWriterSize(out, fileData.writeBuffer)
 
-	// Don't forget to close the file once everything is done
-	defer file.Close()
+	// Sorting needs every record, so they are all collected before anything gets written
+	if fileData.sortBy.column != "" {
+		sorted, err := sortRecords(ctx, fileData.sortBy, writerChannel)
+		if err != nil {
+			return 0, err
+		}
+		sortedChannel := make(chan recordBatch, 1)
+		sortedChannel <- sorted
+		close(sortedChannel)
+		writerChannel = sortedChannel
+	}
 
-	var headers, line []string
+	// The first error stops every write after it. The JSON only has line breaks between its values, since the ones
+	// inside the strings are escaped, so all of them can be changed into the line ending of --line-ending
+	var err error
+	writeString := func(data string) {
+		if err == nil {
+			if fileData.lineEnding != "\n" {
+				data = strings.ReplaceAll(data, "\n", fileData.lineEnding)
+			}
+			_, err = bw.WriteString(data)
+			state.OutputBytes += int64(len(data))
+		}
+	}
 
-	reader := csv.NewReader(file)
+	// Instantiating the JSON parse function and the breakline character.
+	// Inside an object, the records are one level deeper
+	prefix := fileData.indent
+	if fileData.template {
+		prefix = ""
+	} else if fileData.rootKey != "" {
+		prefix += fileData.indent
+	}
+	jsonFunc, breakLine := getJSONFunc(fileData, prefix)
 
-	if fileData.separator == "semicolon" {
-		reader.Comma = ';'
+	// The lines of --extract-column are only separated by their line breaks, and a file without any is empty
+	separator := "," + breakLine
+	if fileData.extractColumn != "" {
+		separator = breakLine
 	}
 
-	// Reading the first line, where we will find our headers
-	headers, err = reader.Read()
-	check(err)
+	// The JSON starts when the first batch arrives, since it may bring the metadata. A resumed file
+	// already has its start, along with the records written before the checkpoint
+	started := state.OutputBytes > 0 || fileData.appendAt.offset > 0
+	first := state.Records == 0 && fileData.appendAt.offset == 0
+	sinceCheckpoint, sinceFlush := int64(0), int64(0)
 
-	// Now we're going to iterate over each line from the CSV file
 	for {
-		// We read one row (line) from the CSV.
-		// This line is a string slice, with each element representing a column
-		line, err = reader.Read()
+		// Waiting for pushed batches of records into our writerChannel, or for the conversion to be cancelled.
+		// A cancellation is checked first, since the next batch may be ready too
+		if err := ctx.Err(); err != nil {
+			return state.Records, err
+		}
+		var batch recordBatch
+		var more bool
+		select {
+		case batch, more = <-writerChannel:
+		case <-ctx.Done():
+			return state.Records, ctx.Err()
+		}
 
-		// If we get to End of the File, we close the channel and break the for-loop
-		if err == io.EOF {
-			close(writerChannel)
-			break
+		if !started {
+			writeString(getJSONStart(fileData, batch.meta, breakLine))
+			started = true
 		}
 
-		// If this happens, we got an unexpected error
-		if err != nil {
-			exitGracefully(err)
+		if !more {
+			if fileData.extractColumn == "" || !first {
+				writeString(getJSONEnd(fileData, breakLine, first))
+			}
+			if err != nil {
+				return state.Records, err
+			}
+			return state.Records, bw.Flush()
 		}
 
-		// Processiong a CSV line
-		record, err := processLine(headers, line)
+		for _, record := range batch.records {
+			if !first {
+				writeString(separator)
+			} else {
+				first = false
+			}
 
-		// If we get an error here, it means we got a wrong number of columns, so we skip this line
+			writeString(jsonFunc(record)) // Writing the JSON string into the buffer
+
+			// With --flush-every, the records reach the file every N records, instead of waiting for the buffer to fill up
+			if sinceFlush++; fileData.flushEvery > 0 && sinceFlush == fileData.flushEvery && err == nil {
+				err = bw.Flush()
+				sinceFlush = 0
+			}
+		}
 		if err != nil {
-			fmt.Printf("Line: %sError: %s\n", line, err)
-			continue
+			return state.Records, err
 		}
 
-		writerChannel <- record
+		// The output ends right after a record here, so it's a point we can resume from
+		state.Records += int64(len(batch.records))
+		sinceCheckpoint += int64(len(batch.records))
+		if fileData.checkpoint != "" && sinceCheckpoint >= fileData.checkpointEvery {
+			if err := bw.Flush(); err != nil {
+				return state.Records, err
+			}
+			if syncer, ok := w.(interface{ Sync() error }); ok {
+				if err := syncer.Sync(); err != nil {
+					return state.Records, err
+				}
+			}
+			state.InputOffset = batch.offset
+			state.OutputSHA256 = hex.EncodeToString(outputHash.Sum(nil))
+			if err := saveCheckpoint(fileData.checkpoint, state); err != nil {
+				return state.Records, err
+			}
+			sinceCheckpoint = 0
+		}
 	}
 }
 
-func createStringWriter(csvPath string) func(string, bool) {
-	jsonDir := filepath.Dir(csvPath)
-	jsonName := fmt.Sprintf("%s.json", strings.TrimSuffix(filepath.Base(csvPath), ".csv"))
+// Errors of the conversions stopped by Ctrl-C
+var errInterrupted = errors.New("interrupted")
 
-	finalLocation := filepath.Join(jsonDir, jsonName)
+// Returns the error of a JSON file that couldn't be written, telling apart the conversions interrupted by Ctrl-C
+func writeError(fileData inputFile, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("The conversion of %s was %w", fileData.filepath, errInterrupted)
+	}
+	return err
+}
 
-	f, err := os.Create(finalLocation)
-	check(err)
+// Writes the JSON file, returning the number of records it has once it's complete. When the conversion fails,
+// the JSON file is left like it was before it
+func writeJSONFile(ctx context.Context, fileData inputFile, writerChannel <-chan recordBatch) (int64, error) {
+	// When resuming, the existing output must still be the one the checkpoint was made with
+	var resumeHash hash.Hash
+	if fileData.resume.OutputBytes > 0 {
+		resumeHash = sha256.New()
+		if err := verifyPartialOutput(outputLocation(fileData, fileData.outputSuffix), fileData.resume, resumeHash); err != nil {
+			return 0, err
+		}
+	}
 
-	return func(data string, close bool) {
-		_, err := f.WriteString(data)
-		check(err)
+	if holdsEveryRecord(fileData) {
+		logger.debugf("The records of %s are kept in memory until it's read", fileData.filepath)
+	}
 
-		if close {
-			f.Close()
+	// With --split-records, the records go into several files instead
+	if fileData.splitRecords > 0 {
+		logger.infof("Writing JSON files...")
+		records, err := writeShards(ctx, fileData, writerChannel)
+		if err != nil {
+			return records, err
 		}
+		logger.summaryf("Completed!")
+		return records, nil
 	}
-}
 
-func getJSONFunc(pretty bool) (func(map[string]string) string, string) {
-	// Declaring the variables we're going to return at the end
-	var jsonFunc func(map[string]string) string
-	var breakLine string
+	// With --append, the existing array is checked before anything gets written to it
+	if fileData.appendRecords {
+		point, err := findAppendPoint(outputLocation(fileData, fileData.outputSuffix))
+		if err != nil {
+			return 0, err
+		}
+		fileData.appendAt = point
+	}
 
-	if pretty {
-		breakLine = "\n"
-		jsonFunc = func(record map[string]string) string {
-			jsonData, _ := json.MarshalIndent(record, "   ", "   ")
-			return "   " + string(jsonData)
+	// The previous records are read first, since the previous file may be the one being written
+	var previous keyedRecords
+	if fileData.diffAgainst != "" {
+		var err error
+		if previous, err = loadKeyedRecords(fileData.diffAgainst, fileData.keyColumn); err != nil {
+			return 0, err
 		}
+	}
+
+	output, err := createOutput(fileData)
+	if err != nil {
+		return 0, err
+	}
+
+	if fileData.dryValidate {
+		logger.infof("Validating records...")
 	} else {
-		breakLine = ""
-		jsonFunc = func(record map[string]string) string {
-			jsonData, _ := json.Marshal(record)
-			return string(jsonData)
+		logger.infof("Writing JSON file...")
+	}
+
+	records, err := writeJSON(ctx, fileData, output, writerChannel, resumeHash)
+	if err == nil {
+		err = output.Close()
+	}
+	if err != nil {
+		discardOutput(output)
+		return records, writeError(fileData, err)
+	}
+
+	// A finished conversion has nothing left to resume
+	if fileData.checkpoint != "" {
+		if err := os.Remove(fileData.checkpoint); err != nil && !os.IsNotExist(err) {
+			return records, err
 		}
 	}
 
-	return jsonFunc, breakLine
+	if fileData.diffAgainst != "" {
+		jsonPath := outputLocation(fileData, fileData.outputSuffix)
+		current, err := loadKeyedRecords(jsonPath, fileData.keyColumn)
+		if err != nil {
+			return records, err
+		}
+		if err := writeDiff(jsonPath, diffRecords(previous, current), fileData); err != nil {
+			return records, err
+		}
+	}
+	logger.summaryf("Completed!")
+	return records, nil
 }
 
-func writeJSONFile(csvPath string, writerChannel <-chan map[string]string, done chan<- bool, pretty bool) {
-	// Instantiating a JSON writer function
-	writeString := createStringWriter(csvPath)
+// Reads a CSV file from file while write writes its records, returning what write returns. When the reading fails,
+// the writing is stopped and the error of the reading is returned instead. Nothing is left running either way
+func runConversion(ctx context.Context, fileData inputFile, file io.ReadSeeker, write func(context.Context, <-chan recordBatch) (int64, error)) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// Instantiating the JSON parse function and the breakline character
-	jsonFunc, breakLine := getJSONFunc(pretty)
+	writerChannel := make(chan recordBatch, writerChannelBuffer)
+	readErr := make(chan error, 1)
+	go func() {
+		err := processCsv(ctx, fileData, file, writerChannel)
+		if err != nil {
+			cancel()
+		}
+		readErr <- err
+	}()
 
-	fmt.Println("Writing JSON file...")
+	records, err := write(ctx, writerChannel)
+	cancel()
+	if readErr := <-readErr; readErr != nil {
+		return records, readErr
+	}
+	return records, err
+}
 
-	// Writing the first character of our JSON file. We always start with a "[" since we always generate array of record
-	writeString("["+breakLine, false)
-	first := true
+// Converts a CSV file, writing its JSON into w instead of the JSON file
+func convertTo(fileData inputFile, w io.Writer) error {
+	return convertToContext(context.Background(), fileData, w)
+}
 
-	for {
-		// Waiting for pushed records into our writerChannel
-		record, more := <-writerChannel
+// Same as convertTo, stopping with the error of ctx once it's done
+func convertToContext(ctx context.Context, fileData inputFile, w io.Writer) error {
+	file, err := os.Open(fileData.filepath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-		if more {
-			if !first {
-				writeString(","+breakLine, false)
-			} else {
-				first = false
-			}
+	return convertReader(ctx, fileData, file, w)
+}
 
-			jsonData := jsonFunc(record)
-			writeString(jsonData, false) // Writing the JSON string with our writer function
-		} else {
-			writeString("]"+breakLine, true)
-			fmt.Println("Completed!")
-			done <- true // Sending the signal to the main function so it can correctly exit out.
-			break
-		}
+// Converts the CSV read from r, writing its JSON into w. fileData.filepath only names it in the messages
+func convertReader(ctx context.Context, fileData inputFile, r io.ReadSeeker, w io.Writer) error {
+	_, err := runConversion(ctx, fileData, r, func(ctx context.Context, writerChannel <-chan recordBatch) (int64, error) {
+		return writeJSON(ctx, fileData, w, writerChannel, nil)
+	})
+	return err
+}
+
+// Converts a single CSV file into its JSON file, returning the number of records written
+func convertFile(fileData inputFile) (int64, error) {
+	return convertFileContext(context.Background(), fileData)
+}
+
+// Same as convertFile, stopping the conversion once ctx is done
+func convertFileContext(ctx context.Context, fileData inputFile) (int64, error) {
+	var started time.Time
+	if fileData.timing {
+		fileData.bytesRead = new(int64)
+		started = time.Now()
+	}
+
+	file, err := os.Open(fileData.filepath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	records, err := runConversion(ctx, fileData, file, func(ctx context.Context, writerChannel <-chan recordBatch) (int64, error) {
+		return writeJSONFile(ctx, fileData, writerChannel)
+	})
+	if err != nil {
+		return records, err
+	}
+	if fileData.timing {
+		logger.infof("%s", timingSummary(fileData.filepath, time.Since(started), records, atomic.LoadInt64(fileData.bytesRead)))
 	}
+	return records, nil
 }
 
 func main() {
 	// Showing useful information when the user enters the --help option
 	flag.Usage = func() {
-		fmt.Printf("Usage %s [options] <csvFile>\nOptions:\n", os.Args[0])
-		flag.PrintDefaults()
+		fmt.Printf("Usage %s [options] <csvFile>... [options]\nOptions:\n", os.Args[0])
+		printUsage(os.Stdout, flag.CommandLine)
 	}
 
 	// Getting the file data that was entered by the user
@@ -219,19 +3938,192 @@ func main() {
 		exitGracefully(err)
 	}
 
-	// Validating the file entered
-	if _, err := checkIfValidFile(fileData.filepath); err != nil {
-		exitGracefully(err)
+	logger.configure(fileData.logLevel, fileData.logFormat, useColor(fileData.noColor, os.Stderr))
+
+	// Counting what happened to every file, so the summary can tell them apart
+	converted, upToDate, declined, failed := 0, 0, 0, 0
+	summarize := len(fileData.filepaths) > 1 || fileData.ifNewer || fileData.filesFromStdin
+
+	// The lines skipped by every file are added to the error file, so the one of a previous run goes away first
+	if fileData.errorFile != "" {
+		if err := os.Remove(fileData.errorFile); err != nil && !os.IsNotExist(err) {
+			exitGracefully(err)
+		}
+	}
+
+	// Big files are only converted once the user confirms, when there's a user to ask
+	// When stdin gave the list of files, there's nobody there to answer. JSON files are not sampled
+	askConfirmation := fileData.confirmAbove > 0 && !fileData.yes && fileData.preview == 0 && fileData.previewFirst == 0 && !fileData.dryValidate && !fileData.filesFromStdin && !fileData.reverse && isTerminal(os.Stdin)
+	answers := bufio.NewReader(os.Stdin)
+
+	// --separator-fallback may change the separator of a file, which must not carry over to the next one
+	separator, separatorGiven := fileData.separator, fileData.separatorGiven
+
+	// With --report, a conversion that ends the program still gets reported, through a cleanup
+	report := newConversionReport(fileData)
+	removeReport := func() {}
+	if fileData.report != "" {
+		removeReport = addCleanup(func() {
+			report.finish(0, fatalError)
+			if err := report.write(fileData); err != nil {
+				logger.errorf("The report can't be written: %v", err)
+			}
+		})
+	}
+	fail := func(err error) {
+		logger.errorf("%v", err)
+		report.finish(0, err)
+		failed++
+	}
+
+	// The URLs given as files are downloaded before they are converted
+	downloads := &downloadDir{}
+
+	for _, path := range fileData.filepaths {
+		fileData.filepath = path
+		fileData.skipped = report.start(fileData)
+
+		// A URL is downloaded first, and then converted like the file it downloaded into
+		if !fileData.reverse && isURL(path) {
+			if err := checkURL(path, fileData); err != nil {
+				fail(err)
+				continue
+			}
+			local, err := downloads.fetch(path, fileData)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			path, fileData.filepath = local, local
+		}
+
+		// Validating the file entered. An invalid file doesn't stop the other ones from being converted
+		validate := func(filename string, forceExtension bool) (bool, error) {
+			return checkIfValidFile(filename, forceExtension, fileData.fixedWidths != nil)
+		}
+		if fileData.reverse {
+			validate = checkIfValidJSONFile
+		}
+		if _, err := validate(path, fileData.forceExtension); err != nil {
+			fail(err)
+			continue
+		}
+		pipe := !fileData.reverse && isNamedPipe(path)
+		if pipe {
+			if err := checkPipe(fileData); err != nil {
+				fail(err)
+				continue
+			}
+		}
+
+		// With --separator-fallback, the file is read with the first separator that finds more than one column
+		if fileData.separatorFallback != nil {
+			fileData.separator, fileData.separatorGiven = separator, separatorGiven
+			picked, err := pickSeparator(fileData)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			fileData.separator, fileData.separatorGiven = picked.separator, picked.separatorGiven
+		}
+
+		// A suffix like .csv would write the JSON over the file being read
+		if outputLocation(fileData, fileData.outputSuffix) == filepath.Clean(path) {
+			fail(fmt.Errorf("The output of %s would overwrite it. Use another output suffix", path))
+			continue
+		}
+
+		// A pipe has new records every time
+		if fileData.ifNewer && !fileData.force && fileData.preview == 0 && !fileData.dryValidate && !pipe {
+			skip, err := isUpToDate(path, outputLocation(fileData, fileData.outputSuffix))
+			if err != nil {
+				fail(err)
+				continue
+			}
+			if skip {
+				logger.infof("%s is up to date", outputLocation(fileData, fileData.outputSuffix))
+				report.skip("up to date")
+				upToDate++
+				continue
+			}
+		}
+
+		if askConfirmation {
+			proceed, err := confirmConversion(fileData, answers, os.Stdout)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			if !proceed {
+				logger.infof("Skipping %s", path)
+				report.skip("declined")
+				declined++
+				continue
+			}
+		}
+
+		// The preview of --preview-confirm takes the place of the confirmation of big files
+		if fileData.previewFirst > 0 {
+			ask := !fileData.yes && !fileData.filesFromStdin && isTerminal(os.Stdin)
+			proceed, err := previewConversion(fileData, ask, answers, os.Stderr)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			if !proceed {
+				logger.infof("Skipping %s", path)
+				report.skip("declined")
+				declined++
+				continue
+			}
+		}
+
+		// Ctrl-C stops the conversion through its context, so the partial output is cleaned up. Once it's done,
+		// Ctrl-C goes back to ending the program right away, like while asking for a confirmation
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		var records int64
+		if fileData.reverse {
+			err = reverseFile(ctx, fileData)
+		} else {
+			records, err = convertFileContext(ctx, fileData)
+		}
+		stop()
+		// A file that can't be converted doesn't stop the other ones, but Ctrl-C stops the whole batch
+		if err != nil {
+			fail(err)
+			if errors.Is(err, errInterrupted) {
+				break
+			}
+			continue
+		}
+		if !fileData.reverse && records == 0 && fileData.failOnEmpty {
+			// The output is still a valid JSON file, but a filter that keeps nothing is most likely a mistake
+			fail(fmt.Errorf("No records were written for %s", path))
+			continue
+		}
+		report.finish(records, nil)
+		converted++
+		if summarize {
+			logger.infof("Converted %s", path)
+		}
 	}
 
-	// Declaring the channels that our go-routines are going to use
-	writerChannel := make(chan map[string]string)
-	done := make(chan bool)
+	downloads.remove()
+
+	if summarize {
+		summary := fmt.Sprintf("%d converted, %d skipped as up to date, %d failed", converted, upToDate, failed)
+		if declined > 0 {
+			summary += fmt.Sprintf(", %d declined", declined)
+		}
+		logger.summaryf("%s", summary)
+	}
 
-	// Running both of our go-routines, the first one responsible for reading and the second one for writing
-	go processCsvFile(fileData, writerChannel)
-	go writeJSONFile(fileData.filepath, writerChannel, done, fileData.pretty)
+	if fileData.report != "" {
+		removeReport()
+		check(report.write(fileData))
+	}
 
-	// Waiting for the done channel to receive a value, so that we can terminate the programn execution
-	<-done
+	if failed > 0 {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,141 @@
+//go:build slow && !windows
+// +build slow,!windows
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Size of the CSV converted by the tests of big files, run with go test -tags slow -timeout 30m
+const slowFileBytes = 4 << 30
+
+// Most memory the conversion of a big file can use, however big the file is
+const slowHeapLimit = 32 << 20
+
+// Generates a CSV file of at least size bytes as it's read, so it never has to be written anywhere
+type syntheticCSV struct {
+	size    int64
+	read    int64
+	rows    int64
+	line    []byte
+	pending []byte
+}
+
+func (s *syntheticCSV) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 {
+		if s.read >= s.size {
+			return 0, io.EOF
+		}
+		if s.read == 0 {
+			s.line = append(s.line[:0], "id,name,price,comment\n"...)
+		} else {
+			s.rows++
+			s.line = strconv.AppendInt(s.line[:0], s.rows, 10)
+			s.line = append(s.line, ",name "...)
+			s.line = strconv.AppendInt(s.line, s.rows%1000, 10)
+			s.line = append(s.line, ',')
+			s.line = strconv.AppendFloat(s.line, float64(s.rows%10000)/100, 'f', 2, 64)
+			s.line = append(s.line, ",\"a comment, with a separator\"\n"...)
+		}
+		s.pending = s.line
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	s.read += int64(n)
+	return n, nil
+}
+
+// Counts the records of the output, which are the only objects in it since the values have no braces
+type recordCounter struct {
+	records int64
+}
+
+func (c *recordCounter) Write(p []byte) (int, error) {
+	c.records += int64(bytes.Count(p, []byte("{")))
+	return len(p), nil
+}
+
+// Keeps the biggest heap seen until stop is called
+func watchHeap() (stop func() uint64) {
+	done := make(chan uint64)
+	quit := make(chan bool)
+	go func() {
+		var stats runtime.MemStats
+		var peak uint64
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > peak {
+				peak = stats.HeapAlloc
+			}
+			select {
+			case <-quit:
+				done <- peak
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() uint64 {
+		quit <- true
+		return <-done
+	}
+}
+
+func Test_bigFileMemory(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileData inputFile
+	}{
+		{"Default options", defaultFileData},
+		{"Pretty with filters", withOptions(func(f *inputFile) {
+			f.pretty, f.rangeFilters, f.redactions = true, []rangeFilter{{"price", 0, 100}}, []redaction{{"name", 2}}
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if holdsEveryRecord(tt.fileData) {
+				t.Skip("These options keep every record in memory")
+			}
+
+			// The file is read through a named pipe, like any other file, while it's being generated
+			pipePath := filepath.Join(t.TempDir(), "big.csv")
+			check(syscall.Mkfifo(pipePath, 0666))
+			source := &syntheticCSV{size: slowFileBytes}
+			go func() {
+				pipe, err := os.OpenFile(pipePath, os.O_WRONLY, 0)
+				check(err)
+				defer pipe.Close()
+				_, err = io.Copy(pipe, source)
+				check(err)
+			}()
+
+			runtime.GC()
+			stop := watchHeap()
+			tt.fileData.filepath = pipePath
+			var counter recordCounter
+			check(convertTo(tt.fileData, &counter))
+			peak := stop()
+
+			if counter.records != source.rows {
+				t.Errorf("records = %d, want %d", counter.records, source.rows)
+			}
+			if peak > slowHeapLimit {
+				t.Errorf("peak heap = %d MB, want at most %d MB", peak>>20, slowHeapLimit>>20)
+			}
+			t.Logf("%d records from %d MB, with a peak heap of %d MB", source.rows, source.read>>20, peak>>20)
+		})
+	}
+}
@@ -1,15 +1,122 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"go.uber.org/goleak"
 )
 
+// The inputFile getFileData returns when only the file path is given
+var defaultFileData = inputFile{
+	filepath:         "test.csv",
+	filepaths:        []string{"test.csv"},
+	separator:        "comma",
+	batchSize:        defaultBatchSize,
+	logLevel:         levelInfo,
+	logFormat:        "text",
+	writeBuffer:      defaultWriteBuffer,
+	readBuffer:       defaultReadBuffer,
+	maxFieldBytes:    defaultMaxFieldBytes,
+	maxColumns:       defaultMaxColumns,
+	checkpointEvery:  defaultCheckpointEvery,
+	indent:           defaultIndent,
+	confirmAbove:     defaultConfirmAbove,
+	redactMask:       "***",
+	concatSep:        " ",
+	percentMode:      "fraction",
+	currencySymbols:  defaultCurrencySymbols,
+	geoInvalid:       "null",
+	decodeInvalid:    "error",
+	decodeBinary:     "keep",
+	parseJSONInvalid: "error",
+	parseJSONDepth:   defaultParseJSONDepth,
+	parseJSONBytes:   defaultParseJSONBytes,
+	retries:          defaultRetries,
+	retryDelay:       defaultRetryDelay,
+	profileCap:       defaultProfileDistinctCap,
+	outputSuffix:     ".json",
+	ragged:           "error",
+	keyOrder:         "alpha",
+	sampleEvery:      1,
+	sampleRate:       1,
+	newlineHandling:  "keep",
+	skipBlankRows:    true,
+	quoteMode:        "minimal",
+	widthUnit:        "rune",
+	reuseRecord:      true,
+	dedupeKeep:       "first",
+	lineEnding:       "\n",
+}
+
+// Converts a CSV file into its JSON file, failing the test when the conversion fails
+func mustConvertFile(t *testing.T, fileData inputFile) int64 {
+	t.Helper()
+	records, err := convertFile(fileData)
+	if err != nil {
+		t.Fatalf("convertFile() error = %v", err)
+	}
+	return records
+}
+
+// Returns a copy of defaultFileData with the changes made by the given function
+func withOptions(change func(fileData *inputFile)) inputFile {
+	fileData := defaultFileData
+	change(&fileData)
+	return fileData
+}
+
+// Converts a CSV string with the given options, and returns the JSON written in place of the file
+func convertString(t *testing.T, csvString string, fileData inputFile) string {
+	t.Helper()
+	fileData.filepath = filepath.Join(t.TempDir(), "data.csv")
+	check(ioutil.WriteFile(fileData.filepath, []byte(csvString), 0666))
+
+	var buf bytes.Buffer
+	if err := convertTo(fileData, &buf); err != nil {
+		t.Fatalf("convertTo() error = %v", err)
+	}
+	return buf.String()
+}
+
 func Test_getFileData(t *testing.T) {
+	// Creating a columns file for the columns-file option
+	columnsFile, err := ioutil.TempFile("", "columns*.txt")
+	check(err)
+	defer os.Remove(columnsFile.Name())
+	columnsFile.WriteString("id\n  name \n\n\tprice\n")
+	columnsFile.Close()
+
+	schema, err := loadSchema(filepath.Join("testJsonFiles", "schema.json"))
+	check(err)
+
+	// Creating a spec file for the fixed-widths-file option
+	specFile, err := ioutil.TempFile("", "spec*.txt")
+	check(err)
+	defer os.Remove(specFile.Name())
+	specFile.WriteString("# name width\nid 6\n\nfull name\t20\n")
+	specFile.Close()
+
 	tests := []struct {
 		name    string
 		want    inputFile
@@ -17,24 +124,297 @@ func Test_getFileData(t *testing.T) {
 		osArgs  []string
 	}{
 		// Here we're declaring each unit test input and output data as defined before
-		{"Default parameters", inputFile{"test.csv", "comma", false}, false, []string{"cmd", "test.csv"}},
+		{"Default parameters", defaultFileData, false, []string{"cmd", "test.csv"}},
 		{"No parameters", inputFile{}, true, []string{"cmd"}},
-		{"Semicolon enabled", inputFile{"test.csv", "semicolon", false}, false, []string{"cmd", "--separator=semicolon", "test.csv"}},
-		{"Pretty enabled", inputFile{"test.csv", "comma", true}, false, []string{"cmd", "--pretty", "test.csv"}},
-		{"Pretty and semicolon enabled", inputFile{"test.csv", "semicolon", true}, false, []string{"cmd", "--pretty", "--separator=semicolon", "test.csv"}},
+		{"Semicolon enabled", withOptions(func(f *inputFile) { f.separator, f.separatorGiven = "semicolon", true }), false, []string{"cmd", "--separator=semicolon", "test.csv"}},
+		{"Pretty enabled", withOptions(func(f *inputFile) { f.pretty = true }), false, []string{"cmd", "--pretty", "test.csv"}},
+		{"Pretty and semicolon enabled", withOptions(func(f *inputFile) { f.pretty, f.separator, f.separatorGiven = true, "semicolon", true }), false, []string{"cmd", "--pretty", "--separator=semicolon", "test.csv"}},
 		{"Separator not identified", inputFile{}, true, []string{"cmd", "--separator=pipe", "test.csv"}},
+		{"Multi-character separator", withOptions(func(f *inputFile) { f.separator, f.separatorGiven = "~|~", true }), false, []string{"cmd", "--separator=~|~", "test.csv"}},
+		{"Separator with a quote", inputFile{}, true, []string{"cmd", `--separator=|"`, "test.csv"}},
+		{"Batch size set", withOptions(func(f *inputFile) { f.batchSize = 10 }), false, []string{"cmd", "--batch-size=10", "test.csv"}},
+		{"Batch size too small", inputFile{}, true, []string{"cmd", "--batch-size=0", "test.csv"}},
+		{"Comma separator given", withOptions(func(f *inputFile) { f.separatorGiven = true }), false, []string{"cmd", "--separator=comma", "test.csv"}},
+		{"Debug logs", withOptions(func(f *inputFile) { f.logLevel = levelDebug }), false, []string{"cmd", "--log-level=debug", "test.csv"}},
+		{"Log level not identified", inputFile{}, true, []string{"cmd", "--log-level=verbose", "test.csv"}},
+		{"JSON logs", withOptions(func(f *inputFile) { f.logFormat = "json" }), false, []string{"cmd", "--log-format=json", "test.csv"}},
+		{"Log format not identified", inputFile{}, true, []string{"cmd", "--log-format=xml", "test.csv"}},
+		{"Write buffer set", withOptions(func(f *inputFile) { f.writeBuffer = 1024 }), false, []string{"cmd", "--write-buffer=1024", "test.csv"}},
+		{"Write buffer too small", inputFile{}, true, []string{"cmd", "--write-buffer=0", "test.csv"}},
+		{"Read buffer set", withOptions(func(f *inputFile) { f.readBuffer = 1024 }), false, []string{"cmd", "--read-buffer=1024", "test.csv"}},
+		{"Read buffer too small", inputFile{}, true, []string{"cmd", "--read-buffer=0", "test.csv"}},
+		{"Field size limit disabled", withOptions(func(f *inputFile) { f.maxFieldBytes = 0 }), false, []string{"cmd", "--max-field-bytes=0", "test.csv"}},
+		{"Negative field size limit", inputFile{}, true, []string{"cmd", "--max-field-bytes=-1", "test.csv"}},
+		{"Range filters", withOptions(func(f *inputFile) {
+			f.rangeFilters = []rangeFilter{{"price", 1, 10.5}, {"qty", math.Inf(-1), -2}, {"a:b", 3, math.Inf(1)}}
+		}), false, []string{"cmd", "--filter-range=price:1..10.5", "--filter-range=qty:..-2", "--filter-range=a:b:3..", "test.csv"}},
+		{"Range filter without column", inputFile{}, true, []string{"cmd", "--filter-range=1..2", "test.csv"}},
+		{"Range filter without range", inputFile{}, true, []string{"cmd", "--filter-range=price:1-2", "test.csv"}},
+		{"Range filter with non-numeric bound", inputFile{}, true, []string{"cmd", "--filter-range=price:a..2", "test.csv"}},
+		{"Range filter with reversed bounds", inputFile{}, true, []string{"cmd", "--filter-range=price:5..2", "test.csv"}},
+		{"Columns", withOptions(func(f *inputFile) { f.columns = []string{"id", "name", "price"} }), false, []string{"cmd", "--columns=id, name,,price", "test.csv"}},
+		{"Columns file", withOptions(func(f *inputFile) { f.columns = []string{"id", "name", "price"} }), false, []string{"cmd", "--columns-file=" + columnsFile.Name(), "test.csv"}},
+		{"Columns file does not exist", inputFile{}, true, []string{"cmd", "--columns-file=nowhere/columns.txt", "test.csv"}},
+		{"Columns and columns file", inputFile{}, true, []string{"cmd", "--columns=id", "--columns-file=" + columnsFile.Name(), "test.csv"}},
+		{"Columns from schema", withOptions(func(f *inputFile) {
+			f.columns, f.columnsFromSchema = []string{"id", "name", "price", "status"}, true
+		}), false, []string{"cmd", "--columns-from-schema=testJsonFiles/schema.json", "test.csv"}},
+		{"Columns from schema and columns", inputFile{}, true, []string{"cmd", "--columns=id", "--columns-from-schema=testJsonFiles/schema.json", "test.csv"}},
+		{"Columns from schema does not exist", inputFile{}, true, []string{"cmd", "--columns-from-schema=nowhere/schema.json", "test.csv"}},
+		{"Checkpoint", withOptions(func(f *inputFile) { f.checkpoint, f.checkpointEvery = "progress.json", 10 }), false, []string{"cmd", "--checkpoint=progress.json", "--checkpoint-every=10", "test.csv"}},
+		{"Checkpoint interval too small", inputFile{}, true, []string{"cmd", "--checkpoint=progress.json", "--checkpoint-every=0", "test.csv"}},
+		{"Resume without a checkpoint file", inputFile{}, true, []string{"cmd", "--resume", "test.csv"}},
+		{"Several files", withOptions(func(f *inputFile) { f.filepath, f.filepaths = "a.csv", []string{"a.csv", "b.csv"} }), false, []string{"cmd", "a.csv", "b.csv"}},
+		{"Flags without file", inputFile{}, true, []string{"cmd", "--pretty"}},
+		{"Checkpoint with several files", inputFile{}, true, []string{"cmd", "--checkpoint=progress.json", "a.csv", "b.csv"}},
+		{"Only newer files", withOptions(func(f *inputFile) { f.ifNewer = true }), false, []string{"cmd", "--if-newer", "test.csv"}},
+		{"Forced conversion", withOptions(func(f *inputFile) { f.ifNewer, f.force = true, true }), false, []string{"cmd", "--if-newer", "--force", "test.csv"}},
+		{"Atomic output", withOptions(func(f *inputFile) { f.atomic = true }), false, []string{"cmd", "--atomic", "test.csv"}},
+		{"Atomic output with checkpoint", inputFile{}, true, []string{"cmd", "--atomic", "--checkpoint=progress.json", "test.csv"}},
+		{"Root key", withOptions(func(f *inputFile) { f.rootKey = "records" }), false, []string{"cmd", "--root-key=records", "test.csv"}},
+		{"Metadata extraction", withOptions(func(f *inputFile) { f.rootKey, f.extractMeta = "records", true }), false, []string{"cmd", "--root-key=records", "--extract-meta", "test.csv"}},
+		{"Metadata extraction without root key", inputFile{}, true, []string{"cmd", "--extract-meta", "test.csv"}},
+		{"Metadata extraction under the meta key", inputFile{}, true, []string{"cmd", "--root-key=meta", "--extract-meta", "test.csv"}},
+		{"SHA-256 checksum", withOptions(func(f *inputFile) { f.checksum = "sha256" }), false, []string{"cmd", "--checksum=sha256", "test.csv"}},
+		{"Checksum algorithm not identified", inputFile{}, true, []string{"cmd", "--checksum=md5", "test.csv"}},
+		{"Indentation", withOptions(func(f *inputFile) { f.indent = "  " }), false, []string{"cmd", "--indent=  ", "test.csv"}},
+		{"Indentation with other characters", inputFile{}, true, []string{"cmd", "--indent=--", "test.csv"}},
+		{"Indentation as a number of spaces", withOptions(func(f *inputFile) { f.indent = "  " }), false, []string{"cmd", "--indent=2", "test.csv"}},
+		{"Negative indentation", inputFile{}, true, []string{"cmd", "--indent=-2", "test.csv"}},
+		{"Indentation with a tab", withOptions(func(f *inputFile) { f.indent = "\t" }), false, []string{"cmd", "--indent=tab", "test.csv"}},
+		{"No indentation", withOptions(func(f *inputFile) { f.indent, f.recordPerLine = "", true }), false, []string{"cmd", "--pretty", "--indent=0", "test.csv"}},
+		{"Tabs", withOptions(func(f *inputFile) { f.indent = "\t" }), false, []string{"cmd", "--tabs", "test.csv"}},
+		{"Tabs and indentation", inputFile{}, true, []string{"cmd", "--tabs", "--indent=  ", "test.csv"}},
+		{"Preview", withOptions(func(f *inputFile) { f.preview, f.pretty = 5, true }), false, []string{"cmd", "--preview=5", "test.csv"}},
+		{"Negative preview", inputFile{}, true, []string{"cmd", "--preview=-1", "test.csv"}},
+		{"Preview with checkpoint", inputFile{}, true, []string{"cmd", "--preview=5", "--checkpoint=progress.json", "test.csv"}},
+		{"Confirmation size", withOptions(func(f *inputFile) { f.confirmAbove = 0 }), false, []string{"cmd", "--confirm-above=0", "test.csv"}},
+		{"Negative confirmation size", inputFile{}, true, []string{"cmd", "--confirm-above=-1", "test.csv"}},
+		{"Without confirmation", withOptions(func(f *inputFile) { f.yes = true }), false, []string{"cmd", "--yes", "test.csv"}},
+		{"Redactions", withOptions(func(f *inputFile) {
+			f.redactions, f.redactMask = []redaction{{"ssn", 0}, {"card", 4}, {"a:b", 0}}, "XXX"
+		}), false, []string{"cmd", "--redact=ssn", "--redact=card:4", "--redact=a:b", "--redact-mask=XXX", "test.csv"}},
+		{"Redaction keeping negative characters", inputFile{}, true, []string{"cmd", "--redact=ssn:-1", "test.csv"}},
+		{"Redaction without column", inputFile{}, true, []string{"cmd", "--redact=", "test.csv"}},
+		{"Hashed columns", withOptions(func(f *inputFile) { f.hashColumns, f.hashSalt = []string{"email", "id"}, "pepper" }), false, []string{"cmd", "--hash=email", "--hash=id", "--hash-salt=pepper", "test.csv"}},
+		{"Profile", withOptions(func(f *inputFile) { f.profile, f.profileCap = true, 100 }), false, []string{"cmd", "--profile", "--profile-distinct-cap=100", "test.csv"}},
+		{"Profile distinct cap too small", inputFile{}, true, []string{"cmd", "--profile", "--profile-distinct-cap=0", "test.csv"}},
+		{"Profile with preview", inputFile{}, true, []string{"cmd", "--profile", "--preview=5", "test.csv"}},
+		{"Unique checks", withOptions(func(f *inputFile) { f.uniqueColumns, f.uniqueStrict = []string{"id", "email"}, true }), false, []string{"cmd", "--unique-check=id", "--unique-check=email", "--unique-strict", "test.csv"}},
+		{"Unique check of a header with a comma", withOptions(func(f *inputFile) { f.uniqueColumns = []string{"region, order_id"} }), false, []string{"cmd", "--unique-check=region, order_id", "test.csv"}},
+		{"Composite unique key", withOptions(func(f *inputFile) { f.uniqueKey, f.uniqueSkipEmpty = []string{"region", "order_id"}, true }), false, []string{"cmd", "--unique-key=region", "--unique-key=order_id", "--unique-skip-empty", "test.csv"}},
+		{"Unique check without column", inputFile{}, true, []string{"cmd", "--unique-check=", "test.csv"}},
+		{"Strict unique check without column", inputFile{}, true, []string{"cmd", "--unique-strict", "test.csv"}},
+		{"Skipping empty values without column", inputFile{}, true, []string{"cmd", "--unique-skip-empty", "test.csv"}},
+		{"Template", withOptions(func(f *inputFile) { f.template = true }), false, []string{"cmd", "--template", "test.csv"}},
+		{"Template with root key", inputFile{}, true, []string{"cmd", "--template", "--root-key=records", "test.csv"}},
+		{"Null value", withOptions(func(f *inputFile) { f.nullValue, f.nullValueGiven = "NULL", true }), false, []string{"cmd", "--null-value=NULL", "test.csv"}},
+		{"Empty cells as null", withOptions(func(f *inputFile) { f.nullValueGiven = true }), false, []string{"cmd", "--null-value=", "test.csv"}},
+		{"Tab separator", withOptions(func(f *inputFile) { f.separator, f.separatorGiven = "tab", true }), false, []string{"cmd", "--separator=tab", "test.csv"}},
+		{"Forced extension", withOptions(func(f *inputFile) { f.forceExtension = true }), false, []string{"cmd", "--force-extension", "test.csv"}},
+		{"Scalar values", withOptions(func(f *inputFile) { f.scalar = true }), false, []string{"cmd", "--scalar", "test.csv"}},
+		{"Scalar template", inputFile{}, true, []string{"cmd", "--scalar", "--template", "test.csv"}},
+		{"Empty files allowed", withOptions(func(f *inputFile) { f.allowEmpty = true }), false, []string{"cmd", "--allow-empty", "test.csv"}},
+		{"Typed values", withOptions(func(f *inputFile) { f.typed = true }), false, []string{"cmd", "--typed", "test.csv"}},
+		{"Type map", withOptions(func(f *inputFile) { f.typeMap = map[string]valueType{"id": typeNumber, "a:b": typeBoolean} }), false, []string{"cmd", "--type-map=id:number, a:b:Boolean", "test.csv"}},
+		{"Unknown type", inputFile{}, true, []string{"cmd", "--type-map=id:integer", "test.csv"}},
+		{"Type without column", inputFile{}, true, []string{"cmd", "--type-map=number", "test.csv"}},
+		{"Output suffix", withOptions(func(f *inputFile) { f.outputSuffix = ".ndjson" }), false, []string{"cmd", "--output-suffix=.ndjson", "test.csv"}},
+		{"Empty output suffix", inputFile{}, true, []string{"cmd", "--output-suffix=", "test.csv"}},
+		{"Output suffix with a directory", inputFile{}, true, []string{"cmd", "--output-suffix=/out.json", "test.csv"}},
+		{"Record per line", withOptions(func(f *inputFile) { f.recordPerLine = true }), false, []string{"cmd", "--record-per-line", "test.csv"}},
+		{"Record per line in pretty JSON", inputFile{}, true, []string{"cmd", "--record-per-line", "--pretty", "test.csv"}},
+		{"No trailing newline", withOptions(func(f *inputFile) { f.noTrailingNewline = true }), false, []string{"cmd", "--no-trailing-newline", "test.csv"}},
+		{"HTML not escaped", withOptions(func(f *inputFile) { f.escaping.noEscapeHTML = true }), false, []string{"cmd", "--no-escape-html", "test.csv"}},
+		{"ASCII output", withOptions(func(f *inputFile) { f.escaping.ascii = true }), false, []string{"cmd", "--ascii", "test.csv"}},
+		{"Padded ragged lines", withOptions(func(f *inputFile) { f.ragged = "pad" }), false, []string{"cmd", "--ragged=pad", "test.csv"}},
+		{"Unknown ragged mode", inputFile{}, true, []string{"cmd", "--ragged=truncate", "test.csv"}},
+		{"Flush interval", withOptions(func(f *inputFile) { f.flushEvery = 100 }), false, []string{"cmd", "--flush-every=100", "test.csv"}},
+		{"Negative flush interval", inputFile{}, true, []string{"cmd", "--flush-every=-1", "test.csv"}},
+		{"Headers", withOptions(func(f *inputFile) { f.givenHeaders = []string{"id", "name"} }), false, []string{"cmd", "--headers=id, name", "test.csv"}},
+		{"Fixed widths", withOptions(func(f *inputFile) { f.fixedWidths, f.widthUnit = []int{10, 8}, "byte" }), false, []string{"cmd", "--fixed-widths=10,8", "--fixed-width-unit=byte", "test.csv"}},
+		{"Fixed widths with headers", withOptions(func(f *inputFile) { f.fixedWidths, f.givenHeaders = []int{10, 8}, []string{"id", "name"} }), false, []string{"cmd", "--fixed-widths=10,8", "--headers=id,name", "test.csv"}},
+		{"Fixed widths spec", withOptions(func(f *inputFile) { f.fixedWidths, f.givenHeaders = []int{6, 20}, []string{"id", "full name"} }), false, []string{"cmd", "--fixed-widths-file=" + specFile.Name(), "test.csv"}},
+		{"Fewer headers than fixed widths", inputFile{}, true, []string{"cmd", "--fixed-widths=10,8", "--headers=id", "test.csv"}},
+		{"Fixed width of zero", inputFile{}, true, []string{"cmd", "--fixed-widths=10,0", "test.csv"}},
+		{"Fixed widths with a separator", inputFile{}, true, []string{"cmd", "--fixed-widths=10,8", "--separator=tab", "test.csv"}},
+		{"Fixed width unit without widths", inputFile{}, true, []string{"cmd", "--fixed-width-unit=byte", "test.csv"}},
+		{"Fail on empty output", withOptions(func(f *inputFile) { f.failOnEmpty = true }), false, []string{"cmd", "--fail-on-empty-output", "test.csv"}},
+		{"CSV reader options", withOptions(func(f *inputFile) {
+			f.fieldsPerRecord, f.lazyQuotes, f.trimLeadingSpace, f.comment, f.reuseRecord = 3, true, true, '#', false
+		}), false, []string{"cmd", "--csv-fields-per-record=3", "--csv-lazy-quotes", "--csv-trim-leading-space", "--csv-comment=#", "--csv-reuse-record=false", "test.csv"}},
+		{"Trimmed space separator", inputFile{}, true, []string{"cmd", "--separator= ", "--csv-trim-leading-space", "test.csv"}},
+		{"Trimmed tab separator", inputFile{}, true, []string{"cmd", "--separator=tab", "--csv-trim-leading-space", "test.csv"}},
+		{"Comment is the separator", inputFile{}, true, []string{"cmd", "--separator=;", "--csv-comment=;", "test.csv"}},
+		{"Comment of two characters", inputFile{}, true, []string{"cmd", "--csv-comment=//", "test.csv"}},
+		{"Comment is a quote", inputFile{}, true, []string{"cmd", `--csv-comment="`, "test.csv"}},
+		{"Lazy quotes with a long separator", inputFile{}, true, []string{"cmd", "--separator=||", "--csv-lazy-quotes", "test.csv"}},
+		{"Comment with fixed widths", inputFile{}, true, []string{"cmd", "--fixed-widths=2,3", "--csv-comment=#", "test.csv"}},
+		{"Negative fields per record", inputFile{}, true, []string{"cmd", "--csv-fields-per-record=-1", "test.csv"}},
+		{"Fields per record with ragged", inputFile{}, true, []string{"cmd", "--csv-fields-per-record=2", "--ragged=pad", "test.csv"}},
+		{"Fields per record for other headers", inputFile{}, true, []string{"cmd", "--csv-fields-per-record=2", "--headers=a,b,c", "test.csv"}},
+		{"Source field", withOptions(func(f *inputFile) { f.sourceField = "file" }), false, []string{"cmd", "--add-source-field=file", "test.csv"}},
+		{"Maximum columns", withOptions(func(f *inputFile) { f.maxColumns = 500 }), false, []string{"cmd", "--max-columns=500", "test.csv"}},
+		{"Negative maximum columns", inputFile{}, true, []string{"cmd", "--max-columns=-1", "test.csv"}},
+		{"Dedupe keeping the last", withOptions(func(f *inputFile) { f.dedupeKey, f.dedupeKeep = "id", "last" }), false, []string{"cmd", "--dedupe-key=id", "--keep=last", "test.csv"}},
+		{"Keep without a dedupe key", inputFile{}, true, []string{"cmd", "--keep=last", "test.csv"}},
+		{"Keep a middle duplicate", inputFile{}, true, []string{"cmd", "--dedupe-key=id", "--keep=middle", "test.csv"}},
+		{"Dedupe with a checkpoint", inputFile{}, true, []string{"cmd", "--dedupe-key=id", "--checkpoint=progress.json", "test.csv"}},
+		{"Keep the last with a limit", inputFile{}, true, []string{"cmd", "--dedupe-key=id", "--keep=last", "--limit=10", "test.csv"}},
+		{"Split records with a manifest", withOptions(func(f *inputFile) { f.splitRecords, f.manifest = 1000, "shards.json" }), false, []string{"cmd", "--split-records=1000", "--manifest=shards.json", "test.csv"}},
+		{"Negative split records", inputFile{}, true, []string{"cmd", "--split-records=-1", "test.csv"}},
+		{"Split records with a checkpoint", inputFile{}, true, []string{"cmd", "--split-records=10", "--checkpoint=progress.json", "test.csv"}},
+		{"Manifest without split records", inputFile{}, true, []string{"cmd", "--manifest=shards.json", "test.csv"}},
+		{"Manifest of several files", inputFile{}, true, []string{"cmd", "--split-records=10", "--manifest=shards.json", "a.csv", "b.csv"}},
+		{"Aligned pretty output", withOptions(func(f *inputFile) { f.pretty, f.align = true, true }), false, []string{"cmd", "--pretty", "--align", "test.csv"}},
+		{"Align without pretty", inputFile{}, true, []string{"cmd", "--align", "test.csv"}},
+		{"CRLF line endings", withOptions(func(f *inputFile) { f.lineEnding = "\r\n" }), false, []string{"cmd", "--line-ending=crlf", "test.csv"}},
+		{"Unknown line ending", inputFile{}, true, []string{"cmd", "--line-ending=cr", "test.csv"}},
+		{"Timing", withOptions(func(f *inputFile) { f.timing = true }), false, []string{"cmd", "--timing", "test.csv"}},
+		{"Timing of a reverse conversion", inputFile{}, true, []string{"cmd", "--timing", "--reverse", "test.json"}},
+		{"Output directory created", withOptions(func(f *inputFile) { f.outputDir, f.createDirs = "reports/2024", true }), false, []string{"cmd", "--output-dir=reports/2024", "--create-dirs", "test.csv"}},
+		{"Output mode", withOptions(func(f *inputFile) { f.outputMode = fileMode{perm: 0640, given: true} }), false, []string{"cmd", "--output-mode=0640", "test.csv"}},
+		{"Output mode not in octal", inputFile{}, true, []string{"cmd", "--output-mode=0649", "test.csv"}},
+		{"Output mode too big", inputFile{}, true, []string{"cmd", "--output-mode=1777", "test.csv"}},
+		{"Boolean tokens", withOptions(func(f *inputFile) {
+			f.typed, f.booleanTokens = true, map[string]bool{"yes": true, "y": true, "no": false}
+		}), false, []string{"cmd", "--typed", "--bool-true=Yes,y", "--bool-false=NO", "test.csv"}},
+		{"Boolean tokens without typed", inputFile{}, true, []string{"cmd", "--bool-true=yes", "test.csv"}},
+		{"Token both true and false", inputFile{}, true, []string{"cmd", "--typed", "--bool-true=y", "--bool-false=Y", "test.csv"}},
+		{"Big number columns", withOptions(func(f *inputFile) {
+			f.typed, f.typeMap = true, map[string]valueType{"id": typeString, "total": typeString}
+		}), false, []string{"cmd", "--typed", "--big-number-columns=id,total", "test.csv"}},
+		{"Big number typed as a number", inputFile{}, true, []string{"cmd", "--type-map=id:number", "--big-number-columns=id", "test.csv"}},
+		{"Append", withOptions(func(f *inputFile) { f.appendRecords, f.atomic = true, true }), false, []string{"cmd", "--append", "--atomic", "test.csv"}},
+		{"Append under a root key", inputFile{}, true, []string{"cmd", "--append", "--root-key=records", "test.csv"}},
+		{"Trailing separator tolerated", withOptions(func(f *inputFile) { f.trailingSeparator = true }), false, []string{"cmd", "--tolerate-trailing-separator", "test.csv"}},
+		{"Sniffing disabled", withOptions(func(f *inputFile) { f.noSniff = true }), false, []string{"cmd", "--no-sniff", "test.csv"}},
+		{"Colors disabled", withOptions(func(f *inputFile) { f.noColor = true }), false, []string{"cmd", "--no-color", "test.csv"}},
+		{"Keys normalized", withOptions(func(f *inputFile) { f.normalizeKeys = true }), false, []string{"cmd", "--normalize-keys", "test.csv"}},
+		{"Fuzzy columns", withOptions(func(f *inputFile) { f.fuzzyColumns = true }), false, []string{"cmd", "--fuzzy-columns", "test.csv"}},
+		{"Rename regexes", withOptions(func(f *inputFile) {
+			f.headerRenames = []headerRename{{regexp.MustCompile("^col_"), ""}, {regexp.MustCompile("(\\w+)_id$"), "${1}Id=x"}}
+		}), false, []string{"cmd", "--rename-regex=^col_=", "--rename-regex=(\\w+)_id$=${1}Id=x", "test.csv"}},
+		{"Rename regex without pattern", inputFile{}, true, []string{"cmd", "--rename-regex==x", "test.csv"}},
+		{"Rename regex with an invalid pattern", inputFile{}, true, []string{"cmd", "--rename-regex=(col=x", "test.csv"}},
+		{"Extract column", withOptions(func(f *inputFile) { f.extractColumn, f.columns, f.outputSuffix = "email", []string{"email"}, ".txt" }), false, []string{"cmd", "--extract-column=email", "test.csv"}},
+		{"Extract column with a suffix", withOptions(func(f *inputFile) { f.extractColumn, f.columns, f.outputSuffix = "email", []string{"email"}, ".lst" }), false, []string{"cmd", "--extract-column=email", "--output-suffix=.lst", "test.csv"}},
+		{"Extract column with columns", inputFile{}, true, []string{"cmd", "--extract-column=email", "--columns=id", "test.csv"}},
+		{"Extract column with typed", inputFile{}, true, []string{"cmd", "--extract-column=email", "--typed", "test.csv"}},
+		{"Concatenations", withOptions(func(f *inputFile) {
+			f.concatFields, f.concatSep = []concatField{{"full_name", []string{"first", "last"}}, {"place", []string{"city", "zip"}}}, ", "
+		}), false, []string{"cmd", "--concat=full_name=first+last", "--concat= place = city + zip", "--concat-sep=, ", "test.csv"}},
+		{"Concatenation without key", inputFile{}, true, []string{"cmd", "--concat==first+last", "test.csv"}},
+		{"Concatenation without columns", inputFile{}, true, []string{"cmd", "--concat=full_name=+", "test.csv"}},
+		{"Concatenations with the same key", inputFile{}, true, []string{"cmd", "--concat=name=first+last", "--concat=name=last+first", "test.csv"}},
+		{"Concatenation as the source field", inputFile{}, true, []string{"cmd", "--concat=file=first+last", "--add-source-field=file", "test.csv"}},
+		{"Exclude", withOptions(func(f *inputFile) { f.excluded = []string{"first", "last"} }), false, []string{"cmd", "--exclude=first, last", "test.csv"}},
+		{"Emit schema", withOptions(func(f *inputFile) { f.emitSchema = "schema.json" }), false, []string{"cmd", "--emit-schema=schema.json", "test.csv"}},
+		{"Emit schema of several files", inputFile{}, true, []string{"cmd", "--emit-schema=schema.json", "a.csv", "b.csv"}},
+		{"Emit schema with preview", inputFile{}, true, []string{"cmd", "--emit-schema=schema.json", "--preview=2", "test.csv"}},
+		{"Emit schema of an extracted column", inputFile{}, true, []string{"cmd", "--emit-schema=schema.json", "--extract-column=id", "test.csv"}},
+		{"Geo points", withOptions(func(f *inputFile) {
+			f.geoPoints, f.geoInvalid = []geoPoint{{"lat", "lon", "location"}, {"to_lat", "to_lon", "to"}}, "skip"
+			f.typeMap = map[string]valueType{"location": typePoint, "to": typePoint}
+		}), false, []string{"cmd", "--geo=lat, lon>location", "--geo=to_lat,to_lon > to", "--geo-invalid=skip", "test.csv"}},
+		{"Geo point without key", inputFile{}, true, []string{"cmd", "--geo=lat,lon>", "test.csv"}},
+		{"Geo point with one column", inputFile{}, true, []string{"cmd", "--geo=lat>location", "test.csv"}},
+		{"Geo point with another type", inputFile{}, true, []string{"cmd", "--geo=lat,lon>location", "--type-map=location:string", "test.csv"}},
+		{"Unknown geo-invalid policy", inputFile{}, true, []string{"cmd", "--geo=lat,lon>location", "--geo-invalid=zero", "test.csv"}},
+		{"Human numbers", withOptions(func(f *inputFile) { f.typeMap, f.percentMode = map[string]valueType{"ctr": typeHumanNumber}, "raw" }), false, []string{"cmd", "--type-map=ctr:humannumber", "--percent-mode=raw", "test.csv"}},
+		{"Unknown percent mode", inputFile{}, true, []string{"cmd", "--type-map=ctr:humannumber", "--percent-mode=ratio", "test.csv"}},
+		{"Decimal comma", withOptions(func(f *inputFile) { f.typed, f.decimalComma = true, true }), false, []string{"cmd", "--typed", "--decimal-comma", "test.csv"}},
+		{"Decimal comma without types", inputFile{}, true, []string{"cmd", "--decimal-comma", "test.csv"}},
+		{"Retries", withOptions(func(f *inputFile) { f.retries, f.retryDelay = 5, 250*time.Millisecond }), false, []string{"cmd", "--retries=5", "--retry-delay=250ms", "test.csv"}},
+		{"Negative retries", inputFile{}, true, []string{"cmd", "--retries=-1", "test.csv"}},
+		{"Decode", withOptions(func(f *inputFile) {
+			f.decodings, f.decodeInvalid, f.decodeBinary = []columnDecoding{{"payload", "base64"}, {"digest", "hex"}}, "null", "replace"
+		}), false, []string{"cmd", "--decode=payload:base64", "--decode", "digest:HEX", "--decode-invalid=null", "--decode-binary=replace", "test.csv"}},
+		{"Decode unknown encoding", inputFile{}, true, []string{"cmd", "--decode=payload:base32", "test.csv"}},
+		{"Decode without encoding", inputFile{}, true, []string{"cmd", "--decode=payload", "test.csv"}},
+		{"Unknown decode-invalid policy", inputFile{}, true, []string{"cmd", "--decode-invalid=skip", "test.csv"}},
+		{"Parse JSON", withOptions(func(f *inputFile) {
+			f.parseJSON, f.typeMap, f.parseJSONInvalid, f.parseJSONDepth, f.parseJSONBytes = []string{"metadata", "attributes"}, map[string]valueType{"metadata": typeJSON, "attributes": typeJSON}, "keep", 4, 0
+		}), false, []string{"cmd", "--parse-json=metadata, attributes", "--parse-json-invalid=keep", "--parse-json-max-depth=4", "--parse-json-max-bytes=0", "test.csv"}},
+		{"Parse JSON with another type", inputFile{}, true, []string{"cmd", "--parse-json=metadata", "--type-map=metadata:number", "test.csv"}},
+		{"Parse JSON with stringify", inputFile{}, true, []string{"cmd", "--parse-json=metadata", "--stringify", "test.csv"}},
+		{"Unknown parse-json-invalid policy", inputFile{}, true, []string{"cmd", "--parse-json-invalid=skip", "test.csv"}},
+		{"Currency symbols", withOptions(func(f *inputFile) {
+			f.currencySymbols, f.currencyAsNumber = map[string]string{"$": "CAD", "€": "EUR", "£": "GBP", "¥": "JPY", "₹": "INR", "kr": "SEK"}, true
+		}), false, []string{"cmd", "--currency-symbols=$:CAD, kr:SEK", "--currency-as-number", "test.csv"}},
+		{"Currency symbol with a lowercase code", inputFile{}, true, []string{"cmd", "--currency-symbols=kr:sek", "test.csv"}},
+		{"Currency symbol without code", inputFile{}, true, []string{"cmd", "--currency-symbols=kr", "test.csv"}},
+		{"Report", withOptions(func(f *inputFile) { f.report = "report.json" }), false, []string{"cmd", "--report=report.json", "test.csv"}},
+		{"Float format", withOptions(func(f *inputFile) { f.typed, f.floatFormat = true, floatFormat{'f', 2} }), false, []string{"cmd", "--typed", "--float-format=%.2f", "test.csv"}},
+		{"Float format without typed", inputFile{}, true, []string{"cmd", "--float-format=%.2f", "test.csv"}},
+		{"Report with reverse", inputFile{}, true, []string{"cmd", "--report=report.json", "--reverse", "test.json"}},
+		{"Options after the file", withOptions(func(f *inputFile) { f.pretty = true }), false, []string{"cmd", "test.csv", "--pretty"}},
+		{"Short options", withOptions(func(f *inputFile) { f.pretty, f.separator, f.separatorGiven = true, "semicolon", true }), false, []string{"cmd", "-p", "test.csv", "-s", "semicolon"}},
+		{"Diff against a previous file", withOptions(func(f *inputFile) { f.diffAgainst, f.keyColumn = "previous.json", "id" }), false, []string{"cmd", "--diff-against=previous.json", "--key-column=id", "test.csv"}},
+		{"Diff without a key column", inputFile{}, true, []string{"cmd", "--diff-against=previous.json", "test.csv"}},
+		{"Diff with a root key", inputFile{}, true, []string{"cmd", "--diff-against=previous.json", "--key-column=id", "--root-key=data", "test.csv"}},
+		{"Separator fallbacks", withOptions(func(f *inputFile) { f.separatorFallback = []string{"semicolon", "tab", "||"} }), false, []string{"cmd", "--separator-fallback", "semicolon, tab,||", "test.csv"}},
+		{"Separator fallbacks with headers", inputFile{}, true, []string{"cmd", "--separator-fallback=semicolon", "--headers=id,name", "test.csv"}},
+		{"Separator fallback that is the comment", inputFile{}, true, []string{"cmd", "--separator-fallback=semicolon,#", "--csv-comment=#", "test.csv"}},
+		{"Preview confirmed", withOptions(func(f *inputFile) { f.previewFirst = 5 }), false, []string{"cmd", "--preview=5", "--preview-confirm", "test.csv"}},
+		{"Preview confirmed without preview", inputFile{}, true, []string{"cmd", "--preview-confirm", "test.csv"}},
+		{"Preview confirmed with reverse", inputFile{}, true, []string{"cmd", "--preview=5", "--preview-confirm", "--reverse", "test.json"}},
+		{"Trailing delimiter allowed", withOptions(func(f *inputFile) { f.trailingSeparator = true }), false, []string{"cmd", "--allow-trailing-delimiter", "test.csv"}},
+		{"Blank rows kept", withOptions(func(f *inputFile) { f.skipBlankRows = false }), false, []string{"cmd", "--skip-blank-rows=false", "test.csv"}},
+		{"All empty rows skipped", withOptions(func(f *inputFile) { f.skipAllEmptyRows = true }), false, []string{"cmd", "--skip-all-empty-rows", "test.csv"}},
+		{"Reverse", withOptions(func(f *inputFile) { f.reverse, f.outputSuffix, f.quoteMode = true, ".csv", "nonnumeric" }), false, []string{"cmd", "--reverse", "--quote-mode=nonnumeric", "test.csv"}},
+		{"Reverse with an output suffix", withOptions(func(f *inputFile) { f.reverse, f.outputSuffix = true, ".tsv" }), false, []string{"cmd", "--reverse", "--output-suffix=.tsv", "test.csv"}},
+		{"Quote mode without reverse", inputFile{}, true, []string{"cmd", "--quote-mode=all", "test.csv"}},
+		{"Unknown quote mode", inputFile{}, true, []string{"cmd", "--reverse", "--quote-mode=some", "test.csv"}},
+		{"Reverse with checkpoint", inputFile{}, true, []string{"cmd", "--reverse", "--checkpoint=progress.json", "test.csv"}},
+		{"Error file", withOptions(func(f *inputFile) { f.ragged, f.errorFile = "skip", "skipped.csv" }), false, []string{"cmd", "--ragged=skip", "--error-file=skipped.csv", "test.csv"}},
+		{"Error file without skipping lines", inputFile{}, true, []string{"cmd", "--error-file=skipped.csv", "test.csv"}},
+		{"Defaults", withOptions(func(f *inputFile) { f.defaults = []columnDefault{{"name", "unknown"}, {"time", "00:00"}} }), false, []string{"cmd", "--default=name:unknown", "--default=time:00:00", "test.csv"}},
+		{"Default without column", inputFile{}, true, []string{"cmd", "--default=:unknown", "test.csv"}},
+		{"Sort by", withOptions(func(f *inputFile) { f.sortBy = sortOrder{"price", true} }), false, []string{"cmd", "--sort-by=price:desc", "test.csv"}},
+		{"Sort by with preview", inputFile{}, true, []string{"cmd", "--sort-by=price", "--preview=5", "test.csv"}},
+		{"Columns key order", withOptions(func(f *inputFile) { f.keyOrder = "csv" }), false, []string{"cmd", "--key-order=csv", "test.csv"}},
+		{"Custom key order", withOptions(func(f *inputFile) { f.keyOrder, f.keyOrderList = "custom", []string{"id", "name"} }), false, []string{"cmd", "--key-order=custom", "--key-order-list=id, name", "test.csv"}},
+		{"Custom key order without list", inputFile{}, true, []string{"cmd", "--key-order=custom", "test.csv"}},
+		{"Key order list without custom order", inputFile{}, true, []string{"cmd", "--key-order-list=id", "test.csv"}},
+		{"Unknown key order", inputFile{}, true, []string{"cmd", "--key-order=random", "test.csv"}},
+		{"Every third line", withOptions(func(f *inputFile) { f.sampleEvery = 3 }), false, []string{"cmd", "--sample-every=3", "test.csv"}},
+		{"Sample interval too small", inputFile{}, true, []string{"cmd", "--sample-every=0", "test.csv"}},
+		{"Limit", withOptions(func(f *inputFile) { f.limit = 10 }), false, []string{"cmd", "--limit=10", "test.csv"}},
+		{"Limit with checkpoint", inputFile{}, true, []string{"cmd", "--limit=10", "--checkpoint=progress.json", "test.csv"}},
+		{"Sample rate", withOptions(func(f *inputFile) { f.sampleRate, f.seed = 0.5, 42 }), false, []string{"cmd", "--sample-rate=0.5", "--seed=42", "test.csv"}},
+		{"Sample rate too big", inputFile{}, true, []string{"cmd", "--sample-rate=1.5", "test.csv"}},
+		{"Sample rate of 0", inputFile{}, true, []string{"cmd", "--sample-rate=0", "test.csv"}},
+		{"Key prefix and wrap key", withOptions(func(f *inputFile) { f.keyPrefix, f.wrapKey = "csv_", "row" }), false, []string{"cmd", "--key-prefix=csv_", "--wrap-key=row", "test.csv"}},
+		{"Escaped newlines", withOptions(func(f *inputFile) { f.newlineHandling = "escape" }), false, []string{"cmd", "--newline-handling=escape", "test.csv"}},
+		{"Unknown newline handling", inputFile{}, true, []string{"cmd", "--newline-handling=remove", "test.csv"}},
+		{"Newlines in fields replaced", withOptions(func(f *inputFile) { f.newlineHandling = "space" }), false, []string{"cmd", "--newline-in-field=replace", "test.csv"}},
+		{"Newlines in fields escaped", withOptions(func(f *inputFile) { f.newlineHandling = "escape" }), false, []string{"cmd", "--newline-in-field=escape", "test.csv"}},
+		{"Newlines in fields kept", defaultFileData, false, []string{"cmd", "--newline-in-field=keep", "test.csv"}},
+		{"Newlines in fields stripped", inputFile{}, true, []string{"cmd", "--newline-in-field=strip", "test.csv"}},
+		{"Both newline options", inputFile{}, true, []string{"cmd", "--newline-in-field=keep", "--newline-handling=keep", "test.csv"}},
+		{"Dry validation", withOptions(func(f *inputFile) { f.schema, f.dryValidate = schema, true }), false, []string{"cmd", "--schema=testJsonFiles/schema.json", "--dry-validate", "test.csv"}},
+		{"Dry validation without schema", inputFile{}, true, []string{"cmd", "--dry-validate", "test.csv"}},
+		{"Missing schema", inputFile{}, true, []string{"cmd", "--schema=nowhere.json", "test.csv"}},
+		{"NFC normalization", withOptions(func(f *inputFile) { f.normalize = "nfc" }), false, []string{"cmd", "--normalize=nfc", "test.csv"}},
+		{"Unknown normalization", inputFile{}, true, []string{"cmd", "--normalize=nfd", "test.csv"}},
+		{"Value cases", withOptions(func(f *inputFile) { f.valueCases = []valueCase{{"email", "lower"}, {"name", "title"}} }), false, []string{"cmd", "--value-case=email:lower, name:title", "test.csv"}},
+		{"Unknown value case", inputFile{}, true, []string{"cmd", "--value-case=email:camel", "test.csv"}},
+		{"Value case without column", inputFile{}, true, []string{"cmd", "--value-case=lower", "test.csv"}},
+		{"Stringified values", withOptions(func(f *inputFile) { f.typed, f.stringify = true, true }), false, []string{"cmd", "--typed", "--stringify", "test.csv"}},
+		{"Resume before any checkpoint was made", withOptions(func(f *inputFile) { f.checkpoint = "nowhere/progress.json" }), false, []string{"cmd", "--checkpoint=nowhere/progress.json", "--resume", "test.csv"}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Saving the original os.Args reference
-			actualOsArgs := os.Args
+			// Saving the original os.Args and Flag command line references
+			actualOsArgs, actualCommandLine := os.Args, flag.CommandLine
 			// This defer function will run after the test is done
 			defer func() {
-				os.Args = actualOsArgs                                           // Restoring the original os.Args reference
-				flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError) // Reseting the Flag command line. So that we can parse flags again
+				os.Args, flag.CommandLine = actualOsArgs, actualCommandLine // Restoring them, since the testing flags are in it
 			}()
 
-			os.Args = tt.osArgs // Setting the specific command args for this test
+			os.Args = tt.osArgs                                              // Setting the specific command args for this test
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError) // A new Flag command line, so that we can parse flags again
 
 			got, err := getFileData()
 			if (err != nil) != tt.wantErr {
@@ -48,30 +428,81 @@ func Test_getFileData(t *testing.T) {
 	}
 }
 
+func Test_fileListFromStdin(t *testing.T) {
+	tests := []struct {
+		name   string
+		stdin  string
+		want   []string
+		osArgs []string
+	}{
+		{"Paths from find", "./a.csv\n./data/b.csv\n", []string{"./a.csv", "./data/b.csv"}, []string{"cmd", "-@"}},
+		{"Blank lines and carriage returns", "a.csv\r\n\nb.csv", []string{"a.csv", "b.csv"}, []string{"cmd", "--pretty", "-@"}},
+		{"After the arguments", "b.csv\n", []string{"a.csv", "b.csv"}, []string{"cmd", "-@", "a.csv"}},
+		{"File named -@", "b.csv\n", []string{"-@"}, []string{"cmd", "--", "-@"}},
+		{"Nothing read", "\n", nil, []string{"cmd", "-@"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdinPath := filepath.Join(t.TempDir(), "stdin")
+			check(ioutil.WriteFile(stdinPath, []byte(tt.stdin), 0666))
+			stdin, err := os.Open(stdinPath)
+			check(err)
+			defer stdin.Close()
+
+			actualOsArgs, actualStdin, actualCommandLine := os.Args, os.Stdin, flag.CommandLine
+			defer func() {
+				os.Args, os.Stdin, flag.CommandLine = actualOsArgs, actualStdin, actualCommandLine
+			}()
+			os.Args, os.Stdin = tt.osArgs, stdin
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+			got, err := getFileData()
+			if (err != nil) != (tt.want == nil) {
+				t.Fatalf("getFileData() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.filepaths, tt.want) {
+				t.Errorf("filepaths = %q, want %q", got.filepaths, tt.want)
+			}
+		})
+	}
+}
+
 func Test_checkIfValidFile(t *testing.T) {
-	// Creating a temporal and empty CSV file
+	// Creating a temporary and empty CSV file
 	tmpfile, err := ioutil.TempFile("", "test*.csv")
 	if err != nil {
 		panic(err) // This should never happen
 	}
-	// Once all the tests are done. We delete the temporal file
+	// Once all the tests are done. We delete the temporary file
 	defer os.Remove(tmpfile.Name())
-	type args struct {
-		filename string
+
+	// Other kinds of files, next to a directory that looks like a CSV file
+	dir := t.TempDir()
+	for _, name := range []string{"test.tsv", "test.txt", "test.dat", "TEST.CSV"} {
+		check(ioutil.WriteFile(filepath.Join(dir, name), nil, 0666))
 	}
+	check(os.Mkdir(filepath.Join(dir, "folder.csv"), 0777))
+
 	tests := []struct {
-		name     string
-		filename string
-		want     bool
-		wantErr  bool
+		name           string
+		filename       string
+		forceExtension bool
+		want           bool
+		wantErr        bool
 	}{
-		{"File does exist", tmpfile.Name(), true, false},
-		{"File does not exist", "nowhere/test.csv", false, true},
-		{"File is not csv", "test.txt", false, true},
+		{"File does exist", tmpfile.Name(), false, true, false},
+		{"File does not exist", "nowhere/test.csv", false, false, true},
+		{"TSV file", filepath.Join(dir, "test.tsv"), false, true, false},
+		{"Text file", filepath.Join(dir, "test.txt"), false, true, false},
+		{"File is not csv", filepath.Join(dir, "test.dat"), false, false, true},
+		{"Forced extension", filepath.Join(dir, "test.dat"), true, true, false},
+		{"Directory", filepath.Join(dir, "folder.csv"), false, false, true},
+		{"Character device", os.DevNull, true, false, true},
+		{"Extension in capitals", filepath.Join(dir, "TEST.CSV"), false, true, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := checkIfValidFile(tt.filename)
+			got, err := checkIfValidFile(tt.filename, tt.forceExtension, false)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("checkIfValidFile() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -81,22 +512,83 @@ func Test_checkIfValidFile(t *testing.T) {
 			}
 		})
 	}
+
+	// A text file is only read as CSV, with a warning, when it's not a fixed-width file
+	var out bytes.Buffer
+	actualOut := logger.out
+	logger.out = &out
+	defer func() { logger.out = actualOut }()
+	for _, fixedWidth := range []bool{false, true} {
+		out.Reset()
+		if got, err := checkIfValidFile(filepath.Join(dir, "test.txt"), false, fixedWidth); !got || err != nil {
+			t.Errorf("checkIfValidFile() with fixed widths %v = %v, %v, want true", fixedWidth, got, err)
+		}
+		if warned := strings.Contains(out.String(), "Reading it as CSV"); warned == fixedWidth {
+			t.Errorf("checkIfValidFile() with fixed widths %v logged %q", fixedWidth, out.String())
+		}
+	}
+}
+
+func Test_isUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	jsonPath := filepath.Join(dir, "data.json")
+	check(ioutil.WriteFile(csvPath, []byte("COL1\n1\n"), 0666))
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		json     string    // The content of the JSON file, when there is one
+		jsonTime time.Time // The modification time of the JSON file
+		want     bool
+	}{
+		{"No JSON file", "", time.Time{}, false},
+		{"JSON file is newer", `[{"COL1":"1"}]`, now.Add(time.Hour), true},
+		{"JSON file is older", `[{"COL1":"1"}]`, now.Add(-time.Hour), false},
+		{"JSON file is newer but empty", "", now.Add(time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(jsonPath)
+			check(os.Chtimes(csvPath, now, now))
+			if !tt.jsonTime.IsZero() {
+				check(ioutil.WriteFile(jsonPath, []byte(tt.json), 0666))
+				check(os.Chtimes(jsonPath, tt.jsonTime, tt.jsonTime))
+			}
+
+			got, err := isUpToDate(csvPath, getJSONPath(csvPath, ".json"))
+			if err != nil {
+				t.Errorf("isUpToDate() error = %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("isUpToDate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
 }
 
 func Test_processCsvFile(t *testing.T) {
-	// Defining the maps we're expenting to get from our function
-	wantMapSlice := []map[string]string{
-		{"COL1": "1", "COL2": "2", "COL3": "3"},
-		{"COL1": "4", "COL2": "5", "COL3": "6"},
+	// Defining the records we're expenting to get from our function
+	headers := []string{"COL1", "COL2", "COL3"}
+	wantRecords := []record{
+		{headers, []string{"1", "2", "3"}, nil},
+		{headers, []string{"4", "5", "6"}, nil},
 	}
 	// Defining our test cases
 	tests := []struct {
-		name      string // The name of the test
-		csvString string // The content of our tested CSV file
-		separator string // The separator used for each test case
+		name           string // The name of the test
+		csvString      string // The content of our tested CSV file
+		separator      string // The separator used for each test case
+		batchSize      int    // The number of records sent together through the channel
+		separatorGiven bool   // Whether the separator was explicitly given in the command line
 	}{
-		{"Comma separator", "COL1,COL2,COL3\n1,2,3\n4,5,6\n", "comma"},
-		{"Semicolon separator", "COL1;COL2;COL3\n1;2;3\n4;5;6\n", "semicolon"},
+		{"Comma separator", "COL1,COL2,COL3\n1,2,3\n4,5,6\n", "comma", defaultBatchSize, false},
+		{"Semicolon separator", "COL1;COL2;COL3\n1;2;3\n4;5;6\n", "semicolon", defaultBatchSize, true},
+		{"One record per batch", "COL1,COL2,COL3\n1,2,3\n4,5,6\n", "comma", 1, false},
+		{"Separator declared by the file", "sep=;\nCOL1;COL2;COL3\n1;2;3\n4;5;6\n", "comma", defaultBatchSize, false},
+		{"Separator declared with CRLF line breaks", "sep=;\r\nCOL1;COL2;COL3\r\n1;2;3\r\n4;5;6\r\n", "comma", defaultBatchSize, false},
+		{"Given separator wins over the declared one", "sep=|\nCOL1;COL2;COL3\n1;2;3\n4;5;6\n", "semicolon", defaultBatchSize, true},
 	}
 	// Iterating our test cases as usual
 	for _, tt := range tests {
@@ -110,59 +602,523 @@ func Test_processCsvFile(t *testing.T) {
 			tmpfile.Sync()                             // Persisting data on disk
 			// Defining the inputFile struct that we're going to use as one parameter of our function
 			testFileData := inputFile{
-				filepath:  tmpfile.Name(),
-				pretty:    false,
-				separator: tt.separator,
+				filepath:       tmpfile.Name(),
+				pretty:         false,
+				separator:      tt.separator,
+				batchSize:      tt.batchSize,
+				separatorGiven: tt.separatorGiven,
+				readBuffer:     defaultReadBuffer,
+				maxFieldBytes:  defaultMaxFieldBytes,
 			}
 			// Defining the writerChanel
-			writerChannel := make(chan map[string]string)
+			writerChannel := make(chan recordBatch)
 			// Calling the targeted function as a go routine
-			go processCsvFile(testFileData, writerChannel)
-			// Iterating over the slice containing the expected map values
-			for _, wantMap := range wantMapSlice {
-				record := <-writerChannel                // Waiting for the record that we want to compare
-				if !reflect.DeepEqual(record, wantMap) { // Making the corresponding test assertion
-					t.Errorf("processCsvFile() = %v, want %v", record, wantMap)
+			go sendRecords(t, testFileData, writerChannel)
+			// Collecting every record from the batches until the channel gets closed
+			var records []record
+			for batch := range writerChannel {
+				if len(batch.records) > tt.batchSize {
+					t.Errorf("processCsvFile() sent a batch of %d records, want at most %d", len(batch.records), tt.batchSize)
+				}
+				records = append(records, batch.records...)
+			}
+			// Making the corresponding test assertion
+			if !reflect.DeepEqual(records, wantRecords) {
+				t.Errorf("processCsvFile() = %v, want %v", records, wantRecords)
+			}
+		})
+	}
+}
+
+// Runs processCsvFile, failing the test when it fails. The channel is closed then too, so the test doesn't wait for it
+func sendRecords(t *testing.T, fileData inputFile, writerChannel chan recordBatch) {
+	if err := processCsvFile(context.Background(), fileData, writerChannel); err != nil {
+		t.Errorf("processCsvFile() error = %v", err)
+		close(writerChannel)
+	}
+}
+
+// Runs processCsvFile over a temporary file with csvString as content and returns every record it sends
+func readRecords(t *testing.T, csvString string, fileData inputFile) []record {
+	tmpfile, err := ioutil.TempFile("", "test*.csv")
+	check(err)
+	defer os.Remove(tmpfile.Name())
+	tmpfile.WriteString(csvString)
+	tmpfile.Sync()
+
+	fileData.filepath = tmpfile.Name()
+	writerChannel := make(chan recordBatch)
+	go sendRecords(t, fileData, writerChannel)
+
+	var records []record
+	for batch := range writerChannel {
+		records = append(records, batch.records...)
+	}
+
+	return records
+}
+
+func Test_processCsvFileGzip(t *testing.T) {
+	// The file is read as gzip because of its content, even with a .csv name
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	gzipWriter.Write([]byte("id,name\n1,a\n2,b\n"))
+	gzipWriter.Close()
+
+	records := readRecords(t, compressed.String(), defaultFileData)
+
+	headers := []string{"id", "name"}
+	want := []record{{headers, []string{"1", "a"}, nil}, {headers, []string{"2", "b"}, nil}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("processCsvFile() = %v, want %v", records, want)
+	}
+}
+
+func Test_sniffContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantHint string
+	}{
+		{"Text", "id,name\n1,a\n", ""},
+		{"Empty file", "", ""},
+		{"ZIP archive", "PK\x03\x04\x14\x00\x06\x00", "ZIP archive"},
+		{"PDF document", "%PDF-1.7\n", "PDF document"},
+		{"Gzip inside gzip", "\x1f\x8b\x08\x00", "gzip"},
+		{"UTF-16", "i\x00d\x00,\x00n\x00", "NUL bytes"},
+		{"NUL after the first bytes", strings.Repeat("id,name\n", 50) + "\x00", "NUL bytes"},
+		{"NUL after the bytes looked at", strings.Repeat("id,name\n", 100) + "\x00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := sniffContent("data.csv", strings.NewReader(tt.content))
+			if tt.wantHint != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantHint) {
+					t.Errorf("sniffContent() error = %v, want one about %s", err, tt.wantHint)
 				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sniffContent() error = %v", err)
+			}
+
+			// Nothing looked at is lost
+			content, err := ioutil.ReadAll(r)
+			check(err)
+			if string(content) != tt.content {
+				t.Errorf("sniffContent() reads %q, want %q", content, tt.content)
+			}
+		})
+	}
+}
+
+func Test_processCsvFileRangeFilters(t *testing.T) {
+	csvString := "id,price,qty\na,0.5,1\nb,1,2\nc,5,n/a\nd,10,4\ne,10.5,5\nf,abc,6\n"
+	tests := []struct {
+		name    string
+		filters []rangeFilter
+		wantIDs []string
+	}{
+		{"Bounds are inclusive", []rangeFilter{{"price", 1, 10}}, []string{"b", "c", "d"}},
+		{"Open upper bound", []rangeFilter{{"price", 10, math.Inf(1)}}, []string{"d", "e"}},
+		{"Filters are combined", []rangeFilter{{"price", 1, 10}, {"qty", 2, 4}}, []string{"b", "d"}},
+		{"Non-numeric cells are skipped", []rangeFilter{{"qty", math.Inf(-1), math.Inf(1)}}, []string{"a", "b", "d", "e", "f"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileData := withOptions(func(f *inputFile) { f.rangeFilters = tt.filters })
+
+			var ids []string
+			for _, rec := range readRecords(t, csvString, fileData) {
+				ids = append(ids, rec.values[0])
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("processCsvFile() kept %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// Runs getFileData with the given command line arguments
+func Test_processCsvFileRedactions(t *testing.T) {
+	csvString := "name,ssn\nAnn,123-45-6789\nBob,987\nCléo,000-00-0ñ12\n"
+	tests := []struct {
+		name     string
+		keep     int
+		wantSSNs []string
+	}{
+		{"Full mask", 0, []string{"***", "***", "***"}},
+		{"Last characters are kept", 4, []string{"***6789", "***", "***0ñ12"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileData := withOptions(func(f *inputFile) { f.redactions = []redaction{{"ssn", tt.keep}} })
+
+			var names, ssns []string
+			for _, rec := range readRecords(t, csvString, fileData) {
+				names, ssns = append(names, rec.values[0]), append(ssns, rec.values[1])
+			}
+			if !reflect.DeepEqual(ssns, tt.wantSSNs) {
+				t.Errorf("processCsvFile() redacted the SSNs as %v, want %v", ssns, tt.wantSSNs)
+			}
+			if want := []string{"Ann", "Bob", "Cléo"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("processCsvFile() changed the names into %v, want %v", names, want)
+			}
+		})
+	}
+}
+
+func Test_processCsvFileHashes(t *testing.T) {
+	csvString := "email,name\nann@example.com,Ann\nbob@example.com,Bob\nann@example.com,Ann again\n"
+	for _, salt := range []string{"", "pepper"} {
+		t.Run(fmt.Sprintf("salt=%q", salt), func(t *testing.T) {
+			fileData := withOptions(func(f *inputFile) { f.hashColumns, f.hashSalt = []string{"email"}, salt })
+			records := readRecords(t, csvString, fileData)
+
+			sum := sha256.Sum256([]byte(salt + "ann@example.com"))
+			if got, want := records[0].values[0], hex.EncodeToString(sum[:]); got != want {
+				t.Errorf("processCsvFile() hashed ann@example.com into %s, want %s", got, want)
+			}
+			if records[0].values[0] != records[2].values[0] {
+				t.Errorf("processCsvFile() hashed the same email into %s and %s", records[0].values[0], records[2].values[0])
+			}
+			if records[0].values[0] == records[1].values[0] {
+				t.Errorf("processCsvFile() hashed different emails into the same %s", records[0].values[0])
+			}
+			if records[1].values[1] != "Bob" {
+				t.Errorf("processCsvFile() changed the name into %s, want Bob", records[1].values[1])
+			}
+		})
+	}
+}
+
+func parseArgs(args ...string) (inputFile, error) {
+	actualOsArgs, actualCommandLine := os.Args, flag.CommandLine
+	defer func() {
+		os.Args, flag.CommandLine = actualOsArgs, actualCommandLine
+	}()
+
+	os.Args = append([]string{"cmd"}, args...)
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	return getFileData()
+}
+
+func Test_preview(t *testing.T) {
+	// The broken quote would stop the conversion, so the file must not be read past the previewed records
+	csvString := "id,name\n1,a\n2,b\n3,\"c\"d\n"
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte(csvString), 0666))
+
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	check(err)
+	actualStdout := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = actualStdout }()
+
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.preview, f.pretty, f.columns = csvPath, 2, true, []string{"name"} }))
+	stdout.Close()
+
+	got, err := ioutil.ReadFile(stdout.Name())
+	check(err)
+	if want := "[\n   {\n      \"name\": \"a\"\n   },\n   {\n      \"name\": \"b\"\n   }\n]\n"; string(got) != want {
+		t.Errorf("preview = %q, want %q", got, want)
+	}
+	if names := listDir(t, dir); !reflect.DeepEqual(names, []string{"data.csv", "stdout"}) {
+		t.Errorf("directory has %v, want no JSON file", names)
+	}
+}
+
+func Test_processCsvFileColumnsFile(t *testing.T) {
+	columnsFile, err := ioutil.TempFile("", "columns*.txt")
+	check(err)
+	defer os.Remove(columnsFile.Name())
+	columnsFile.WriteString("price\n\nid \n")
+	columnsFile.Close()
+
+	fileData, err := parseArgs("--columns-file", columnsFile.Name(), "test.csv")
+	if err != nil {
+		t.Fatalf("getFileData() error = %v", err)
+	}
+	records := readRecords(t, "id,name,price\n1,one,10\n2,two,20\n", fileData)
+
+	// Only the listed columns should be there, in the order of the file
+	want := []record{
+		{[]string{"id", "price"}, []string{"1", "10"}, nil},
+		{[]string{"id", "price"}, []string{"2", "20"}, nil},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("processCsvFile() = %v, want %v", records, want)
+	}
+}
+
+func Test_selectColumns(t *testing.T) {
+	headers := []string{"id", "name", "price"}
+	tests := []struct {
+		name        string
+		included    []string
+		wantKeys    []string
+		wantColumns []int
+		wantErr     bool
+	}{
+		{"Every column by default", nil, headers, []int{0, 1, 2}, false},
+		{"Columns keep the file order", []string{"price", "id"}, []string{"id", "price"}, []int{0, 2}, false},
+		{"Column not in the headers", []string{"id", "cost"}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, columns, err := selectColumns(headers, tt.included)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("selectColumns() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) || !reflect.DeepEqual(columns, tt.wantColumns) {
+				t.Errorf("selectColumns() = %v, %v, want %v, %v", keys, columns, tt.wantKeys, tt.wantColumns)
+			}
+		})
+	}
+}
+
+func Test_normalizeHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    []string
+	}{
+		{"Punctuation and spaces", []string{"Total (USD)", "unit/price", "  id  "}, []string{"Total_USD", "unit_price", "id"}},
+		{"Letters and digits are kept", []string{"größe_2", "été"}, []string{"größe_2", "été"}},
+		{"Same normalized name", []string{"a b", "a-b", "a_b"}, []string{"a_b", "a_b_2", "a_b_3"}},
+		{"Suffix already taken", []string{"a_2", "a", "a!"}, []string{"a_2", "a", "a_3"}},
+		{"Nothing left", []string{"id", "(%)"}, []string{"id", "column_2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeHeaders(tt.headers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeHeaders() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findColumn(t *testing.T) {
+	headers := []string{"ID", "E-mail ", "First Name", "first_name", "email"}
+	tests := []struct {
+		name    string
+		column  string
+		fuzzy   bool
+		want    int
+		wantErr bool
+	}{
+		{"Exact", "email", false, 4, false},
+		{"Case is exact without fuzzy", "id", false, -1, false},
+		{"Case", "id", true, 0, false},
+		{"Exact wins over fuzzy", "email", true, 4, false},
+		{"Spaces and dashes", " i-d", true, 0, false},
+		{"Ambiguous", "firstname", true, -1, true},
+		{"Ambiguous with an exact header besides", "E_MAIL", true, -1, true},
+		{"Missing", "phone", true, -1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findColumn(headers, tt.column, tt.fuzzy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findColumn() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("findColumn() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_renameHeaders(t *testing.T) {
+	stripPrefix := []headerRename{{regexp.MustCompile("^col_"), ""}}
+	tests := []struct {
+		name    string
+		headers []string
+		renames []headerRename
+		want    []string
+		wantErr bool
+	}{
+		{"Common prefix stripped", []string{"col_id", "col_name", "price"}, stripPrefix, []string{"id", "name", "price"}, false},
+		{"Renames in order", []string{"col_user_id"}, []headerRename{stripPrefix[0], {regexp.MustCompile("_(\\w)"), "-$1"}}, []string{"user-id"}, false},
+		{"Collision", []string{"col_id", "id"}, stripPrefix, nil, true},
+		{"Renamed into nothing", []string{"col_"}, stripPrefix, nil, true},
+		{"Repeated headers left alone", []string{"a", "a", "col_b"}, stripPrefix, []string{"a", "a", "b"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renameHeaders(tt.headers, tt.renames)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("renameHeaders() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("renameHeaders() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fieldGuard(t *testing.T) {
+	tests := []struct {
+		name      string
+		csvString string
+		limit     int64
+		wantErr   bool
+	}{
+		{"Well-formed file", "COL1,COL2\n1,2\n3,4\n", 16, false},
+		{"Unterminated quote", "COL1,COL2\n\"1,2\n3,4\n5,6\n7,8\n9,10\n", 16, true},
+		{"No limit", "COL1,COL2\n\"1,2\n3,4\n5,6\n7,8\n9,10\n", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := &fieldGuard{r: strings.NewReader(tt.csvString)}
+			guard.reset(tt.limit)
+			reader := csv.NewReader(bufio.NewReaderSize(guard, 16))
+
+			// Reading every line, resetting the guard after each of them like processCsvFile does
+			var err error
+			for err == nil {
+				_, err = reader.Read()
+				guard.reset(tt.limit)
+			}
+			if errors.Is(err, errFieldTooLarge) != tt.wantErr {
+				t.Errorf("fieldGuard error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The allowance of a record doesn't grow with the columns, so a runaway quote in a wide file stops just as soon
+	headers := make([]string, 5000)
+	for i := range headers {
+		headers[i] = fmt.Sprintf("c%d", i)
+	}
+	csvString := strings.Join(headers, ",") + "\n\"" + strings.Repeat("a,", 4<<20)
+	fileData := withOptions(func(f *inputFile) { f.filepath, f.maxFieldBytes, f.bytesRead = "wide.csv", 1024, new(int64) })
+
+	err := convertReader(context.Background(), fileData, strings.NewReader(csvString), ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "bigger than the maximum of 1024 bytes") {
+		t.Errorf("convertReader() error = %v, want the field to be too big", err)
+	}
+	if limit := int64(len(headers)*6) + fileData.maxFieldBytes + recordSlackBytes + 2*defaultReadBuffer; *fileData.bytesRead > limit {
+		t.Errorf("convertReader() read %d bytes of a runaway quote, want at most %d", *fileData.bytesRead, limit)
+	}
+}
+
+func Test_checkFieldSizes(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxFieldBytes int64
+		wantErr       string
+	}{
+		{"Fields within the limit", 5, ""},
+		{"Field bigger than the limit", 4, "Field 2 on line 3 is bigger than the maximum of 4 bytes"},
+		{"No limit", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := csv.NewReader(strings.NewReader("COL1,COL2\n1,2\n3,45678\n"))
+			reader.Read()
+			reader.Read()
+			line, _ := reader.Read()
+
+			err := checkFieldSizes(reader, line, tt.maxFieldBytes)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("checkFieldSizes() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_checkColumnCount(t *testing.T) {
+	// Read with the wrong separator, a file of a single column per line becomes one of many columns
+	wide := strings.Split(strings.Repeat("a;", 999)+"a", ";")
+
+	tests := []struct {
+		name       string
+		headers    []string
+		maxColumns int
+		wantErr    string
+	}{
+		{"Columns within the limit", []string{"id", "name"}, 2, ""},
+		{"Too many columns", wide, 500, "The headers have 1000 columns, more than the maximum of 500. Check the separator, or raise --max-columns or use --force to convert it anyway"},
+		{"No limit", wide, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkColumnCount(tt.headers, tt.maxColumns)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("checkColumnCount() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_singleColumnWarning(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   []string
+		separator string
+		wantHint  string
+	}{
+		{"Several columns", []string{"id", "name;city"}, ",", ""},
+		{"Single column without separators", []string{"id"}, ",", ""},
+		{"Semicolons read with comma", []string{"id;name;city"}, ",", `";"`},
+		{"Commas read with tab", []string{"id,name"}, "\t", `","`},
+		{"Separator of the file", []string{"id||name"}, "|", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := singleColumnWarning(tt.headers, tt.separator)
+			if (got != "") != (tt.wantHint != "") || !strings.Contains(got, tt.wantHint) {
+				t.Errorf("singleColumnWarning() = %q, want one about %s", got, tt.wantHint)
 			}
 		})
 	}
 }
 
 func Test_writeJSONFile(t *testing.T) {
-	// Defining the data maps we want to convert into JSON
-	dataMap := []map[string]string{
-		{"COL1": "1", "COL2": "2", "COL3": "3"},
-		{"COL1": "4", "COL2": "5", "COL3": "6"},
+	// Defining the records we want to convert into JSON
+	headers := []string{"COL1", "COL2", "COL3"}
+	dataMap := []record{
+		{headers, []string{"1", "2", "3"}, nil},
+		{headers, []string{"4", "5", "6"}, nil},
 	}
 	// Defining our test cases
 	tests := []struct {
-		csvPath  string // The "fake" csv path.
-		jsonPath string // The existing JSON file with the expected data
-		pretty   bool   // Whether the output is formatted or not
-		name     string // The name of the test
+		csvPath     string // The "fake" csv path.
+		jsonPath    string // The existing JSON file with the expected data
+		pretty      bool   // Whether the output is formatted or not
+		writeBuffer int    // The size of the output buffer
+		name        string // The name of the test
 	}{
-		{"compact.csv", "compact.json", false, "Compact JSON"},
-		{"pretty.csv", "pretty.json", true, "Pretty JSON"},
+		{"compact.csv", "compact.json", false, defaultWriteBuffer, "Compact JSON"},
+		{"pretty.csv", "pretty.json", true, defaultWriteBuffer, "Pretty JSON"},
+		{"compact.csv", "compact.json", false, 8, "Buffer smaller than the output"},
 	}
 	// Iterating over our test cases
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Creating our mocked channels
-			writerChannel := make(chan map[string]string)
-			done := make(chan bool)
+			writerChannel := make(chan recordBatch)
+			done := make(chan error)
 			// Running a go-routine
 			go func() {
-				// Pushing the dataMap elements into our mocked writerChannel
-				for _, record := range dataMap {
-					writerChannel <- record
+				// Pushing the dataMap elements into our mocked writerChannel, one record per batch
+				for _, rec := range dataMap {
+					writerChannel <- recordBatch{records: []record{rec}}
 				}
 				close(writerChannel)
 			}()
 			// Running our targeted function
-			go writeJSONFile(tt.csvPath, writerChannel, done, tt.pretty)
+			go func() {
+				_, err := writeJSONFile(context.Background(), inputFile{filepath: tt.csvPath, pretty: tt.pretty, writeBuffer: tt.writeBuffer, indent: defaultIndent, outputSuffix: ".json", lineEnding: "\n"}, writerChannel)
+				done <- err
+			}()
 			// Waiting for the past function to end
-			<-done
+			check(<-done)
 			// Getting the text from the JSON file created by the previous function
 			testOutput, err := ioutil.ReadFile(tt.jsonPath)
 
@@ -181,3 +1137,1358 @@ func Test_writeJSONFile(t *testing.T) {
 		})
 	}
 }
+
+func Test_columnsFromSchema(t *testing.T) {
+	// The file has a column the schema doesn't have, and the schema has properties the file doesn't have
+	fileData, err := parseArgs("--columns-from-schema=testJsonFiles/schema.json", "data.csv")
+	check(err)
+
+	got := convertString(t, "id,name,comment\n1,a,x\n2,b,y\n", fileData)
+	if want := `[{"id":"1","name":"a"},{"id":"2","name":"b"}]` + "\n"; got != want {
+		t.Errorf("output = %s, want %s", got, want)
+	}
+}
+
+func Test_emptyOutput(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id,price\n1,5\n2,8\n"), 0666))
+
+	tests := []struct {
+		name    string
+		filters []rangeFilter
+		want    int64
+	}{
+		{"Some records kept", []rangeFilter{{"price", 6, 10}}, 1},
+		{"Filter keeps nothing", []rangeFilter{{"price", 100, math.Inf(1)}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The JSON is still written when it has no records, and main gets the count to fail with
+			got := mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.rangeFilters, f.failOnEmpty = csvPath, tt.filters, true }))
+			if got != tt.want {
+				t.Errorf("convertFile() = %d, want %d", got, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "data.json")); err != nil {
+				t.Errorf("output file got error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_sourceField(t *testing.T) {
+	// Every file of a conversion of several files gets its own JSON, and each record tells which file it came from
+	dir := t.TempDir()
+	check(ioutil.WriteFile(filepath.Join(dir, "east.csv"), []byte("id,name\n1,a\n2,b\n"), 0666))
+	check(ioutil.WriteFile(filepath.Join(dir, "west.csv"), []byte("id,name\n3,c\n"), 0666))
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"east.csv", `[{"id":"1","name":"a","source":"east.csv"},{"id":"2","name":"b","source":"east.csv"}]` + "\n"},
+		{"west.csv", `[{"id":"3","name":"c","source":"west.csv"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.sourceField = filepath.Join(dir, tt.file), "source" }))
+			got, err := ioutil.ReadFile(getJSONPath(filepath.Join(dir, tt.file), ".json"))
+			check(err)
+			if string(got) != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dedupeKey(t *testing.T) {
+	// The customers are upserted, so a later line of the same id is its newer version
+	csvString := "id,name,plan\n1,Ann,free\n2,Bob,free\n1,Ann,pro\n3,Cy,free\n2,Bob,team\n1,Ann,free\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Keep the first", withOptions(func(f *inputFile) { f.dedupeKey, f.dedupeKeep = "id", "first" }),
+			`[{"id":"1","name":"Ann","plan":"free"},{"id":"2","name":"Bob","plan":"free"},{"id":"3","name":"Cy","plan":"free"}]` + "\n"},
+		{"Keep the last", withOptions(func(f *inputFile) { f.dedupeKey, f.dedupeKeep = "id", "last" }),
+			`[{"id":"3","name":"Cy","plan":"free"},{"id":"2","name":"Bob","plan":"team"},{"id":"1","name":"Ann","plan":"free"}]` + "\n"},
+		{"Keep the last in small batches", withOptions(func(f *inputFile) { f.dedupeKey, f.dedupeKeep, f.batchSize = "id", "last", 2 }),
+			`[{"id":"3","name":"Cy","plan":"free"},{"id":"2","name":"Bob","plan":"team"},{"id":"1","name":"Ann","plan":"free"}]` + "\n"},
+		{"Key not included", withOptions(func(f *inputFile) { f.dedupeKey, f.dedupeKeep, f.columns = "id", "first", []string{"plan"} }),
+			`[{"plan":"free"},{"plan":"free"},{"plan":"free"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// The lines without a key are not duplicates of each other
+	for keep, want := range map[string]string{
+		"first": `[{"id":"1","name":"Ann"},{"id":"","name":"Bob"},{"id":"","name":"Cy"}]`,
+		"last":  `[{"id":"","name":"Bob"},{"id":"","name":"Cy"},{"id":"1","name":"Dee"}]`,
+	} {
+		got := convertString(t, "id,name\n1,Ann\n,Bob\n,Cy\n1,Dee\n", withOptions(func(f *inputFile) { f.dedupeKey, f.dedupeKeep = "id", keep }))
+		if got != want+"\n" {
+			t.Errorf("output keeping the %s = %s, want %s", keep, got, want)
+		}
+	}
+}
+
+// Cancels the conversion as soon as the JSON starts being written
+type cancellingWriter struct {
+	cancel context.CancelFunc
+}
+
+func (c cancellingWriter) Write(p []byte) (int, error) {
+	c.cancel()
+	return len(p), nil
+}
+
+func Test_cancellation(t *testing.T) {
+	var csvData strings.Builder
+	csvData.WriteString("id,name\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&csvData, "%d,a\n", i)
+	}
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte(csvData.String()), 0666))
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+	}{
+		{"Streamed records", defaultFileData},
+		{"Sorted records", withOptions(func(f *inputFile) { f.sortBy = sortOrder{column: "id"} })},
+		{"Records held for the last duplicates", withOptions(func(f *inputFile) { f.dedupeKey, f.dedupeKeep = "id", "last" })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The reader stops once it sees the cancellation, which may take it a moment, so VerifyNone
+			// retries for a while before naming the goroutines still running
+			defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+			tt.fileData.filepath, tt.fileData.batchSize, tt.fileData.writeBuffer = csvPath, 10, 16
+
+			// Cancelled before starting, and while writing
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if err := convertToContext(ctx, tt.fileData, ioutil.Discard); !errors.Is(err, context.Canceled) {
+				t.Errorf("convertToContext() with a cancelled context error = %v, want %v", err, context.Canceled)
+			}
+			ctx, cancel = context.WithCancel(context.Background())
+			if err := convertToContext(ctx, tt.fileData, cancellingWriter{cancel}); !errors.Is(err, context.Canceled) {
+				t.Errorf("convertToContext() cancelled while writing error = %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func Test_lineEnding(t *testing.T) {
+	csvString := "id,note\n1,\"a\nb\"\n2,c\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"LF compact", defaultFileData, `[{"id":"1","note":"a\nb"},{"id":"2","note":"c"}]` + "\n"},
+		{"CRLF compact", withOptions(func(f *inputFile) { f.lineEnding = "\r\n" }), `[{"id":"1","note":"a\nb"},{"id":"2","note":"c"}]` + "\r\n"},
+		{"LF pretty", withOptions(func(f *inputFile) { f.pretty, f.indent = true, " " }), "[\n {\n  \"id\": \"1\",\n  \"note\": \"a\\nb\"\n },\n {\n  \"id\": \"2\",\n  \"note\": \"c\"\n }\n]\n"},
+		{"CRLF pretty", withOptions(func(f *inputFile) { f.pretty, f.indent, f.lineEnding = true, " ", "\r\n" }), "[\r\n {\r\n  \"id\": \"1\",\r\n  \"note\": \"a\\nb\"\r\n },\r\n {\r\n  \"id\": \"2\",\r\n  \"note\": \"c\"\r\n }\r\n]\r\n"},
+		{"CRLF record per line", withOptions(func(f *inputFile) { f.recordPerLine, f.lineEnding = true, "\r\n" }), "[\r\n" + `{"id":"1","note":"a\nb"},` + "\r\n" + `{"id":"2","note":"c"}` + "\r\n]\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The files of --split-records end their lines the same way
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte(csvString), 0666))
+	mustConvertFile(t, withOptions(func(f *inputFile) {
+		f.filepath, f.splitRecords, f.recordPerLine, f.lineEnding = csvPath, 1, true, "\r\n"
+	}))
+	got, err := ioutil.ReadFile(getJSONPath(csvPath, ".0002.json"))
+	check(err)
+	if want := "[\r\n" + `{"id":"2","note":"c"}` + "\r\n]\r\n"; string(got) != want {
+		t.Errorf("second file = %q, want %q", got, want)
+	}
+}
+
+func Test_outputLocation(t *testing.T) {
+	tests := []struct {
+		name      string
+		csvPath   string
+		outputDir string
+		want      string
+	}{
+		{"Next to the CSV file", filepath.Join("data", "a.csv"), "", filepath.Join("data", "a.json")},
+		{"Mirrored directories", filepath.Join("data", "2024", "a.csv"), "out", filepath.Join("out", "data", "2024", "a.json")},
+		{"Parent directory", filepath.Join("..", "a.csv"), "out", filepath.Join("out", "a.json")},
+		{"Absolute path", filepath.Join(string(filepath.Separator), "tmp", "a.csv"), "out", filepath.Join("out", "a.json")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outputLocation(withOptions(func(f *inputFile) { f.filepath, f.outputDir = tt.csvPath, tt.outputDir }), ".json"); got != tt.want {
+				t.Errorf("outputLocation() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_createDirs(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id\n1\n"), 0666))
+	outputDir := filepath.Join(dir, "reports", "2024", "05")
+
+	// Without --create-dirs, a missing directory is an error
+	if _, err := createOutput(withOptions(func(f *inputFile) { f.filepath, f.outputDir = csvPath, outputDir })); err == nil {
+		t.Errorf("createOutput() error = nil, want the missing directory")
+	}
+
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.outputDir, f.createDirs, f.atomic = csvPath, outputDir, true, true }))
+	got, err := ioutil.ReadFile(outputLocation(withOptions(func(f *inputFile) { f.filepath, f.outputDir = csvPath, outputDir }), ".json"))
+	check(err)
+	if want := `[{"id":"1"}]` + "\n"; string(got) != want {
+		t.Errorf("output = %s, want %s", got, want)
+	}
+
+	// A directory that can't be created is reported with the output it was for
+	blocker := filepath.Join(dir, "file")
+	check(ioutil.WriteFile(blocker, nil, 0666))
+	_, err = createOutput(withOptions(func(f *inputFile) {
+		f.filepath, f.outputDir, f.createDirs = csvPath, filepath.Join(blocker, "out"), true
+	}))
+	if err == nil || !strings.Contains(err.Error(), "can't be created") {
+		t.Errorf("createOutput() error = %v, want the directory that can't be created", err)
+	}
+}
+
+func Test_flushEvery(t *testing.T) {
+	headers := []string{"id"}
+	batch := []record{{headers, []string{"1"}, nil}, {headers, []string{"2"}, nil}, {headers, []string{"3"}, nil}}
+
+	tests := []struct {
+		name       string
+		flushEvery int64
+		want       string // What the file has while the conversion is still going
+	}{
+		{"Every 2 records", 2, `[{"id":"1"},{"id":"2"}`},
+		{"Only at the end", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fileData := withOptions(func(f *inputFile) { f.filepath, f.flushEvery = filepath.Join(dir, "data.csv"), tt.flushEvery })
+
+			writerChannel := make(chan recordBatch)
+			done := make(chan error)
+			go func() {
+				_, err := writeJSONFile(context.Background(), fileData, writerChannel)
+				done <- err
+			}()
+
+			// The writer only takes the second batch once it's done with the first one
+			writerChannel <- recordBatch{records: batch}
+			writerChannel <- recordBatch{}
+			got, err := ioutil.ReadFile(filepath.Join(dir, "data.json"))
+			check(err)
+			if string(got) != tt.want {
+				t.Errorf("file before the end = %q, want %q", got, tt.want)
+			}
+
+			close(writerChannel)
+			check(<-done)
+			got, err = ioutil.ReadFile(filepath.Join(dir, "data.json"))
+			check(err)
+			if want := `[{"id":"1"},{"id":"2"},{"id":"3"}]` + "\n"; string(got) != want {
+				t.Errorf("file = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// Returns the names of the files in dir
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	check(err)
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func Test_atomicWrite(t *testing.T) {
+	t.Run("Complete output replaces the JSON file", func(t *testing.T) {
+		dir := t.TempDir()
+		fileData := withOptions(func(f *inputFile) { f.filepath, f.atomic = filepath.Join(dir, "data.csv"), true })
+		check(ioutil.WriteFile(filepath.Join(dir, "data.json"), []byte("old"), 0666))
+
+		output, err := createOutput(fileData)
+		check(err)
+		io.WriteString(output, "[")
+		io.WriteString(output, "]")
+		// Until the output is closed, the JSON file must still be the old one
+		if content, _ := ioutil.ReadFile(filepath.Join(dir, "data.json")); string(content) != "old" {
+			t.Errorf("JSON file = %q before the output was complete, want %q", content, "old")
+		}
+		check(output.Close())
+
+		if content, _ := ioutil.ReadFile(filepath.Join(dir, "data.json")); string(content) != "[]" {
+			t.Errorf("JSON file = %q, want %q", content, "[]")
+		}
+		if names := listDir(t, dir); !reflect.DeepEqual(names, []string{"data.json"}) {
+			t.Errorf("directory has %v, want only the JSON file", names)
+		}
+	})
+
+	t.Run("Failed output leaves nothing behind", func(t *testing.T) {
+		dir := t.TempDir()
+		fileData := withOptions(func(f *inputFile) { f.filepath, f.atomic = filepath.Join(dir, "data.csv"), true })
+
+		output, err := createOutput(fileData)
+		check(err)
+		io.WriteString(output, `[{"COL1":"1"},`)
+		// This is what exitGracefully does when the conversion fails
+		runCleanups()
+
+		if names := listDir(t, dir); len(names) != 0 {
+			t.Errorf("directory has %v after a failed conversion, want it empty", names)
+		}
+	})
+}
+
+func Test_extractMeta(t *testing.T) {
+	csvString := "# author: Jane Doe\n# not a key value pair\n#source:  survey 2021\nid,name\n1,a\n2,b\n"
+	tests := []struct {
+		name   string
+		pretty bool
+		want   string
+	}{
+		{"Compact JSON", false, `{"meta":{"author":"Jane Doe","source":"survey 2021"},"records":[{"id":"1","name":"a"},{"id":"2","name":"b"}]}` + "\n"},
+		{"Pretty JSON", true, `{
+   "meta": {
+      "author": "Jane Doe",
+      "source": "survey 2021"
+   },
+   "records": [
+      {
+         "id": "1",
+         "name": "a"
+      },
+      {
+         "id": "2",
+         "name": "b"
+      }
+   ]
+}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			csvPath := filepath.Join(dir, "data.csv")
+			check(ioutil.WriteFile(csvPath, []byte(csvString), 0666))
+
+			mustConvertFile(t, withOptions(func(f *inputFile) {
+				f.filepath, f.pretty, f.rootKey, f.extractMeta = csvPath, tt.pretty, "records", true
+			}))
+
+			got, err := ioutil.ReadFile(filepath.Join(dir, "data.json"))
+			check(err)
+			if string(got) != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_convertTo(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id,name\n1,a\n2,b\n"), 0666))
+
+	var buf bytes.Buffer
+	if err := convertTo(withOptions(func(f *inputFile) { f.filepath = csvPath }), &buf); err != nil {
+		t.Fatalf("convertTo() error = %v", err)
+	}
+	if want := `[{"id":"1","name":"a"},{"id":"2","name":"b"}]` + "\n"; buf.String() != want {
+		t.Errorf("convertTo() wrote %s, want %s", buf.String(), want)
+	}
+	if names := listDir(t, filepath.Dir(csvPath)); !reflect.DeepEqual(names, []string{"data.csv"}) {
+		t.Errorf("directory has %v, want no JSON file", names)
+	}
+}
+
+func Test_convertOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		csvString string
+		fileData  inputFile
+		want      string
+	}{
+		{"Null value", "id,name\n1,NULL\n2,\n", withOptions(func(f *inputFile) { f.nullValue, f.nullValueGiven = "NULL", true }),
+			`[{"id":"1","name":null},{"id":"2","name":""}]` + "\n"},
+		{"Given headers", "1,a\n2,b\n", withOptions(func(f *inputFile) { f.givenHeaders = []string{"id", "name"} }),
+			`[{"id":"1","name":"a"},{"id":"2","name":"b"}]` + "\n"},
+		// Without --ragged, the rows with a trailing separator are not an error anymore
+		{"Trailing separator", "id,name\n1,a,\n2,b\n", withOptions(func(f *inputFile) { f.trailingSeparator = true }),
+			`[{"id":"1","name":"a"},{"id":"2","name":"b"}]` + "\n"},
+		// The columns options use the normalized names
+		{"Normalized headers", "id,Total (USD),Total/USD\n1,2.5,3\n", withOptions(func(f *inputFile) {
+			f.normalizeKeys, f.columns = true, []string{"Total_USD", "Total_USD_2"}
+		}), `[{"Total_USD":"2.5","Total_USD_2":"3"}]` + "\n"},
+		// The names of --columns and --type-map match the headers of the file
+		{"Fuzzy columns", "ID,E-mail ,Total Amount\n1,a@b.c,2.5\n", withOptions(func(f *inputFile) {
+			f.fuzzyColumns, f.columns = true, []string{"email", "total_amount"}
+			f.typeMap = map[string]valueType{"TOTAL-AMOUNT": typeNumber}
+		}), `[{"E-mail ":"a@b.c","Total Amount":2.5}]` + "\n"},
+		// The options taking columns use the renamed headers
+		{"Renamed headers", "col_id,col_name,col_city\n1,Ann,Oslo\n", withOptions(func(f *inputFile) {
+			f.headerRenames, f.columns = []headerRename{{regexp.MustCompile("^col_"), ""}}, []string{"id", "name"}
+		}), `[{"id":"1","name":"Ann"}]` + "\n"},
+		{"Human numbers", "id,reach\n1,3.4k\n2,12%\n3,lots\n", withOptions(func(f *inputFile) { f.typeMap = map[string]valueType{"reach": typeHumanNumber} }),
+			`[{"id":"1","reach":3400},{"id":"2","reach":0.12},{"id":"3","reach":"lots"}]` + "\n"},
+		// The numbers of a column are read the same way, so one written with a decimal point is read with one from then on
+		{"Decimal comma", "amount;rate;ref\n1.234,56;0,5;3.14\n2,5;1.000;1.234\n-0,25;2;2.25\n", withOptions(func(f *inputFile) {
+			f.separator, f.typed, f.decimalComma = "semicolon", true, true
+		}), `[{"amount":1234.56,"rate":0.5,"ref":3.14},{"amount":2.5,"rate":1000,"ref":1.234},{"amount":-0.25,"rate":2,"ref":2.25}]` + "\n"},
+		{"Value case", "country,email,name\nde,Foo@Bar.com,jean-luc o'neil\nfr,foo@bar.com,ÉLODIE DUPONT\n", withOptions(func(f *inputFile) {
+			f.valueCases = []valueCase{{"email", "lower"}, {"country", "upper"}, {"name", "title"}}
+		}), `[{"country":"DE","email":"foo@bar.com","name":"Jean-Luc O'neil"},{"country":"FR","email":"foo@bar.com","name":"Élodie Dupont"}]` + "\n"},
+		{"Indented envelope", "# source: survey\nid,name\n1,a\n", withOptions(func(f *inputFile) {
+			f.pretty, f.indent, f.rootKey, f.extractMeta = true, "  ", "records", true
+		}), "{\n  \"meta\": {\n    \"source\": \"survey\"\n  },\n  \"records\": [\n    {\n      \"id\": \"1\",\n      \"name\": \"a\"\n    }\n  ]\n}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, tt.csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_convertFileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		fileData inputFile
+		wantErr  string
+	}{
+		{"Missing column", "id,name\n1,a\n", withOptions(func(f *inputFile) { f.dedupeKey = "email" }), "Column email to dedupe by is not in the headers"},
+		{"Empty file", "", defaultFileData, "is empty"},
+		{"Broken quote", "id,name\n1,\"a\"b\n", defaultFileData, "parse error on line 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.fileData.filepath = filepath.Join(dir, "data.csv")
+			check(ioutil.WriteFile(tt.fileData.filepath, []byte(tt.csv), 0666))
+
+			// The error comes back to the caller, which decides what to do with it, and the JSON file is removed
+			_, err := convertFile(tt.fileData)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("convertFile() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if names := listDir(t, dir); !reflect.DeepEqual(names, []string{"data.csv"}) {
+				t.Errorf("directory has %v, want no JSON file", names)
+			}
+		})
+	}
+}
+
+func Test_template(t *testing.T) {
+	// The broken quote would stop the conversion, so only the headers may be read
+	csvString := "id,name,price\n1,\"a\"b,2\n"
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Compact JSON", withOptions(func(f *inputFile) { f.template = true }), `{"id":"","name":"","price":""}` + "\n"},
+		{"Pretty JSON", withOptions(func(f *inputFile) { f.template, f.pretty = true, true }), "{\n   \"id\": \"\",\n   \"name\": \"\",\n   \"price\": \"\"\n}\n"},
+		{"Null values", withOptions(func(f *inputFile) { f.template, f.nullValueGiven = true, true }), `{"id":null,"name":null,"price":null}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.fileData.filepath = filepath.Join(dir, "data.csv")
+			check(ioutil.WriteFile(tt.fileData.filepath, []byte(csvString), 0666))
+
+			mustConvertFile(t, tt.fileData)
+
+			got, err := ioutil.ReadFile(filepath.Join(dir, "data.json"))
+			check(err)
+			if string(got) != tt.want {
+				t.Errorf("template = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_typedValues(t *testing.T) {
+	csvString := "active,code,id,price\ntrue,007,1,-2.5e3\nno,,2,abc\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Strings by default", withOptions(func(f *inputFile) {}), `[{"active":"true","code":"007","id":"1","price":"-2.5e3"},{"active":"no","code":"","id":"2","price":"abc"}]` + "\n"},
+		{"Typed", withOptions(func(f *inputFile) { f.typed = true }), `[{"active":true,"code":"007","id":1,"price":-2.5e3},{"active":"no","code":"","id":2,"price":"abc"}]` + "\n"},
+		{"Type map", withOptions(func(f *inputFile) { f.typeMap = map[string]valueType{"price": typeNumber} }), `[{"active":"true","code":"007","id":"1","price":-2.5e3},{"active":"no","code":"","id":"2","price":"abc"}]` + "\n"},
+		{"Type map over typed", withOptions(func(f *inputFile) { f.typed, f.typeMap = true, map[string]valueType{"id": typeString} }), `[{"active":true,"code":"007","id":"1","price":-2.5e3},{"active":"no","code":"","id":"2","price":"abc"}]` + "\n"},
+		{"Stringify over typed", withOptions(func(f *inputFile) { f.typed, f.stringify = true, true }), `[{"active":"true","code":"007","id":"1","price":"-2.5e3"},{"active":"no","code":"","id":"2","price":"abc"}]` + "\n"},
+		{"Stringify over type map", withOptions(func(f *inputFile) { f.typeMap, f.stringify = map[string]valueType{"price": typeNumber}, true }), `[{"active":"true","code":"007","id":"1","price":"-2.5e3"},{"active":"no","code":"","id":"2","price":"abc"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_registeredTypes(t *testing.T) {
+	if got, want := typeNames(), "string, number, boolean, humannumber, currency, point or json"; got != want {
+		t.Errorf("typeNames() = %q, want %q", got, want)
+	}
+
+	// A type registered next to the built-in ones can be given to --type-map
+	builtIn := registeredTypes
+	defer func() { registeredTypes = builtIn }()
+	registeredTypes = append(builtIn[:len(builtIn):len(builtIn)], registeredType{"cents", func(fileData inputFile) valueConverter {
+		return func(dst []byte, value string) ([]byte, bool) {
+			cents, err := strconv.Atoi(value)
+			if err != nil {
+				return dst, false
+			}
+			return strconv.AppendFloat(dst, float64(cents)/100, 'f', 2, 64), true
+		}
+	}})
+	if got, want := typeNames(), "string, number, boolean, humannumber, currency, point, json or cents"; got != want {
+		t.Errorf("typeNames() = %q, want %q", got, want)
+	}
+
+	typeMap, err := parseTypeMap("price:cents")
+	check(err)
+	got := convertString(t, "id,price\n1,1999\n2,free\n", withOptions(func(f *inputFile) { f.typeMap = typeMap }))
+	if want := `[{"id":"1","price":19.99},{"id":"2","price":"free"}]` + "\n"; got != want {
+		t.Errorf("output = %s, want %s", got, want)
+	}
+}
+
+func Test_parseHumanNumber(t *testing.T) {
+	tests := []struct {
+		value  string
+		raw    bool
+		want   string
+		wantOk bool
+	}{
+		{"42", false, "42", true},
+		{"-2.5e3", false, "-2.5e3", true},
+		{"12%", false, "0.12", true},
+		{"12%", true, "12", true},
+		{"0.5%", false, "0.005", true},
+		{"-150%", false, "-1.5", true},
+		{"3.4k", false, "3400", true},
+		{"1.2M", false, "1200000", true},
+		{"0.05K", false, "50", true},
+		{" 7 B ", false, "7000000000", true},
+		{"1.23456k", false, "1234.56", true},
+		{"1.2m", false, "", false},
+		{"1e3k", false, "", false},
+		{"k", false, "", false},
+		{"%", false, "", false},
+		{"12%%", false, "", false},
+		{"abc", false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseHumanNumber(tt.value, tt.raw)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseHumanNumber(%q, %v) = %q, %v, want %q, %v", tt.value, tt.raw, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_parseDecimalComma(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   string
+		wantOk bool
+	}{
+		{"1.234,56", "1234.56", true},
+		{"-12.345.678,9", "-12345678.9", true},
+		{"0,5", "0.5", true},
+		{"1234,5", "1234.5", true},
+		{"42", "42", true},
+		{"1.234", "1234", true},
+		{"1.5", "", false},
+		{"1234.567,8", "", false},
+		{".123,4", "", false},
+		{"1,", "", false},
+		{"1,2,3", "", false},
+		{"1,2.3", "", false},
+		{"01,5", "", false},
+		{"1e3", "", false},
+		{"abc", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseDecimalComma(tt.value)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseDecimalComma(%q) = %q, %v, want %q, %v", tt.value, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_parseCurrency(t *testing.T) {
+	symbols := map[string]string{"$": "USD", "€": "EUR", "kr": "SEK", "R$": "BRL"}
+	tests := []struct {
+		value        string
+		decimalComma bool
+		wantAmount   string
+		wantCurrency string
+		wantOk       bool
+	}{
+		{"$1,299.99", false, "1299.99", "USD", true},
+		{"EUR 45,00", false, "45.00", "EUR", true},
+		{"45,00 €", false, "45.00", "EUR", true},
+		{"(1,299.99)", false, "-1299.99", "", true},
+		{"($1,299.99)", false, "-1299.99", "USD", true},
+		{"-$5", false, "-5", "USD", true},
+		{"$-5", false, "-5", "USD", true},
+		{"R$ 10,50", false, "10.50", "BRL", true},
+		{"100 kr", false, "100", "SEK", true},
+		{"1.299", false, "1.299", "", true},
+		{"1.299", true, "1299", "", true},
+		{"12.5", true, "12.5", "", true},
+		{"GBP1000", false, "1000", "GBP", true},
+		{"(-5)", false, "", "", false},
+		{"$", false, "", "", false},
+		{"five dollars", false, "", "", false},
+		{"$1,29.9", false, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			amount, currency, ok := parseCurrency(tt.value, symbols, tt.decimalComma)
+			if amount != tt.wantAmount || currency != tt.wantCurrency || ok != tt.wantOk {
+				t.Errorf("parseCurrency(%q, %v) = %q, %q, %v, want %q, %q, %v", tt.value, tt.decimalComma, amount, currency, ok, tt.wantAmount, tt.wantCurrency, tt.wantOk)
+			}
+		})
+	}
+
+	// Every cell has its own currency
+	for _, asNumber := range []bool{false, true} {
+		got := convertString(t, "id;price\n1;$1,299.99\n2;EUR 45,00\n3;12\n", withOptions(func(f *inputFile) {
+			f.separator, f.currencyAsNumber = "semicolon", asNumber
+			f.typeMap = map[string]valueType{"price": typeCurrency}
+		}))
+		want := `[{"id":"1","price":{"amount":1299.99,"currency":"USD"}},{"id":"2","price":{"amount":45.00,"currency":"EUR"}},{"id":"3","price":{"amount":12,"currency":null}}]` + "\n"
+		if asNumber {
+			want = `[{"id":"1","price":1299.99},{"id":"2","price":45.00},{"id":"3","price":12}]` + "\n"
+		}
+		if got != want {
+			t.Errorf("output = %s, want %s", got, want)
+		}
+	}
+}
+
+func Test_parseCoordinates(t *testing.T) {
+	tests := []struct {
+		name    string
+		lat     string
+		lon     string
+		wantErr bool
+	}{
+		{"Valid", " 59.91", "10.75 ", false},
+		{"Limits", "-90", "180", false},
+		{"Empty latitude", "", "10.75", true},
+		{"Latitude out of range", "90.5", "0", true},
+		{"Longitude out of range", "0", "-181", true},
+		{"Not a number", "north", "0", true},
+		{"Not a JSON number", "Inf", "0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseCoordinates(tt.lat, tt.lon); (err != nil) != tt.wantErr {
+				t.Errorf("parseCoordinates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	for _, policy := range []string{"null", "skip"} {
+		got := convertString(t, "name,lat,lon\nOslo,59.91,10.75\nNowhere,,\nMars,100,0\n", withOptions(func(f *inputFile) {
+			f.geoPoints, f.geoInvalid = []geoPoint{{"lat", "lon", "location"}}, policy
+			f.typeMap = map[string]valueType{"location": typePoint}
+		}))
+		want := `[{"location":{"type":"Point","coordinates":[10.75,59.91]},"name":"Oslo"}`
+		if policy == "null" {
+			want += `,{"location":null,"name":"Nowhere"},{"location":null,"name":"Mars"}`
+		}
+		if want += "]\n"; got != want {
+			t.Errorf("output with %s = %s, want %s", policy, got, want)
+		}
+	}
+}
+
+func Test_booleanTokens(t *testing.T) {
+	csvString := "active,flag,id\nyes,Y,1\nNO,n,0\ntrue,maybe,2\n"
+	tokens := map[string]bool{"yes": true, "y": true, "1": true, "no": false, "n": false}
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Typed", withOptions(func(f *inputFile) { f.typed, f.booleanTokens = true, tokens }), `[{"active":true,"flag":true,"id":true},{"active":false,"flag":false,"id":0},{"active":true,"flag":"maybe","id":2}]` + "\n"},
+		{"Type map", withOptions(func(f *inputFile) { f.typeMap, f.booleanTokens = map[string]valueType{"flag": typeBoolean}, tokens }), `[{"active":"yes","flag":true,"id":"1"},{"active":"NO","flag":false,"id":"0"},{"active":"true","flag":"maybe","id":"2"}]` + "\n"},
+		{"Stringify", withOptions(func(f *inputFile) { f.typed, f.stringify, f.booleanTokens = true, true, tokens }), `[{"active":"yes","flag":"Y","id":"1"},{"active":"NO","flag":"n","id":"0"},{"active":"true","flag":"maybe","id":"2"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_bigNumberColumns(t *testing.T) {
+	// The digits are written as they are in the file either way, but only the strings survive a float64 reader
+	fileData, err := parseArgs("--typed", "--big-number-columns=id,amount", "data.csv")
+	check(err)
+	got := convertString(t, "amount,id\n0.1000000000000000055511,12345678901234567890\n", fileData)
+	if want := `[{"amount":"0.1000000000000000055511","id":"12345678901234567890"}]` + "\n"; got != want {
+		t.Errorf("output = %s, want %s", got, want)
+	}
+
+	var records []map[string]interface{}
+	check(json.Unmarshal([]byte(got), &records))
+	if records[0]["id"] != "12345678901234567890" {
+		t.Errorf("id = %v, want the 20 digits", records[0]["id"])
+	}
+}
+
+func Test_floatFormat(t *testing.T) {
+	csvString := "id,price\n1000000,1000000.0\n2,1e400\n3,-2.5e-3\n"
+
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{"As in the file", "", `[{"id":1000000,"price":1000000.0},{"id":2,"price":1e400},{"id":3,"price":-2.5e-3}]` + "\n"},
+		{"Two decimals", "%.2f", `[{"id":1000000,"price":1000000.00},{"id":2,"price":1e400},{"id":3,"price":-0.00}]` + "\n"},
+		{"Fixed", "fixed:1", `[{"id":1000000,"price":1000000.0},{"id":2,"price":1e400},{"id":3,"price":-0.0}]` + "\n"},
+		{"Exponent", "%.1e", `[{"id":1000000,"price":1.0e+06},{"id":2,"price":1e400},{"id":3,"price":-2.5e-03}]` + "\n"},
+		{"Shortest", "%g", `[{"id":1000000,"price":1e+06},{"id":2,"price":1e400},{"id":3,"price":-0.0025}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := parseFloatFormat(tt.format)
+			check(err)
+
+			if got := convertString(t, csvString, withOptions(func(f *inputFile) { f.typed, f.floatFormat = true, format })); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	for _, format := range []string{"%d", "%.2s", "fixed:", "fixed:-1", "%5.2f"} {
+		if _, err := parseFloatFormat(format); err == nil {
+			t.Errorf("parseFloatFormat(%q) error = nil, want one", format)
+		}
+	}
+}
+
+func Test_isJSONNumber(t *testing.T) {
+	for value, want := range map[string]bool{
+		"0": true, "-1": true, "3.14": true, "1e10": true, "2.5E-3": true,
+		"": false, "007": false, "+1": false, "1.": false, ".5": false, "1e": false, "Inf": false, "NaN": false, "0x10": false, " 1": false,
+	} {
+		if got := isJSONNumber(value); got != want {
+			t.Errorf("isJSONNumber(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func Test_outputSuffix(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id\n1\n"), 0666))
+
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.outputSuffix, f.checksum = csvPath, ".ndjson", "sha256" }))
+
+	if names := listDir(t, dir); !reflect.DeepEqual(names, []string{"data.csv", "data.ndjson", "data.ndjson.sha256"}) {
+		t.Errorf("files = %v, want the output named with the suffix", names)
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(dir, "data.ndjson")); string(got) != "[{\"id\":\"1\"}]\n" {
+		t.Errorf("output = %q, want the records", got)
+	}
+}
+
+func Test_lineEndings(t *testing.T) {
+	csvString := "id\n1\n2\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Compact JSON", defaultFileData, "[{\"id\":\"1\"},{\"id\":\"2\"}]\n"},
+		{"Record per line", withOptions(func(f *inputFile) { f.recordPerLine = true }), "[\n{\"id\":\"1\"},\n{\"id\":\"2\"}\n]\n"},
+		{"Record per line with root key", withOptions(func(f *inputFile) { f.recordPerLine, f.rootKey = true, "records" }), "{\n\"records\":[\n{\"id\":\"1\"},\n{\"id\":\"2\"}\n]\n}\n"},
+		{"Scalar record per line", withOptions(func(f *inputFile) { f.recordPerLine, f.scalar = true, true }), "[\n\"1\",\n\"2\"\n]\n"},
+		{"No trailing newline", withOptions(func(f *inputFile) { f.noTrailingNewline = true }), "[{\"id\":\"1\"},{\"id\":\"2\"}]"},
+		{"Pretty JSON without trailing newline", withOptions(func(f *inputFile) { f.pretty, f.noTrailingNewline = true, true }), "[\n   {\n      \"id\": \"1\"\n   },\n   {\n      \"id\": \"2\"\n   }\n]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_escaping(t *testing.T) {
+	csvString := "text\n<b>café & crème</b> 🎉\n"
+
+	tests := []struct {
+		name     string
+		escaping jsonEscaping
+		value    string // How the value is written
+	}{
+		{"Like json.Marshal", jsonEscaping{}, `"\u003cb\u003ecafé \u0026 crème\u003c/b\u003e 🎉"`},
+		{"HTML not escaped", jsonEscaping{noEscapeHTML: true}, `"<b>café & crème</b> 🎉"`},
+		{"ASCII", jsonEscaping{ascii: true}, `"\u003cb\u003ecaf\u00e9 \u0026 cr\u00e8me\u003c/b\u003e \ud83c\udf89"`},
+		{"ASCII without escaping HTML", jsonEscaping{noEscapeHTML: true, ascii: true}, `"<b>caf\u00e9 & cr\u00e8me</b> \ud83c\udf89"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for pretty, want := range map[bool]string{
+				false: `[{"text":` + tt.value + "}]\n",
+				true:  "[\n   {\n      \"text\": " + tt.value + "\n   }\n]\n",
+			} {
+				got := convertString(t, csvString, withOptions(func(f *inputFile) { f.pretty, f.escaping = pretty, tt.escaping }))
+				if got != want {
+					t.Errorf("output with pretty %v = %s, want %s", pretty, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_errorFile(t *testing.T) {
+	dir := t.TempDir()
+	csvPath, errorPath := filepath.Join(dir, "data.csv"), filepath.Join(dir, "skipped.csv")
+	// The skipped lines have quotes, a line break inside a value and a CRLF ending, which must all be kept
+	long := strings.Repeat("x", 5000)
+	check(ioutil.WriteFile(csvPath, []byte("id,name\n1,a\n2,\"b, \"\"c\"\"\",extra\r\n3,"+long+"\n4\n\"5\",\"d\ne\",f\n6,g"), 0666))
+
+	var buf bytes.Buffer
+	fileData := withOptions(func(f *inputFile) {
+		f.filepath, f.ragged, f.errorFile, f.readBuffer = csvPath, "skip", errorPath, minReadBuffer
+	})
+	check(convertTo(fileData, &buf))
+
+	if want := `[{"id":"1","name":"a"},{"id":"3","name":"` + long + `"},{"id":"6","name":"g"}]` + "\n"; buf.String() != want {
+		t.Errorf("output = %s, want %s", buf.String(), want)
+	}
+
+	got, err := ioutil.ReadFile(errorPath)
+	check(err)
+	want := "# " + csvPath + ":3: 3 fields instead of 2\n2,\"b, \"\"c\"\"\",extra\r\n" +
+		"# " + csvPath + ":5: 1 fields instead of 2\n4\n" +
+		"# " + csvPath + ":6: 3 fields instead of 2\n\"5\",\"d\ne\",f\n"
+	if string(got) != want {
+		t.Errorf("error file = %q, want %q", got, want)
+	}
+}
+
+func Test_blankRows(t *testing.T) {
+	// The lines of separators only include one with more fields than the headers, and one with quoted empty values
+	csvString := "id,name\n1,a\n\n,\n,,,\n\"\",\"\"\n \t, \n2,b\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Blank rows skipped", withOptions(func(f *inputFile) { f.ragged = "skip" }), `[{"id":"1","name":"a"},{"id":" \t","name":" "},{"id":"2","name":"b"}]` + "\n"},
+		{"Wrong number of fields", defaultFileData, `[{"id":"1","name":"a"},{"id":" \t","name":" "},{"id":"2","name":"b"}]` + "\n"},
+		{"All empty rows skipped", withOptions(func(f *inputFile) { f.skipAllEmptyRows = true }), `[{"id":"1","name":"a"},{"id":"2","name":"b"}]` + "\n"},
+		{"Blank rows kept", withOptions(func(f *inputFile) { f.ragged, f.skipBlankRows = "skip", false }), `[{"id":"1","name":"a"},{"id":"","name":""},{"id":"","name":""},{"id":" \t","name":" "},{"id":"2","name":"b"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_trailingSeparator(t *testing.T) {
+	csvString := "id,name\n1,a,\n2,b\n3,c,d\n4,d,,\n5\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Skipped", withOptions(func(f *inputFile) { f.ragged = "skip" }), `[{"id":"2","name":"b"}]` + "\n"},
+		{"Tolerated", withOptions(func(f *inputFile) { f.ragged, f.trailingSeparator = "skip", true }), `[{"id":"1","name":"a"},{"id":"2","name":"b"}]` + "\n"},
+		{"Tolerated with padding", withOptions(func(f *inputFile) { f.ragged, f.trailingSeparator = "pad", true }), `[{"id":"1","name":"a"},{"id":"2","name":"b"},{"id":"5","name":""}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+}
+
+func Test_defaults(t *testing.T) {
+	csvString := "count,id,name\n1,1,\n\"\",2,b\n0,3\n"
+	defaults := []columnDefault{{"name", "unknown"}, {"count", "0"}}
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Padded line", withOptions(func(f *inputFile) { f.ragged, f.defaults = "pad", defaults }), `[{"count":"1","id":"1","name":"unknown"},{"count":"0","id":"2","name":"b"},{"count":"0","id":"3","name":"unknown"}]` + "\n"},
+		{"Skipped line", withOptions(func(f *inputFile) { f.ragged, f.defaults = "skip", defaults }), `[{"count":"1","id":"1","name":"unknown"},{"count":"0","id":"2","name":"b"}]` + "\n"},
+		{"Defaults before types", withOptions(func(f *inputFile) { f.ragged, f.defaults, f.typed = "pad", defaults, true }), `[{"count":1,"id":1,"name":"unknown"},{"count":0,"id":2,"name":"b"},{"count":0,"id":3,"name":"unknown"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_keyOrder(t *testing.T) {
+	csvString := "name,id,city,name\na,1,x,b\nc,2,y,d\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Alphabetical", defaultFileData, `[{"city":"x","id":"1","name":"b"},{"city":"y","id":"2","name":"d"}]` + "\n"},
+		{"Columns", withOptions(func(f *inputFile) { f.keyOrder = "csv" }), `[{"id":"1","city":"x","name":"b"},{"id":"2","city":"y","name":"d"}]` + "\n"},
+		{"Custom", withOptions(func(f *inputFile) { f.keyOrder, f.keyOrderList = "custom", []string{"name"} }), `[{"name":"b","id":"1","city":"x"},{"name":"d","id":"2","city":"y"}]` + "\n"},
+		{"Custom with every key", withOptions(func(f *inputFile) { f.keyOrder, f.keyOrderList = "custom", []string{"city", "name", "id"} }), `[{"city":"x","name":"b","id":"1"},{"city":"y","name":"d","id":"2"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sampleEvery(t *testing.T) {
+	csvString := "id\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Every line", defaultFileData, `[{"id":"1"},{"id":"2"},{"id":"3"},{"id":"4"},{"id":"5"},{"id":"6"},{"id":"7"},{"id":"8"},{"id":"9"},{"id":"10"}]` + "\n"},
+		{"Every third line", withOptions(func(f *inputFile) { f.sampleEvery = 3 }), `[{"id":"3"},{"id":"6"},{"id":"9"}]` + "\n"},
+		{"Every third line with a limit", withOptions(func(f *inputFile) { f.sampleEvery, f.limit = 3, 2 }), `[{"id":"3"},{"id":"6"}]` + "\n"},
+		{"Range filter over the sampled lines", withOptions(func(f *inputFile) { f.sampleEvery, f.rangeFilters = 3, []rangeFilter{{"id", 4, 10}} }), `[{"id":"6"},{"id":"9"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sampleRate(t *testing.T) {
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		outputs = append(outputs, convertString(t, "id\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", withOptions(func(f *inputFile) { f.sampleRate, f.seed = 0.5, 42 })))
+	}
+
+	if want := `[{"id":"1"},{"id":"2"},{"id":"4"},{"id":"5"},{"id":"6"},{"id":"8"},{"id":"9"}]` + "\n"; outputs[0] != want || outputs[1] != want {
+		t.Errorf("outputs = %v, want %s both times", outputs, want)
+	}
+}
+
+func Test_keyPrefixAndWrapKey(t *testing.T) {
+	csvString := "id,name,age\n1,a,30\n2,b,40\n"
+
+	// The options taking columns still use the headers of the file
+	columns := func(f *inputFile) {
+		f.columns, f.typeMap = []string{"id", "name"}, map[string]valueType{"id": typeNumber}
+	}
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Key prefix", withOptions(func(f *inputFile) { columns(f); f.keyPrefix = "csv_" }), `[{"csv_id":1,"csv_name":"a"},{"csv_id":2,"csv_name":"b"}]` + "\n"},
+		{"Wrap key", withOptions(func(f *inputFile) { columns(f); f.wrapKey = "row" }), `[{"row":{"id":1,"name":"a"}},{"row":{"id":2,"name":"b"}}]` + "\n"},
+		{"Both", withOptions(func(f *inputFile) { columns(f); f.keyPrefix, f.wrapKey = "csv_", "row" }), `[{"row":{"csv_id":1,"csv_name":"a"}},{"row":{"csv_id":2,"csv_name":"b"}}]` + "\n"},
+		{"Pretty JSON", withOptions(func(f *inputFile) { columns(f); f.wrapKey, f.pretty, f.limit = "row", true, 1 }), "[\n   {\n      \"row\": {\n         \"id\": 1,\n         \"name\": \"a\"\n      }\n   }\n]\n"},
+		{"Scalar values", withOptions(func(f *inputFile) { columns(f); f.columns, f.wrapKey, f.scalar = []string{"name"}, "row", true }), `[{"row":"a"},{"row":"b"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newlineHandling(t *testing.T) {
+	csvString := "id,address\n1,\"1 Main St\r\nApt 2\n\nSpringfield\"\n2,plain\n"
+
+	tests := []struct {
+		handling string
+		want     string
+	}{
+		{"keep", `[{"address":"1 Main St\nApt 2\n\nSpringfield","id":"1"},{"address":"plain","id":"2"}]` + "\n"},
+		{"escape", `[{"address":"1 Main St\\nApt 2\\n\\nSpringfield","id":"1"},{"address":"plain","id":"2"}]` + "\n"},
+		{"space", `[{"address":"1 Main St Apt 2 Springfield","id":"1"},{"address":"plain","id":"2"}]` + "\n"},
+		{"strip", `[{"address":"1 Main StApt 2Springfield","id":"1"},{"address":"plain","id":"2"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.handling, func(t *testing.T) {
+			if got := convertString(t, csvString, withOptions(func(f *inputFile) { f.newlineHandling = tt.handling })); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// The CSV reader turns \r\n into \n, but values can still have lone carriage returns
+	if got := handleNewlines("a\r\nb\rc", "escape"); got != `a\r\nb\rc` {
+		t.Errorf("handleNewlines() = %q, want the carriage returns escaped too", got)
+	}
+}
+
+func Test_normalize(t *testing.T) {
+	// The headers and the first value have a decomposed "é", written as "e" followed by a combining accent
+	csvString := "cafe\u0301,id\nRene\u0301e,1\nRenée,2\n\ufb01le,3\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Off", defaultFileData, "[{\"cafe\u0301\":\"Rene\u0301e\",\"id\":\"1\"},{\"cafe\u0301\":\"Renée\",\"id\":\"2\"},{\"cafe\u0301\":\"\ufb01le\",\"id\":\"3\"}]\n"},
+		{"NFC", withOptions(func(f *inputFile) { f.normalize = "nfc" }), "[{\"café\":\"Renée\",\"id\":\"1\"},{\"café\":\"Renée\",\"id\":\"2\"},{\"café\":\"\ufb01le\",\"id\":\"3\"}]\n"},
+		{"NFKC", withOptions(func(f *inputFile) { f.normalize = "nfkc" }), "[{\"café\":\"Renée\",\"id\":\"1\"},{\"café\":\"Renée\",\"id\":\"2\"},{\"café\":\"file\",\"id\":\"3\"}]\n"},
+		{"Columns matched after normalizing", withOptions(func(f *inputFile) { f.normalize, f.columns, f.limit = "nfc", []string{"café"}, 1 }), "[{\"café\":\"Renée\"}]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_changeCase(t *testing.T) {
+	// The mappings don't depend on the language: the Turkish "İ" becomes "i", while "I" doesn't become "ı"
+	if got := changeCase("İSTANBUL ıŞIK", "lower"); got != "istanbul ışik" {
+		t.Errorf("changeCase() = %q, want %q", got, "istanbul ışik")
+	}
+}
+
+func Test_convertTSV(t *testing.T) {
+	dir := t.TempDir()
+	check(ioutil.WriteFile(filepath.Join(dir, "data.tsv"), []byte("id\tname\n1\ta,b\n"), 0666))
+
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath = filepath.Join(dir, "data.tsv") }))
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "data.json"))
+	check(err)
+	if want := `[{"id":"1","name":"a,b"}]` + "\n"; string(got) != want {
+		t.Errorf("output = %s, want %s", got, want)
+	}
+}
+
+func Test_scalar(t *testing.T) {
+	csvString := "tag\na\n\"b, c\"\nNULL\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Compact JSON", withOptions(func(f *inputFile) { f.scalar = true }), `["a","b, c","NULL"]` + "\n"},
+		{"Pretty JSON", withOptions(func(f *inputFile) { f.scalar, f.pretty = true, true }), "[\n   \"a\",\n   \"b, c\",\n   \"NULL\"\n]\n"},
+		{"Null values", withOptions(func(f *inputFile) { f.scalar, f.nullValue, f.nullValueGiven = true, "NULL", true }), `["a","b, c",null]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tabs(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id,name\n1,a\n"), 0666))
+
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.pretty, f.indent = csvPath, true, "\t" }))
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "data.json"))
+	check(err)
+	if want := "[\n\t{\n\t\t\"id\": \"1\",\n\t\t\"name\": \"a\"\n\t}\n]\n"; string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func Test_checksum(t *testing.T) {
+	for _, atomic := range []bool{false, true} {
+		t.Run(fmt.Sprintf("atomic=%v", atomic), func(t *testing.T) {
+			dir := t.TempDir()
+			csvPath := filepath.Join(dir, "data.csv")
+			check(ioutil.WriteFile(csvPath, []byte("id,name\n1,a\n2,b\n"), 0666))
+
+			mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.checksum, f.atomic = csvPath, "sha256", atomic }))
+
+			output, err := ioutil.ReadFile(filepath.Join(dir, "data.json"))
+			check(err)
+			got, err := ioutil.ReadFile(filepath.Join(dir, "data.json.sha256"))
+			check(err)
+			sum := sha256.Sum256(output)
+			if want := hex.EncodeToString(sum[:]) + "  data.json\n"; string(got) != want {
+				t.Errorf("checksum file = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func Test_emptyFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		csvString string
+		fileData  inputFile
+		want      string
+	}{
+		{"Empty file", "", withOptions(func(f *inputFile) { f.allowEmpty = true }), "[]\n"},
+		{"Empty file with only a separator directive", "sep=;\n", withOptions(func(f *inputFile) { f.allowEmpty, f.pretty = true, true }), "[\n]\n"},
+		{"Template of an empty file", "", withOptions(func(f *inputFile) { f.allowEmpty, f.template = true, true }), "{}\n"},
+		{"Headers only", "id,name\n", defaultFileData, "[]\n"},
+		{"Headers only in pretty JSON", "id,name", withOptions(func(f *inputFile) { f.pretty = true }), "[\n]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.fileData.filepath = filepath.Join(dir, "data.csv")
+			check(ioutil.WriteFile(tt.fileData.filepath, []byte(tt.csvString), 0666))
+
+			mustConvertFile(t, tt.fileData)
+
+			got, err := ioutil.ReadFile(filepath.Join(dir, "data.json"))
+			check(err)
+			if string(got) != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_failedOutput(t *testing.T) {
+	tests := []struct {
+		name      string
+		fileData  inputFile
+		wantNames []string
+	}{
+		{"Broken JSON file is removed", defaultFileData, nil},
+		{"JSON file is kept for resuming", withOptions(func(f *inputFile) { f.checkpoint = "progress.json" }), []string{"data.json"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.fileData.filepath = filepath.Join(dir, "data.csv")
+
+			output, err := createOutput(tt.fileData)
+			check(err)
+			io.WriteString(output, `[{"COL1":"1"},`)
+			// This is what exitGracefully does when the conversion fails
+			runCleanups()
+
+			if names := listDir(t, dir); !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("directory has %v after a failed conversion, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func Test_getJSONFunc(t *testing.T) {
+	// The records are compared against what encoding/json produces for the equivalent map
+	tests := []struct {
+		name    string
+		headers []string
+		values  []string
+	}{
+		{"Unsorted headers", []string{"b", "c", "a"}, []string{"1", "2", "3"}},
+		{"Repeated header keeps the last value", []string{"a", "b", "a"}, []string{"1", "2", "3"}},
+		{"Characters that need escaping", []string{"<html>", "quote", "control"}, []string{"Tom & Jerry", `say "hi" \o/`, "line\nbreak\t\x01"}},
+		{"Unicode and invalid UTF-8", []string{"name", "sep", "bad"}, []string{"café crème", "\u2028\u2029", "\xff\xfe"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordMap := make(map[string]string)
+			for i, name := range tt.headers {
+				recordMap[name] = tt.values[i]
+			}
+			rec := record{tt.headers, tt.values, nil}
+
+			compactFunc, _ := getJSONFunc(defaultFileData, defaultIndent)
+			want, _ := json.Marshal(recordMap)
+			if got := compactFunc(rec); got != string(want) {
+				t.Errorf("getJSONFunc(false) = %v, want %v", got, string(want))
+			}
+
+			for _, indent := range []string{defaultIndent, "\t"} {
+				prettyFunc, _ := getJSONFunc(withOptions(func(f *inputFile) { f.pretty, f.indent = true, indent }), indent)
+				want, _ = json.MarshalIndent(recordMap, indent, indent)
+				if got := prettyFunc(rec); got != indent+string(want) {
+					t.Errorf("getJSONFunc(true, %q) = %v, want %v", indent, got, indent+string(want))
+				}
+			}
+		})
+	}
+}
+
+func Test_concatFields(t *testing.T) {
+	csvString := "id,first,last\n1,Ada,Lovelace\n2,Alan,\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Combined field", withOptions(func(f *inputFile) {
+			f.concatFields = []concatField{{"full_name", []string{"first", "last"}}}
+		}), `[{"first":"Ada","full_name":"Ada Lovelace","id":"1","last":"Lovelace"},{"first":"Alan","full_name":"Alan ","id":"2","last":""}]`},
+		{"Sources excluded", withOptions(func(f *inputFile) {
+			f.concatFields, f.concatSep, f.excluded = []concatField{{"name", []string{"last", "first"}}}, ", ", []string{"first", "last"}
+		}), `[{"id":"1","name":"Lovelace, Ada"},{"id":"2","name":", Alan"}]`},
+		{"Key of an excluded column", withOptions(func(f *inputFile) {
+			f.concatFields, f.excluded, f.columns = []concatField{{"first", []string{"first", "last"}}}, []string{"first"}, []string{"first", "last"}
+		}), `[{"first":"Ada Lovelace","last":"Lovelace"},{"first":"Alan ","last":""}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, tt.fileData); got != tt.want+"\n" {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_extractColumn(t *testing.T) {
+	csvString := "id,email,age\n1,ann@example.com,31\n2,\"bob, jr@example.com\",17\n3,cy@example.com,45\n"
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Every row", withOptions(func(f *inputFile) {}), "ann@example.com\nbob, jr@example.com\ncy@example.com\n"},
+		{"Filtered rows", withOptions(func(f *inputFile) { f.rangeFilters = []rangeFilter{{"age", 18, math.Inf(1)}} }), "ann@example.com\ncy@example.com\n"},
+		{"No rows left", withOptions(func(f *inputFile) { f.rangeFilters = []rangeFilter{{"age", 100, math.Inf(1)}} }), ""},
+		{"Pretty changes nothing", withOptions(func(f *inputFile) { f.pretty, f.rangeFilters = true, []rangeFilter{{"id", 3, 3}} }), "cy@example.com\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileData := tt.fileData
+			fileData.extractColumn, fileData.columns = "email", []string{"email"}
+			if got := convertString(t, csvString, fileData); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Number of rows of the generated fixture used by the benchmarks
+const benchmarkRows = 5000000
+
+// Creates a narrow CSV file with benchmarkRows rows inside dir and returns its path
+func createBenchmarkFixture(b *testing.B, dir string) string {
+	csvPath := filepath.Join(dir, "bench.csv")
+
+	f, err := os.Create(csvPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.WriteString("id,name,value\n")
+	for i := 0; i < benchmarkRows; i++ {
+		fmt.Fprintf(w, "%d,name%d,%d\n", i, i%100, i*7)
+	}
+	if err := w.Flush(); err != nil {
+		b.Fatal(err)
+	}
+
+	return csvPath
+}
+
+func Benchmark_conversion(b *testing.B) {
+	csvPath := createBenchmarkFixture(b, b.TempDir())
+	benchmarks := []struct {
+		name        string
+		batchSize   int
+		writeBuffer int
+	}{
+		// A batch size of 1 behaves like sending every record on its own through the channel
+		{"batch=1", 1, defaultWriteBuffer},
+		{fmt.Sprintf("batch=%d", defaultBatchSize), defaultBatchSize, defaultWriteBuffer},
+		// A write buffer of 1 byte behaves like writing every fragment directly to the file
+		{"write-buffer=1", defaultBatchSize, 1},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			fileData := inputFile{filepath: csvPath, separator: "comma", batchSize: bm.batchSize, writeBuffer: bm.writeBuffer, readBuffer: defaultReadBuffer, maxFieldBytes: defaultMaxFieldBytes, outputSuffix: ".json", lineEnding: "\n"}
+			b.ReportAllocs()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				_, err := convertFile(fileData)
+				check(err)
+			}
+			b.ReportMetric(float64(benchmarkRows*b.N)/time.Since(start).Seconds(), "rows/s")
+		})
+	}
+}
@@ -0,0 +1,116 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func Test_checkIfValidFileSpecialFiles(t *testing.T) {
+	dir := t.TempDir()
+	check(syscall.Mkfifo(filepath.Join(dir, "pipe.csv"), 0666))
+	check(syscall.Mkfifo(filepath.Join(dir, "feed"), 0666))
+	listener, err := net.Listen("unix", filepath.Join(dir, "socket.csv"))
+	check(err)
+	defer listener.Close()
+	check(ioutil.WriteFile(filepath.Join(dir, "secret.csv"), nil, 0))
+
+	tests := []struct {
+		name     string
+		filename string
+		wantErr  bool
+	}{
+		{"Named pipe", filepath.Join(dir, "pipe.csv"), false},
+		{"Named pipe without an extension", filepath.Join(dir, "feed"), false},
+		{"Socket", filepath.Join(dir, "socket.csv"), true},
+	}
+	// Root can read any file, so the permissions can only be checked as another user
+	if os.Geteuid() != 0 {
+		tests = append(tests, struct {
+			name     string
+			filename string
+			wantErr  bool
+		}{"File can't be read", filepath.Join(dir, "secret.csv"), true})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := checkIfValidFile(tt.filename, false, false); (err != nil) != tt.wantErr {
+				t.Errorf("checkIfValidFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_convertPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	check(err)
+	defer r.Close()
+	go func() {
+		w.Write([]byte("id,name\n1,a\n"))
+		w.Write([]byte("2,b\n"))
+		w.Close()
+	}()
+
+	// The pipe is read until its writer closes it, and its JSON file has to go somewhere else
+	pipePath := fmt.Sprintf("/dev/fd/%d", r.Fd())
+	if _, err := checkIfValidFile(pipePath, false, false); err != nil {
+		t.Fatalf("checkIfValidFile() error = %v", err)
+	}
+	fileData := withOptions(func(f *inputFile) { f.filepath = pipePath })
+	if err := checkPipe(fileData); err == nil {
+		t.Errorf("checkPipe() error = nil without an output directory")
+	}
+
+	fileData.outputDir = t.TempDir()
+	check(checkPipe(fileData))
+	mustConvertFile(t, fileData)
+	got, err := ioutil.ReadFile(outputLocation(fileData, fileData.outputSuffix))
+	check(err)
+	if want := `[{"id":"1","name":"a"},{"id":"2","name":"b"}]` + "\n"; string(got) != want {
+		t.Errorf("output = %s, want %s", got, want)
+	}
+}
+
+func Test_outputMode(t *testing.T) {
+	// The umask would leave 0600, so the mode only comes out right when it's set exactly
+	defer syscall.Umask(syscall.Umask(077))
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id,name\n1,a\n2\n3,c\n"), 0666))
+	mode := fileMode{perm: 0640, given: true}
+
+	mustConvertFile(t, withOptions(func(f *inputFile) {
+		f.filepath, f.outputMode, f.atomic, f.checksum, f.profile = csvPath, mode, true, "sha256", true
+		f.ragged, f.errorFile = "skip", filepath.Join(dir, "errors.csv")
+	}))
+	mustConvertFile(t, withOptions(func(f *inputFile) {
+		f.filepath, f.outputMode, f.splitRecords, f.manifest, f.ragged = csvPath, mode, 1, filepath.Join(dir, "shards.json"), "skip"
+	}))
+
+	for _, name := range []string{"data.json", "data.json.sha256", "data.json.profile.json", "errors.csv", "data.0001.json", "data.0002.json", "shards.json"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s got error: %v", name, err)
+			continue
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("%s has mode %o, want 640", name, info.Mode().Perm())
+		}
+	}
+
+	// Without --output-mode, the umask applies like it does to any other file
+	syscall.Umask(022)
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.outputSuffix, f.ragged = csvPath, ".default.json", "skip" }))
+	info, err := os.Stat(filepath.Join(dir, "data.default.json"))
+	check(err)
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("data.default.json has mode %o, want 644", info.Mode().Perm())
+	}
+}
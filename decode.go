@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// The encodings --decode takes
+var decodeEncodings = map[string]func(string) ([]byte, error){
+	"base64": decodeBase64,
+	"hex":    hex.DecodeString,
+}
+
+// A column whose values are decoded, given to --decode as COLUMN:ENCODING
+type columnDecoding struct {
+	column   string
+	encoding string
+}
+
+// Parses a decoded column written as COLUMN:ENCODING, like payload:base64
+func parseColumnDecoding(value string) (columnDecoding, error) {
+	separatorIndex := strings.LastIndex(value, ":")
+	if separatorIndex <= 0 {
+		return columnDecoding{}, fmt.Errorf("Decoded column %s must be written as COLUMN:ENCODING", value)
+	}
+
+	column, encoding := strings.TrimSpace(value[:separatorIndex]), strings.ToLower(strings.TrimSpace(value[separatorIndex+1:]))
+	if _, found := decodeEncodings[encoding]; !found {
+		return columnDecoding{}, fmt.Errorf("Unknown encoding %s of column %s. Use base64 or hex", encoding, column)
+	}
+
+	return columnDecoding{column, encoding}, nil
+}
+
+// Decodes base64 with or without its padding, in the standard alphabet or the one of URLs
+func decodeBase64(value string) ([]byte, error) {
+	var err error
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		var decoded []byte
+		if decoded, err = encoding.DecodeString(value); err == nil {
+			return decoded, nil
+		}
+	}
+	return nil, err
+}
+
+// A column whose values processLine decodes. apply also tells if the value is to be written as null
+type columnDecoder struct {
+	index  int
+	column string
+	apply  func(string) (string, bool, error)
+}
+
+// Returns the function decoding the values of a column. The decoded bytes are written as text when they are UTF-8.
+// Otherwise they can't go into the JSON as they are, so binary keep leaves the value encoded like in the file,
+// and replace writes the text with U+FFFD in place of the bytes that are not UTF-8.
+// A value that's not valid in the encoding is kept like it is with invalid keep, written as null with null,
+// and an error with error, which skips its line. An empty value is valid, so it stays an empty string
+func newColumnDecoder(index int, decoding columnDecoding, invalid string, binary string) columnDecoder {
+	decode := decodeEncodings[decoding.encoding]
+	apply := func(value string) (string, bool, error) {
+		decoded, err := decode(strings.TrimSpace(value))
+		if err != nil {
+			switch invalid {
+			case "keep":
+				return value, false, nil
+			case "null":
+				return "", true, nil
+			}
+			return "", false, fmt.Errorf("Value of column %s is not valid %s. Skipping", decoding.column, decoding.encoding)
+		}
+
+		if utf8.Valid(decoded) {
+			return string(decoded), false, nil
+		}
+		if binary == "replace" {
+			return strings.ToValidUTF8(string(decoded), string(utf8.RuneError)), false, nil
+		}
+		return value, false, nil
+	}
+
+	return columnDecoder{index, decoding.column, apply}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func Test_newColumnDecoder(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		invalid  string
+		binary   string
+		value    string
+		want     string
+		wantNull bool
+		wantErr  bool
+	}{
+		{"Base64", "base64", "error", "keep", "aGVsbG8gd29ybGQ=", "hello world", false, false},
+		{"Base64 without padding", "base64", "error", "keep", "aGVsbG8", "hello", false, false},
+		{"Base64 of URLs", "base64", "error", "keep", "w6k_", "é?", false, false},
+		{"Spaces around", "base64", "error", "keep", " aGk= ", "hi", false, false},
+		{"Hex", "hex", "error", "keep", "48656C6c6f", "Hello", false, false},
+		{"Empty", "hex", "error", "keep", "", "", false, false},
+		{"Empty is not null", "base64", "null", "keep", "", "", false, false},
+		{"Invalid is an error", "base64", "error", "keep", "not base64!", "", false, true},
+		{"Invalid kept", "hex", "keep", "keep", "xyz", "xyz", false, false},
+		{"Invalid is null", "hex", "null", "keep", "xyz", "", true, false},
+		{"Binary kept encoded", "base64", "error", "keep", "//79", "//79", false, false},
+		{"Binary replaced", "hex", "error", "replace", "6869ff", "hi�", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := newColumnDecoder(0, columnDecoding{"payload", tt.encoding}, tt.invalid, tt.binary)
+			got, null, err := decoder.apply(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || null != tt.wantNull {
+				t.Errorf("apply() = %q, %v, want %q, %v", got, null, tt.want, tt.wantNull)
+			}
+		})
+	}
+
+	// The values that can't be decoded are null with --decode-invalid=null, and their rows are skipped by default.
+	// An empty value is valid, so it stays an empty string either way
+	for invalid, want := range map[string]string{
+		"null":  `[{"id":"1","payload":"hi"},{"id":"2","payload":null},{"id":"3","payload":""}]`,
+		"error": `[{"id":"1","payload":"hi"},{"id":"3","payload":""}]`,
+	} {
+		got := convertString(t, "id,payload\n1,aGk=\n2,%%\n3,\n", withOptions(func(f *inputFile) {
+			f.decodings, f.decodeInvalid, f.decodeBinary = []columnDecoding{{"payload", "base64"}}, invalid, "keep"
+		}))
+		if got != want+"\n" {
+			t.Errorf("output with %s = %s, want %s", invalid, got, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// The records of a JSON array by the value of their key field, for --diff-against. The keys keep the order of the array
+type keyedRecords struct {
+	keys    []string
+	records map[string]json.RawMessage
+	fields  map[string]map[string]string // The compact JSON of every field of each record, to compare them
+}
+
+// A record found in both files with different fields
+type changedRecord struct {
+	Key    string          `json:"key"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// What --diff-against writes next to the JSON file. Added and changed records are in the order of the new file,
+// and removed ones in the order of the previous one
+type recordDiff struct {
+	Added   []json.RawMessage `json:"added"`
+	Removed []json.RawMessage `json:"removed"`
+	Changed []changedRecord   `json:"changed"`
+}
+
+// Returns the value of the key field of a record as text. A string is taken without its quotes, so the key of
+// a typed file matches the one of an untyped file
+func recordKey(value json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		return text
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, value); err != nil {
+		return string(value)
+	}
+	return compact.String()
+}
+
+// Reads the records of a JSON array by their key field. Every record must have the key, and no two records can
+// have the same one, since the records of the two files are matched by it. A missing file has no records, so
+// the first diff of a file has all of them as added
+func loadKeyedRecords(path string, keyField string) (keyedRecords, error) {
+	keyed := keyedRecords{records: make(map[string]json.RawMessage), fields: make(map[string]map[string]string)}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return keyed, nil
+	}
+	if err != nil {
+		return keyedRecords{}, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return keyedRecords{}, fmt.Errorf("%s can't be compared, since it's not a JSON array", path)
+	}
+
+	for n := 1; decoder.More(); n++ {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return keyedRecords{}, fmt.Errorf("%s can't be compared: %v", path, err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return keyedRecords{}, fmt.Errorf("Record %d of %s is not an object", n, path)
+		}
+
+		value, found := fields[keyField]
+		if !found {
+			return keyedRecords{}, fmt.Errorf("Record %d of %s has no %s field", n, path, keyField)
+		}
+		key := recordKey(value)
+		if _, seen := keyed.records[key]; seen {
+			return keyedRecords{}, fmt.Errorf("Record %d of %s has the %s %s of a previous record", n, path, keyField, key)
+		}
+
+		compared := make(map[string]string, len(fields))
+		for name, value := range fields {
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, value); err != nil {
+				return keyedRecords{}, err
+			}
+			compared[name] = compact.String()
+		}
+		keyed.keys = append(keyed.keys, key)
+		keyed.records[key] = raw
+		keyed.fields[key] = compared
+	}
+
+	return keyed, nil
+}
+
+// Compares the records of the previous file with the ones of the new file. A record is changed when any of its
+// fields has another value, or when it gained or lost a field
+func diffRecords(before, after keyedRecords) recordDiff {
+	diff := recordDiff{Added: []json.RawMessage{}, Removed: []json.RawMessage{}, Changed: []changedRecord{}}
+
+	for _, key := range after.keys {
+		previous, found := before.records[key]
+		if !found {
+			diff.Added = append(diff.Added, after.records[key])
+		} else if !reflect.DeepEqual(before.fields[key], after.fields[key]) {
+			diff.Changed = append(diff.Changed, changedRecord{key, previous, after.records[key]})
+		}
+	}
+	for _, key := range before.keys {
+		if _, found := after.records[key]; !found {
+			diff.Removed = append(diff.Removed, before.records[key])
+		}
+	}
+
+	return diff
+}
+
+// Writes the differences next to the JSON file, as <file>.diff.json, indented and escaped like the JSON file
+func writeDiff(jsonPath string, diff recordDiff, fileData inputFile) error {
+	var content bytes.Buffer
+	if err := json.Indent(&content, fileData.escaping.appendEncoded(nil, diff), "", fileData.indent); err != nil {
+		return err
+	}
+
+	diffPath := jsonPath + ".diff.json"
+	if err := fileData.outputMode.writeFile(diffPath, append(content.Bytes(), '\n')); err != nil {
+		return err
+	}
+
+	logger.infof("%d added, %d removed and %d changed records against %s written to %s", len(diff.Added), len(diff.Removed), len(diff.Changed), fileData.diffAgainst, diffPath)
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_diffAgainst(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	previousPath := filepath.Join(dir, "previous.json")
+	check(ioutil.WriteFile(csvPath, []byte("id,name\n1,a\n2,B\n4,d\n"), 0666))
+	check(ioutil.WriteFile(previousPath, []byte("[\n{\"id\": \"1\", \"name\": \"a\"},\n{\"name\": \"b\", \"id\": \"2\"},\n{\"id\": \"3\", \"name\": \"c\"}\n]\n"), 0666))
+
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.diffAgainst, f.keyColumn = csvPath, previousPath, "id" }))
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "data.json.diff.json"))
+	check(err)
+	want := `{
+   "added": [
+      {
+         "id": "4",
+         "name": "d"
+      }
+   ],
+   "removed": [
+      {
+         "id": "3",
+         "name": "c"
+      }
+   ],
+   "changed": [
+      {
+         "key": "2",
+         "before": {
+            "name": "b",
+            "id": "2"
+         },
+         "after": {
+            "id": "2",
+            "name": "B"
+         }
+      }
+   ]
+}
+`
+	if string(got) != want {
+		t.Errorf("diff = %s, want %s", got, want)
+	}
+}
+
+func Test_loadKeyedRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantKeys int
+		wantErr  bool
+	}{
+		{"Records", `[{"id": 1, "name": "a"}, {"id": "2"}]`, 2, false},
+		{"Empty array", `[]`, 0, false},
+		{"Not an array", `{"id": "1"}`, 0, true},
+		{"Record without the key", `[{"id": "1"}, {"name": "b"}]`, 0, true},
+		{"Repeated key", `[{"id": "1"}, {"id": 1}]`, 0, true},
+		{"Record that is not an object", `[{"id": "1"}, 2]`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "previous.json")
+			check(ioutil.WriteFile(path, []byte(tt.content), 0666))
+
+			got, err := loadKeyedRecords(path, "id")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadKeyedRecords() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got.keys) != tt.wantKeys {
+				t.Errorf("loadKeyedRecords() found keys %v, want %d", got.keys, tt.wantKeys)
+			}
+		})
+	}
+
+	// The first diff of a file has nothing to compare with
+	if got, err := loadKeyedRecords(filepath.Join(t.TempDir(), "missing.json"), "id"); err != nil || len(got.keys) != 0 {
+		t.Errorf("loadKeyedRecords() of a missing file = %v, %v, want no records", got.keys, err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Default number of times a download is tried again after a connection error or a 5xx response
+const defaultRetries = 2
+
+// Default wait before the first retry of a download. Every retry waits twice as long as the one before
+const defaultRetryDelay = time.Second
+
+// How long a download can go without receiving anything, while waiting for the response or for the next part of
+// its body. A big file takes as long as it needs, as long as it keeps arriving
+var downloadTimeout = 30 * time.Second
+
+// The client the URLs are downloaded with
+var httpClient = &http.Client{}
+
+// Validates if a location is the URL of a file to download instead of a path
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// Validates that the JSON file of a URL has somewhere to go. The download is removed once the program ends,
+// so the JSON file can't be next to it
+func checkURL(location string, fileData inputFile) error {
+	if fileData.outputDir == "" && fileData.outputTemplate == "" && fileData.preview == 0 && !fileData.dryValidate {
+		return fmt.Errorf("%s is a URL, so its JSON file must be named with --output-dir or --output-template", location)
+	}
+
+	return nil
+}
+
+// The temporary directory the URLs of a run are downloaded into. It's only created by the first download, and
+// removed when the program ends, on an error too
+type downloadDir struct {
+	path          string
+	removeCleanup func()
+}
+
+// Downloads a URL given as a file, returning the file it was downloaded into
+func (d *downloadDir) fetch(location string, fileData inputFile) (string, error) {
+	if d.path == "" {
+		dir, err := os.MkdirTemp("", "csv2json-")
+		if err != nil {
+			return "", err
+		}
+		d.path = dir
+		d.removeCleanup = addCleanup(func() { os.RemoveAll(dir) })
+	}
+
+	return fetchURL(location, d.path, fileData.retries, fileData.retryDelay)
+}
+
+// Removes the downloads, once every file is converted
+func (d *downloadDir) remove() {
+	if d.path != "" {
+		d.removeCleanup()
+		os.RemoveAll(d.path)
+	}
+}
+
+// An error of a download that's worth trying again, like a connection reset or a 503
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+// Downloads a URL into a file of dir, named like the last element of the URL path, so it's converted like a local
+// file with that name. Connection errors and 5xx responses are tried again up to retries times, waiting delay
+// before the first retry and twice as long before each of the next ones. Other responses, like a 404, fail right away
+func fetchURL(location string, dir string, retries int, delay time.Duration) (string, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("URL %s is not valid: %v", location, err)
+	}
+	name := path.Base(parsed.Path)
+	if name == "." || name == "/" {
+		name = "download"
+	}
+	localPath := filepath.Join(dir, name)
+
+	for attempt := 0; ; attempt++ {
+		err = downloadFile(location, localPath)
+		if _, transient := err.(transientError); !transient || attempt == retries {
+			break
+		}
+		logger.warnf("Downloading %s failed: %v. Trying again in %s", location, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s can't be downloaded: %v", location, err)
+	}
+
+	logger.infof("Downloaded %s", location)
+
+	return localPath, nil
+}
+
+// Downloads a URL into a file, once. The download is cancelled once nothing arrives for downloadTimeout, which is
+// worth trying again like a connection error
+func downloadFile(location string, localPath string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	idle := time.AfterFunc(downloadTimeout, cancel)
+	defer idle.Stop()
+	transient := func(err error) error {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("nothing was received for %s", downloadTimeout)
+		}
+		return transientError{err}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return transient(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 500 {
+		return transientError{fmt.Errorf("the server answered %s", response.Status)}
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("the server answered %s", response.Status)
+	}
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, idleReader{response.Body, idle}); err != nil {
+		file.Close()
+		// The connection broke or stalled in the middle of the body
+		return transient(err)
+	}
+	return file.Close()
+}
+
+// Restarts the timer of a download every time some of its body arrives
+type idleReader struct {
+	r     io.Reader
+	timer *time.Timer
+}
+
+func (i idleReader) Read(p []byte) (int, error) {
+	n, err := i.r.Read(p)
+	if n > 0 {
+		i.timer.Reset(downloadTimeout)
+	}
+	return n, err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Starts a server answering the first requests with the given statuses, and the rest with a CSV file
+func stubServer(t *testing.T, statuses ...int) (*httptest.Server, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= len(statuses) {
+			w.WriteHeader(statuses[requests-1])
+			return
+		}
+		w.Write([]byte("a,b\n1,2\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func Test_fetchURL(t *testing.T) {
+	t.Run("Retried until it succeeds", func(t *testing.T) {
+		server, requests := stubServer(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+
+		local, err := fetchURL(server.URL+"/files/data.csv", t.TempDir(), 2, time.Millisecond)
+		if err != nil {
+			t.Fatalf("fetchURL() error = %v", err)
+		}
+		if *requests != 3 {
+			t.Errorf("fetchURL() made %d requests, want 3", *requests)
+		}
+		content, err := ioutil.ReadFile(local)
+		check(err)
+		if string(content) != "a,b\n1,2\n" {
+			t.Errorf("fetchURL() downloaded %q", content)
+		}
+		if filepath.Base(local) != "data.csv" {
+			t.Errorf("fetchURL() downloaded into %s, want a data.csv file", local)
+		}
+	})
+
+	t.Run("Out of retries", func(t *testing.T) {
+		server, requests := stubServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+		if _, err := fetchURL(server.URL+"/data.csv", t.TempDir(), 2, time.Millisecond); err == nil {
+			t.Errorf("fetchURL() didn't fail")
+		}
+		if *requests != 3 {
+			t.Errorf("fetchURL() made %d requests, want 3", *requests)
+		}
+	})
+
+	t.Run("Stalled downloads are retried", func(t *testing.T) {
+		actualTimeout := downloadTimeout
+		downloadTimeout = 50 * time.Millisecond
+		defer func() { downloadTimeout = actualTimeout }()
+
+		// The first response never comes, and the second one stops in the middle of its body
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			switch requests {
+			case 1:
+				<-r.Context().Done()
+			case 2:
+				w.Write([]byte("a,b\n"))
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			default:
+				w.Write([]byte("a,b\n1,2\n"))
+			}
+		}))
+		defer server.Close()
+
+		local, err := fetchURL(server.URL+"/data.csv", t.TempDir(), 2, time.Millisecond)
+		if err != nil {
+			t.Fatalf("fetchURL() error = %v", err)
+		}
+		if requests != 3 {
+			t.Errorf("fetchURL() made %d requests, want 3", requests)
+		}
+		content, err := ioutil.ReadFile(local)
+		check(err)
+		if string(content) != "a,b\n1,2\n" {
+			t.Errorf("fetchURL() downloaded %q", content)
+		}
+	})
+
+	t.Run("Client errors are not retried", func(t *testing.T) {
+		server, requests := stubServer(t, http.StatusNotFound)
+
+		if _, err := fetchURL(server.URL+"/data.csv", t.TempDir(), 2, time.Millisecond); err == nil {
+			t.Errorf("fetchURL() didn't fail")
+		}
+		if *requests != 1 {
+			t.Errorf("fetchURL() made %d requests, want 1", *requests)
+		}
+	})
+}
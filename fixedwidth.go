@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parses a list of column widths written as 10,8,25
+func parseWidths(list string) ([]int, error) {
+	var widths []int
+	for _, value := range splitColumnList(list, ",") {
+		width, err := strconv.Atoi(value)
+		if err != nil || width < 1 {
+			return nil, fmt.Errorf("Width %s must be a number bigger than 0", value)
+		}
+		widths = append(widths, width)
+	}
+
+	return widths, nil
+}
+
+// Parses a spec file of a fixed-width file, where each line has the name of a column followed by its width,
+// like "account number 10". Blank lines and lines starting with # are ignored
+func parseWidthSpec(content string) ([]string, []int, error) {
+	var names []string
+	var widths []int
+	for n, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		separatorIndex := strings.LastIndexAny(line, " \t")
+		if separatorIndex < 0 {
+			return nil, nil, fmt.Errorf("Line %d of the spec must have the name of a column and its width", n+1)
+		}
+		width, err := strconv.Atoi(line[separatorIndex+1:])
+		if err != nil || width < 1 {
+			return nil, nil, fmt.Errorf("Line %d of the spec has the width %s, which must be a number bigger than 0", n+1, line[separatorIndex+1:])
+		}
+
+		names = append(names, strings.TrimSpace(line[:separatorIndex]))
+		widths = append(widths, width)
+	}
+
+	if len(widths) == 0 {
+		return nil, nil, fmt.Errorf("The spec has no columns")
+	}
+
+	return names, widths, nil
+}
+
+// Reads the records of a fixed-width file, for --fixed-widths. Every line is cut at the widths of the columns,
+// counted in runes, or in bytes with --fixed-width-unit=byte, and the padding around each value is trimmed.
+// A line ending before the last column has fewer fields, and text after the last column is one more field,
+// so both are handled like the lines of a CSV file without as many fields as the headers
+type fixedWidthReader struct {
+	r               *bufio.Reader
+	widths          []int
+	runes           bool
+	fieldsPerRecord int
+	line            int // Number of lines read
+	record          []string
+	positions       []fieldPosition
+}
+
+func (f *fixedWidthReader) Read() ([]string, error) {
+	// Empty lines are skipped, like encoding/csv does
+	var line string
+	for line == "" {
+		text, err := f.r.ReadString('\n')
+		if err != nil && (err != io.EOF || text == "") {
+			return nil, err
+		}
+		f.line++
+		line = strings.TrimRight(text, "\r\n")
+	}
+
+	f.record, f.positions = f.record[:0], f.positions[:0]
+	pos := 0
+	for _, width := range f.widths {
+		if pos >= len(line) {
+			break
+		}
+
+		end := pos + width
+		if f.runes {
+			end = pos
+			for n := 0; n < width && end < len(line); n++ {
+				_, size := utf8.DecodeRuneInString(line[end:])
+				end += size
+			}
+		}
+		if end > len(line) {
+			end = len(line)
+		}
+
+		f.positions = append(f.positions, fieldPosition{f.line, pos + 1})
+		f.record = append(f.record, strings.TrimSpace(line[pos:end]))
+		pos = end
+	}
+	if rest := strings.TrimSpace(line[pos:]); rest != "" {
+		f.positions = append(f.positions, fieldPosition{f.line, pos + 1})
+		f.record = append(f.record, rest)
+	}
+
+	if f.fieldsPerRecord > 0 && len(f.record) != f.fieldsPerRecord {
+		return f.record, &csv.ParseError{StartLine: f.line, Line: f.line, Column: 1, Err: csv.ErrFieldCount}
+	}
+
+	return f.record, nil
+}
+
+// Returns the line and the column, in bytes, where a field of the last record starts
+func (f *fixedWidthReader) FieldPos(field int) (line, column int) {
+	if field < 0 || field >= len(f.positions) {
+		panic("out of range index passed to FieldPos")
+	}
+
+	return f.positions[field].line, f.positions[field].column
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseWidthSpec(t *testing.T) {
+	names, widths, err := parseWidthSpec("# Bank export\naccount 10\n\nholder name\t25\r\namount 12\n")
+	check(err)
+	if !reflect.DeepEqual(names, []string{"account", "holder name", "amount"}) || !reflect.DeepEqual(widths, []int{10, 25, 12}) {
+		t.Errorf("parseWidthSpec() = %q, %v", names, widths)
+	}
+
+	for _, spec := range []string{"", "account\n", "account ten\n", "account -1\n"} {
+		if _, _, err := parseWidthSpec(spec); err == nil {
+			t.Errorf("parseWidthSpec(%q) should fail", spec)
+		}
+	}
+}
+
+func Test_fixedWidthReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		runes   bool
+		want    [][]string
+		wantErr error
+	}{
+		{"Padding trimmed", "1    Ann   10.5\n22   Bob     -3\n", true, [][]string{{"1", "Ann", "10.5"}, {"22", "Bob", "-3"}}, nil},
+		{"Runes", "1    Zoë   10\n", true, [][]string{{"1", "Zoë", "10"}}, nil},
+		{"Bytes", "1    Zoë  10\n", false, [][]string{{"1", "Zoë", "10"}}, nil},
+		{"Empty lines and CRLF", "1    Ann   10\r\n\r\n2    Bob   20", true, [][]string{{"1", "Ann", "10"}, {"2", "Bob", "20"}}, nil},
+		{"Short line", "1    Ann\n", true, [][]string{{"1", "Ann"}}, nil},
+		{"Text after the last column", "1    Ann   10  extra\n", true, [][]string{{"1", "Ann", "10", "extra"}}, nil},
+		{"Short line with the ragged policy", "1    Ann\n", true, nil, csv.ErrFieldCount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := &fixedWidthReader{r: bufio.NewReader(strings.NewReader(tt.data)), widths: []int{5, 6, 4}, runes: tt.runes, fieldsPerRecord: -1}
+			if tt.wantErr != nil {
+				reader.fieldsPerRecord = 3
+			}
+
+			var got [][]string
+			var err error
+			for {
+				var line []string
+				line, err = reader.Read()
+				if err != nil {
+					break
+				}
+				got = append(got, append([]string(nil), line...))
+			}
+
+			if tt.wantErr == nil && err != io.EOF || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("records = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fixedWidthFile(t *testing.T) {
+	given := []string{"id", "name"}
+
+	tests := []struct {
+		name    string
+		data    string
+		headers []string
+		ragged  string
+		want    string
+	}{
+		{"Headers in the file", "id   name  \n1    Ann   \n2    Bob   \n", nil, "error", `[{"id":"1","name":"Ann"},{"id":"2","name":"Bob"}]` + "\n"},
+		{"Given headers", "1    Ann   \n2    Bob   \n", given, "error", `[{"id":"1","name":"Ann"},{"id":"2","name":"Bob"}]` + "\n"},
+		{"Short lines padded", "1    Ann   \n2\n", given, "pad", `[{"id":"1","name":"Ann"},{"id":"2","name":""}]` + "\n"},
+		{"Short lines skipped", "1    Ann   \n2\n", given, "skip", `[{"id":"1","name":"Ann"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "data.txt")
+			check(ioutil.WriteFile(path, []byte(tt.data), 0666))
+
+			var buf bytes.Buffer
+			fileData := withOptions(func(f *inputFile) {
+				f.filepath, f.fixedWidths, f.givenHeaders, f.ragged = path, []int{5, 6}, tt.headers, tt.ragged
+			})
+			check(convertTo(fileData, &buf))
+			if buf.String() != tt.want {
+				t.Errorf("output = %s, want %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
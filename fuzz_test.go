@@ -0,0 +1,40 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// The separators the fuzzed files are read with, picked by the fuzzer through an index
+var fuzzSeparators = []string{",", ";", "\t", "||"}
+
+// Converts arbitrary bytes as a CSV file, and checks that whatever convertReader writes is valid JSON, or that it
+// fails with an error instead of writing half an output. Run with go test -fuzz=Fuzz_convertReader
+func Fuzz_convertReader(f *testing.F) {
+	f.Add([]byte("id,name\n1,a\n2,b\n"), uint8(0), false)
+	f.Add([]byte("id;name\r\n1;\"a;\"\"b\"\"\"\r\n"), uint8(1), true)
+	f.Add([]byte("\xEF\xBB\xBFid\tname\n1\t\"a\nb\"\n"), uint8(2), false)
+	f.Add([]byte("id||name\n1||\"a||b\"\n\"2\"||\xff\n"), uint8(3), true)
+	f.Add([]byte("sep=;\nid;name\n1;a;extra\n2\n"), uint8(0), false)
+	f.Add([]byte("a,\"b\n"), uint8(0), false)
+
+	f.Fuzz(func(t *testing.T, data []byte, separatorIndex uint8, pretty bool) {
+		separator := fuzzSeparators[int(separatorIndex)%len(fuzzSeparators)]
+		fileData := withOptions(func(f *inputFile) {
+			f.filepath, f.separator, f.separatorGiven, f.pretty, f.allowEmpty = "fuzz.csv", separator, true, pretty, true
+		})
+
+		var buf bytes.Buffer
+		if err := convertReader(context.Background(), fileData, bytes.NewReader(data), &buf); err != nil {
+			return
+		}
+		if !json.Valid(buf.Bytes()) {
+			t.Fatalf("convertReader() of %q wrote invalid JSON %s", data, buf.Bytes())
+		}
+	})
+}
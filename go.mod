@@ -1,3 +1,8 @@
 module github.com/FaizBShah/csv-to-json-cli
 
 go 1.17
+
+require (
+	go.uber.org/goleak v1.1.12
+	golang.org/x/text v0.13.0
+)
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Run go test -run Test_golden -update to write the golden files again, after checking the new output is right
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files of testdata with the current output")
+
+func Test_golden(t *testing.T) {
+	// The CSV files of testdata, along with the options they need to be converted
+	fixtures := []struct {
+		name    string
+		options func(f *inputFile)
+	}{
+		{"quotes", func(f *inputFile) {}},
+		{"newlines", func(f *inputFile) {}},
+		{"semicolons", func(f *inputFile) {}},
+		{"bom", func(f *inputFile) {}},
+		{"ragged", func(f *inputFile) { f.ragged = "pad" }},
+		{"unicode", func(f *inputFile) {}},
+	}
+	formats := []struct {
+		name    string
+		options func(f *inputFile)
+	}{
+		{"compact", func(f *inputFile) {}},
+		{"pretty", func(f *inputFile) { f.pretty = true }},
+		{"aligned", func(f *inputFile) { f.pretty, f.align = true, true }},
+		{"record-per-line", func(f *inputFile) { f.recordPerLine = true }},
+	}
+
+	for _, fixture := range fixtures {
+		for _, format := range formats {
+			fixture, format := fixture, format
+			t.Run(fixture.name+"/"+format.name, func(t *testing.T) {
+				fileData := withOptions(func(f *inputFile) {
+					f.filepath = filepath.Join("testdata", fixture.name+".csv")
+					fixture.options(f)
+					format.options(f)
+				})
+
+				var buf bytes.Buffer
+				check(convertTo(fileData, &buf))
+
+				goldenPath := filepath.Join("testdata", fixture.name+"."+format.name+".json")
+				if *updateGolden {
+					check(ioutil.WriteFile(goldenPath, buf.Bytes(), 0666))
+				}
+				want, err := ioutil.ReadFile(goldenPath)
+				if err != nil {
+					t.Fatalf("golden file can't be read, run the test with -update to write it: %v", err)
+				}
+				if !bytes.Equal(buf.Bytes(), want) {
+					t.Errorf("output = %q, want %q", buf.Bytes(), want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+// The levels are sorted by severity, so a logger shows every message at or above its own level
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// ANSI colors of the text messages, when they are written to a terminal
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// Errors and warnings stand out, the other levels keep the color of the terminal
+var levelColors = map[logLevel]string{levelWarn: colorYellow, levelError: colorRed}
+
+func (level logLevel) String() string {
+	return logLevelNames[level]
+}
+
+func parseLogLevel(name string) (logLevel, error) {
+	for i, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return logLevel(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unknown log level %s. Use debug, info, warn or error", name)
+}
+
+// Writes leveled messages, either as "level: message" lines or as one JSON object per line.
+// The reader and writer go-routines log at the same time, so every write is protected by a mutex
+type leveledLogger struct {
+	mu         sync.Mutex
+	out        io.Writer
+	level      logLevel
+	jsonFormat bool
+	color      bool // The text messages are colored by their level
+}
+
+// The logger used by the whole program. main configures it from the --log-level and --log-format options
+var logger = &leveledLogger{out: os.Stderr, level: levelInfo}
+
+func (l *leveledLogger) configure(level logLevel, format string, color bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.level = level
+	l.jsonFormat = format == "json"
+	l.color = color
+}
+
+// Validates if the messages written into out can be colored: out must be a terminal, and neither --no-color
+// nor the NO_COLOR environment variable may say otherwise
+func useColor(noColor bool, out *os.File) bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(out)
+}
+
+func (l *leveledLogger) logf(level logLevel, format string, args ...interface{}) {
+	l.write(level, levelColors[level], format, args...)
+}
+
+func (l *leveledLogger) write(level logLevel, color string, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if level < l.level {
+		return
+	}
+
+	message := fmt.Sprintf(format, args...)
+
+	if l.jsonFormat {
+		entry, _ := json.Marshal(struct {
+			Time    string `json:"time"`
+			Level   string `json:"level"`
+			Message string `json:"msg"`
+		}{time.Now().Format(time.RFC3339), level.String(), message})
+		fmt.Fprintf(l.out, "%s\n", entry)
+	} else if l.color && color != "" {
+		fmt.Fprintf(l.out, "%s%s: %s%s\n", color, level, message, colorReset)
+	} else {
+		fmt.Fprintf(l.out, "%s: %s\n", level, message)
+	}
+}
+
+func (l *leveledLogger) debugf(format string, args ...interface{}) {
+	l.logf(levelDebug, format, args...)
+}
+
+func (l *leveledLogger) infof(format string, args ...interface{}) {
+	l.logf(levelInfo, format, args...)
+}
+
+// Logs the outcome of the conversion at info level, in green on a terminal
+func (l *leveledLogger) summaryf(format string, args ...interface{}) {
+	l.write(levelInfo, colorGreen, format, args...)
+}
+
+func (l *leveledLogger) warnf(format string, args ...interface{}) {
+	l.logf(levelWarn, format, args...)
+}
+
+func (l *leveledLogger) errorf(format string, args ...interface{}) {
+	l.logf(levelError, format, args...)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_leveledLogger(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  logLevel
+		format string
+		want   string
+	}{
+		{"Debug level shows everything", levelDebug, "text", "debug: reading\ninfo: writing\nwarn: skipping\nerror: failed\n"},
+		{"Info level suppresses debug logs", levelInfo, "text", "info: writing\nwarn: skipping\nerror: failed\n"},
+		{"Error level only shows errors", levelError, "text", "error: failed\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			l := &leveledLogger{out: &out}
+			l.configure(tt.level, tt.format, false)
+
+			l.debugf("reading")
+			l.infof("writing")
+			l.warnf("skipping")
+			l.errorf("failed")
+
+			if got := out.String(); got != tt.want {
+				t.Errorf("leveledLogger output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_leveledLoggerJSON(t *testing.T) {
+	var out bytes.Buffer
+	l := &leveledLogger{out: &out}
+	l.configure(levelInfo, "json", true)
+
+	l.debugf("reading %d", 1)
+	l.warnf("skipping line %d", 2)
+
+	// Only the warning should be there, as a single JSON object
+	var entry map[string]string
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("leveledLogger output %q is not a JSON object: %v", out.String(), err)
+	}
+	if entry["level"] != "warn" || entry["msg"] != "skipping line 2" || entry["time"] == "" {
+		t.Errorf("leveledLogger output = %v, want a warn entry with the message and time", entry)
+	}
+}
+
+func Test_leveledLoggerColor(t *testing.T) {
+	var out bytes.Buffer
+	l := &leveledLogger{out: &out}
+	l.configure(levelInfo, "text", true)
+
+	l.infof("writing")
+	l.warnf("skipping")
+	l.errorf("failed")
+	l.summaryf("done")
+
+	want := "info: writing\n\x1b[33mwarn: skipping\x1b[0m\n\x1b[31merror: failed\x1b[0m\n\x1b[32minfo: done\x1b[0m\n"
+	if got := out.String(); got != want {
+		t.Errorf("leveledLogger output = %q, want %q", got, want)
+	}
+}
+
+func Test_useColor(t *testing.T) {
+	// The output of the tests is not a terminal
+	f, err := ioutil.TempFile(t.TempDir(), "log")
+	check(err)
+	defer f.Close()
+	if useColor(false, f) {
+		t.Errorf("useColor() = true for a file that's not a terminal")
+	}
+}
+
+func Test_parseLogLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    logLevel
+		wantErr bool
+	}{
+		{"debug", levelDebug, false},
+		{"INFO", levelInfo, false},
+		{"warn", levelWarn, false},
+		{"error", levelError, false},
+		{"verbose", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLogLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseLogLevel() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseLogLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
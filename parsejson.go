@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Default deepest nesting of the objects and arrays of a JSON value of --parse-json
+const defaultParseJSONDepth = 32
+
+// Default size of the biggest JSON value of --parse-json, 1 MiB
+const defaultParseJSONBytes = 1 << 20
+
+// Validates that a value is a single JSON value, with its objects and arrays nested at most maxDepth levels deep,
+// and at most maxBytes long. The value is only read as tokens, so a deep or big value from an untrusted file is
+// rejected before anything is built out of it. A limit of 0 means no limit
+func checkEmbeddedJSON(value string, maxDepth int, maxBytes int) error {
+	if maxBytes > 0 && len(value) > maxBytes {
+		return fmt.Errorf("is longer than %d bytes", maxBytes)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	for depth := 0; ; {
+		token, err := decoder.Token()
+		if err != nil {
+			return errors.New("is not valid JSON")
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			if depth++; maxDepth > 0 && depth > maxDepth {
+				return fmt.Errorf("is nested more than %d levels deep", maxDepth)
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("is not valid JSON")
+	}
+
+	return nil
+}
+
+// Returns the function checking the JSON values of a column of --parse-json, with the same policies as the
+// decoded columns: a value that's not valid JSON is kept like it is with invalid keep, which the json type then
+// writes as a string, null with null, and an error with error, which skips its line.
+// Empty values are null with every policy
+func newJSONDecoder(index int, column string, fileData inputFile) columnDecoder {
+	apply := func(value string) (string, bool, error) {
+		if value == "" || fileData.parseJSONInvalid == "keep" {
+			return value, false, nil
+		}
+		err := checkEmbeddedJSON(value, fileData.parseJSONDepth, fileData.parseJSONBytes)
+		if err == nil {
+			return value, false, nil
+		}
+		if fileData.parseJSONInvalid == "null" {
+			return "", true, nil
+		}
+		return "", false, fmt.Errorf("Value of column %s %v. Skipping", column, err)
+	}
+
+	return columnDecoder{index, column, apply}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_checkEmbeddedJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		maxDepth int
+		maxBytes int
+		wantErr  bool
+	}{
+		{"Object", `{"a":[1,{"b":null}],"c":"d"}`, 3, 0, false},
+		{"Array", `[1, 2, 3]`, 1, 0, false},
+		{"Scalar", `12345678901234567890`, 1, 0, false},
+		{"String", `"text"`, 1, 0, false},
+		{"Text", `text`, 1, 0, true},
+		{"Unclosed", `{"a":1`, 0, 0, true},
+		{"Missing colon", `{"a" 1}`, 0, 0, true},
+		{"Trailing value", `{} {}`, 0, 0, true},
+		{"Too deep", `[[[1]]]`, 2, 0, true},
+		{"Deep without limit", strings.Repeat("[", 100) + strings.Repeat("]", 100), 0, 0, false},
+		{"Too big", `[1,2,3]`, 0, 6, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkEmbeddedJSON(tt.value, tt.maxDepth, tt.maxBytes); (err != nil) != tt.wantErr {
+				t.Errorf("checkEmbeddedJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseJSON(t *testing.T) {
+	csvString := "id,meta\n1,\"{\"\"b\"\":[1,2],\"\"a\"\":\"\"<x>\"\"}\"\n2,not json\n3,\n"
+	tests := []struct {
+		name    string
+		invalid string
+		pretty  bool
+		want    string
+	}{
+		{"Invalid skipped", "error", false, `[{"id":"1","meta":{"b":[1,2],"a":"\u003cx\u003e"}},{"id":"3","meta":null}]`},
+		{"Invalid kept", "keep", false, `[{"id":"1","meta":{"b":[1,2],"a":"\u003cx\u003e"}},{"id":"2","meta":"not json"},{"id":"3","meta":null}]`},
+		{"Invalid null", "null", false, `[{"id":"1","meta":{"b":[1,2],"a":"\u003cx\u003e"}},{"id":"2","meta":null},{"id":"3","meta":null}]`},
+		{"Pretty", "error", true, "[\n   {\n      \"id\": \"1\",\n      \"meta\": {\n         \"b\": [\n            1,\n            2\n         ],\n         \"a\": \"\\u003cx\\u003e\"\n      }\n   },\n   {\n      \"id\": \"3\",\n      \"meta\": null\n   }\n]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convertString(t, csvString, withOptions(func(f *inputFile) {
+				f.pretty, f.parseJSON, f.parseJSONInvalid = tt.pretty, []string{"meta"}, tt.invalid
+				f.typeMap = map[string]valueType{"meta": typeJSON}
+			}))
+			if got = strings.TrimSuffix(got, "\n"); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Number of distinct values remembered per column by default. Past it, the column only reports that it has at least that many
+const defaultProfileDistinctCap = 10000
+
+// The statistics --profile gathers about one column
+type columnProfile struct {
+	nonEmpty  int64
+	distinct  map[string]struct{}
+	overflow  bool // More distinct values than the cap were found
+	minLength int
+	maxLength int
+	notNumber bool // Some non-empty value is not a number
+	numbers   int64
+	min       float64
+	max       float64
+	sum       float64
+}
+
+// Gathers statistics about the columns of the records while they are converted
+type fileProfile struct {
+	keys        []string
+	columns     []columnProfile
+	rows        int64
+	distinctCap int
+}
+
+func newFileProfile(keys []string, distinctCap int) *fileProfile {
+	columns := make([]columnProfile, len(keys))
+	for i := range columns {
+		columns[i].distinct = make(map[string]struct{})
+	}
+
+	return &fileProfile{keys: keys, columns: columns, distinctCap: distinctCap}
+}
+
+// Adds the values of a record, given in the same order as the keys
+func (p *fileProfile) add(values []string) {
+	for i, value := range values {
+		column := &p.columns[i]
+
+		length := utf8.RuneCountInString(value)
+		if p.rows == 0 || length < column.minLength {
+			column.minLength = length
+		}
+		if length > column.maxLength {
+			column.maxLength = length
+		}
+
+		if !column.overflow {
+			if _, seen := column.distinct[value]; !seen {
+				if len(column.distinct) == p.distinctCap {
+					// There's no need to remember the values anymore, so the memory is released
+					column.overflow, column.distinct = true, nil
+				} else {
+					// Values share their memory with the rest of the line, so a copy is kept instead
+					column.distinct[string([]byte(value))] = struct{}{}
+				}
+			}
+		}
+
+		if value == "" {
+			continue
+		}
+		column.nonEmpty++
+
+		if column.notNumber {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			column.notNumber = true
+			continue
+		}
+		if column.numbers == 0 || number < column.min {
+			column.min = number
+		}
+		if column.numbers == 0 || number > column.max {
+			column.max = number
+		}
+		column.numbers++
+		column.sum += number
+	}
+	p.rows++
+}
+
+type numericReport struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+type columnReport struct {
+	Name      string         `json:"name"`
+	NonEmpty  int64          `json:"non_empty"`
+	Distinct  interface{}    `json:"distinct"` // A number, or "≥cap" when there were too many to count
+	MinLength int            `json:"min_length"`
+	MaxLength int            `json:"max_length"`
+	Numeric   *numericReport `json:"numeric,omitempty"` // Only for columns where every non-empty value is a number
+}
+
+type profileReport struct {
+	Rows    int64          `json:"rows"`
+	Columns []columnReport `json:"columns"`
+}
+
+func (p *fileProfile) report() profileReport {
+	report := profileReport{Rows: p.rows, Columns: make([]columnReport, len(p.keys))}
+	for i, column := range p.columns {
+		var distinct interface{} = len(column.distinct)
+		if column.overflow {
+			distinct = fmt.Sprintf("≥%d", p.distinctCap)
+		}
+
+		report.Columns[i] = columnReport{
+			Name:      p.keys[i],
+			NonEmpty:  column.nonEmpty,
+			Distinct:  distinct,
+			MinLength: column.minLength,
+			MaxLength: column.maxLength,
+		}
+		if !column.notNumber && column.numbers > 0 {
+			report.Columns[i].Numeric = &numericReport{column.min, column.max, column.sum / float64(column.numbers)}
+		}
+	}
+
+	return report
+}
+
+// Writes the statistics next to the JSON file, as <file>.profile.json, indented and escaped like the JSON file
+func (p *fileProfile) write(jsonPath string, indent string, escaping jsonEscaping, mode fileMode) error {
+	var content bytes.Buffer
+	if err := json.Indent(&content, escaping.appendEncoded(nil, p.report()), "", indent); err != nil {
+		return err
+	}
+
+	profilePath := jsonPath + ".profile.json"
+	if err := mode.writeFile(profilePath, append(content.Bytes(), '\n')); err != nil {
+		return err
+	}
+
+	logger.infof("Column statistics written to %s", profilePath)
+
+	return nil
+}
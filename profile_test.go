@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_fileProfile(t *testing.T) {
+	profile := newFileProfile([]string{"id", "price", "city"}, 2)
+	for _, values := range [][]string{
+		{"1", "2.5", "Paris"},
+		{"2", "", "Oslo"},
+		{"3", "-1.5", "Paris"},
+		{"4", "n/a", "Rome"},
+	} {
+		profile.add(values)
+	}
+
+	want := profileReport{Rows: 4, Columns: []columnReport{
+		{Name: "id", NonEmpty: 4, Distinct: "≥2", MinLength: 1, MaxLength: 1, Numeric: &numericReport{1, 4, 2.5}},
+		{Name: "price", NonEmpty: 3, Distinct: "≥2", MinLength: 0, MaxLength: 4},
+		{Name: "city", NonEmpty: 4, Distinct: "≥2", MinLength: 4, MaxLength: 5},
+	}}
+	if got := profile.report(); !reflect.DeepEqual(got, want) {
+		t.Errorf("report() = %+v, want %+v", got, want)
+	}
+
+	// Under the cap, distinct values are counted exactly
+	profile = newFileProfile([]string{"city"}, 10)
+	for _, city := range []string{"Paris", "Oslo", "Paris"} {
+		profile.add([]string{city})
+	}
+	if got := profile.report().Columns[0]; got.Distinct != 2 || got.Numeric != nil {
+		t.Errorf("report() = %+v, want 2 distinct values and no numeric statistics", got)
+	}
+}
+
+func Test_profileFile(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id,name\n1,a\n2,b\n"), 0666))
+
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.profile, f.columns = csvPath, true, []string{"id"} }))
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "data.json.profile.json"))
+	check(err)
+	want := `{
+   "rows": 2,
+   "columns": [
+      {
+         "name": "id",
+         "non_empty": 2,
+         "distinct": 2,
+         "min_length": 1,
+         "max_length": 1,
+         "numeric": {
+            "min": 1,
+            "max": 2,
+            "mean": 1.5
+         }
+      }
+   ]
+}
+`
+	if string(got) != want {
+		t.Errorf("profile = %s, want %s", got, want)
+	}
+}
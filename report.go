@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"sync"
+	"time"
+)
+
+// Number of skipped lines listed by each entry of --report. The rest are only counted
+const maxReportedLines = 100
+
+// The lines of a file skipped because they don't match the headers. processCsvFile adds them while the
+// program may be exiting on an error, which reports them too, so they are protected by a mutex
+type skippedLines struct {
+	sync.Mutex
+	count int64
+	first []int
+}
+
+func (s *skippedLines) add(line int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.count++
+	if len(s.first) < maxReportedLines {
+		s.first = append(s.first, line)
+	}
+}
+
+// What --report writes about the conversion of a file
+type fileReport struct {
+	Input        string            `json:"input"`
+	Output       string            `json:"output"`
+	Rows         int64             `json:"rows"`
+	Skipped      int64             `json:"skipped"`
+	SkippedLines []int             `json:"skipped_lines"`
+	DurationMs   int64             `json:"duration_ms"`
+	StartedAt    string            `json:"started_at"`
+	Options      map[string]string `json:"options"`
+	Status       string            `json:"status"` // converted, failed, or why it was not converted
+	Error        string            `json:"error,omitempty"`
+
+	started time.Time
+	skipped *skippedLines
+}
+
+// The entries of --report, one for every file. A single file gets an object instead of an array of one entry
+type conversionReport struct {
+	files   []fileReport
+	batch   bool
+	options map[string]string
+}
+
+// Creates the report of the files of a run. The options are the values of every option, given or not
+func newConversionReport(fileData inputFile) *conversionReport {
+	options := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !isShortFlag(f.Name) {
+			options[f.Name] = f.Value.String()
+		}
+	})
+
+	return &conversionReport{batch: len(fileData.filepaths) > 1 || fileData.filesFromStdin, options: options}
+}
+
+// Adds the entry of the file about to be converted, returning where it counts the lines skipped
+func (r *conversionReport) start(fileData inputFile) *skippedLines {
+	now := time.Now()
+	skipped := &skippedLines{}
+	r.files = append(r.files, fileReport{
+		Input:        fileData.filepath,
+		Output:       outputLocation(fileData, fileData.outputSuffix),
+		SkippedLines: []int{},
+		StartedAt:    now.Format(time.RFC3339),
+		Options:      r.options,
+		started:      now,
+		skipped:      skipped,
+	})
+	return skipped
+}
+
+// Completes the entry of the last file started, with the error that stopped it if there's one
+func (r *conversionReport) finish(rows int64, err error) {
+	if len(r.files) == 0 {
+		return
+	}
+
+	file := &r.files[len(r.files)-1]
+	file.Rows = rows
+	file.DurationMs = time.Since(file.started).Milliseconds()
+	file.Status = "converted"
+	if err != nil {
+		file.Status, file.Error = "failed", err.Error()
+	}
+
+	file.skipped.Lock()
+	defer file.skipped.Unlock()
+	file.Skipped = file.skipped.count
+	file.SkippedLines = append(file.SkippedLines[:0], file.skipped.first...)
+}
+
+// Completes the entry of the last file started, which was not converted for the given reason, like "up to date"
+func (r *conversionReport) skip(reason string) {
+	r.finish(0, nil)
+	if len(r.files) > 0 {
+		r.files[len(r.files)-1].Status = reason
+	}
+}
+
+// Writes the report into the file given by --report, indented like the JSON files
+func (r *conversionReport) write(fileData inputFile) error {
+	var content []byte
+	var err error
+	if r.batch || len(r.files) != 1 {
+		content, err = json.MarshalIndent(r.files, "", fileData.indent)
+	} else {
+		content, err = json.MarshalIndent(r.files[0], "", fileData.indent)
+	}
+	if err != nil {
+		return err
+	}
+
+	return fileData.outputMode.writeFile(fileData.report, append(content, '\n'))
+}
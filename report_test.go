@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_conversionReport(t *testing.T) {
+	tests := []struct {
+		name      string
+		filepaths []string
+		wantArray bool
+	}{
+		{"Single file is an object", []string{"a.csv"}, false},
+		{"Batch is an array", []string{"a.csv", "b.csv"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reportPath := filepath.Join(t.TempDir(), "report.json")
+			fileData := withOptions(func(f *inputFile) { f.filepaths, f.report = tt.filepaths, reportPath })
+			report := newConversionReport(fileData)
+
+			for _, path := range tt.filepaths {
+				fileData.filepath = path
+				skipped := report.start(fileData)
+				for line := 2; line < 2+maxReportedLines+10; line++ {
+					skipped.add(line)
+				}
+				report.finish(3, errors.New("record on line 200: wrong number of fields"))
+			}
+			check(report.write(fileData))
+
+			content, err := ioutil.ReadFile(reportPath)
+			check(err)
+			var entries []map[string]interface{}
+			if tt.wantArray {
+				err = json.Unmarshal(content, &entries)
+			} else {
+				var entry map[string]interface{}
+				err = json.Unmarshal(content, &entry)
+				entries = append(entries, entry)
+			}
+			if err != nil {
+				t.Fatalf("report %s is not the JSON wanted: %v", content, err)
+			}
+			if len(entries) != len(tt.filepaths) {
+				t.Fatalf("report has %d entries, want %d", len(entries), len(tt.filepaths))
+			}
+
+			got := entries[0]
+			if got["input"] != "a.csv" || got["output"] != "a.json" || got["rows"] != 3.0 || got["skipped"] != float64(maxReportedLines+10) {
+				t.Errorf("report entry = %v, want the input, output, rows and skipped lines of a.csv", got)
+			}
+			if lines := got["skipped_lines"].([]interface{}); len(lines) != maxReportedLines || lines[0] != 2.0 {
+				t.Errorf("report entry lists %d skipped lines starting with %v, want %d starting with 2", len(lines), lines[0], maxReportedLines)
+			}
+			if got["status"] != "failed" || got["error"] != "record on line 200: wrong number of fields" || got["started_at"] == "" {
+				t.Errorf("report entry = %v, want the error and the start", got)
+			}
+			if options := got["options"].(map[string]interface{}); len(options) == 0 {
+				t.Errorf("report entry has no options")
+			}
+		})
+	}
+}
+
+func Test_reportSkippedLines(t *testing.T) {
+	skipped := &skippedLines{}
+	fileData := withOptions(func(f *inputFile) { f.ragged, f.skipped = "skip", skipped })
+	readRecords(t, "id,name\n1,a\n2\n3,c\n4,d,e\n", fileData)
+
+	if skipped.count != 2 || !reflect.DeepEqual(skipped.first, []int{3, 5}) {
+		t.Errorf("skipped %d lines %v, want 2 lines [3 5]", skipped.count, skipped.first)
+	}
+}
+
+func Test_reportSkippedFiles(t *testing.T) {
+	fileData := withOptions(func(f *inputFile) { f.filepaths = []string{"a.csv", "b.csv", "c.csv"} })
+	report := newConversionReport(fileData)
+	for _, path := range fileData.filepaths {
+		fileData.filepath = path
+		report.start(fileData)
+		switch path {
+		case "a.csv":
+			report.finish(2, nil)
+		case "b.csv":
+			report.skip("up to date")
+		case "c.csv":
+			report.skip("declined")
+		}
+	}
+
+	var statuses []string
+	for _, file := range report.files {
+		statuses = append(statuses, file.Status)
+	}
+	if want := []string{"converted", "up to date", "declined"}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("report statuses = %v, want %v", statuses, want)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A value of a JSON record, as it's written in the CSV file
+type csvCell struct {
+	text   string
+	number bool
+	null   bool // A null or a missing key, which are written as --null-value or as an empty field
+}
+
+// Writes the records of --reverse. The encoding/csv writer only quotes the fields that need it, so the quotes
+// are added here instead, following the same rules for --quote-mode=minimal
+type csvRecordWriter struct {
+	w          *bufio.Writer
+	separator  string
+	mode       string
+	lineEnding string
+}
+
+// Validates if a field has to be quoted to be read back as the same value, which are the same rules as encoding/csv
+func (c *csvRecordWriter) needsQuotes(field string) bool {
+	if field == "" {
+		return false
+	}
+	if field == `\.` || strings.Contains(field, c.separator) || strings.ContainsAny(field, "\"\r\n") {
+		return true
+	}
+
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
+
+// With --quote-mode=all every field is quoted, and with nonnumeric every field but numbers and nulls,
+// so an empty string and a null can be told apart
+func (c *csvRecordWriter) quoted(cell csvCell) bool {
+	switch c.mode {
+	case "all":
+		return true
+	case "nonnumeric":
+		return !cell.number && !cell.null
+	}
+
+	return c.needsQuotes(cell.text)
+}
+
+func (c *csvRecordWriter) write(cells []csvCell) error {
+	for i, cell := range cells {
+		if i > 0 {
+			c.w.WriteString(c.separator)
+		}
+
+		if !c.quoted(cell) {
+			c.w.WriteString(cell.text)
+			continue
+		}
+		c.w.WriteByte('"')
+		c.w.WriteString(strings.ReplaceAll(cell.text, `"`, `""`))
+		c.w.WriteByte('"')
+	}
+
+	_, err := c.w.WriteString(c.lineEnding)
+	return err
+}
+
+// Reads the next value of an object, turning it into a cell. Objects and arrays are written as their JSON
+func readCell(dec *json.Decoder, fileData inputFile) (csvCell, error) {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return csvCell{}, err
+	}
+
+	switch raw[0] {
+	case '"':
+		var text string
+		err := json.Unmarshal(raw, &text)
+		return csvCell{text: text}, err
+	case 'n':
+		return nullCell(fileData), nil
+	case 't', 'f':
+		return csvCell{text: string(raw)}, nil
+	case '{', '[':
+		var compact bytes.Buffer
+		err := json.Compact(&compact, raw)
+		return csvCell{text: compact.String()}, err
+	}
+
+	return csvCell{text: string(raw), number: true}, nil
+}
+
+// The cell of a null value, or of a key the record doesn't have
+func nullCell(fileData inputFile) csvCell {
+	if fileData.nullValueGiven {
+		return csvCell{text: fileData.nullValue, null: true}
+	}
+	return csvCell{null: true}
+}
+
+// Validates that the next token of the JSON is the given delimiter
+func expectDelim(dec *json.Decoder, delim json.Delim, what string) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("Expected %s, found %v", what, token)
+	}
+
+	return nil
+}
+
+// Validates if the JSON is NDJSON, with an object per line, instead of an array of objects. Only the spaces
+// before the first value are read, so the decoder still gets the whole JSON
+func isNDJSON(r *bufio.Reader) (bool, error) {
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b == '{', r.UnreadByte()
+		}
+	}
+}
+
+// Converts an array of JSON objects, or NDJSON, into CSV. The objects are read one at a time, so the file is never
+// held in memory. The headers are the keys of the first object, in the order they are written, and a later object
+// can't add keys
+func reverseTo(ctx context.Context, fileData inputFile, r io.Reader, w *bufio.Writer) error {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	ndjson, err := isNDJSON(br)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(br)
+	if !ndjson {
+		if err := expectDelim(dec, '[', "an array of records"); err != nil {
+			return err
+		}
+	}
+
+	separatorData := fileData
+	separatorData.filepath = getJSONPath(fileData.filepath, fileData.outputSuffix) // A .tsv output is separated by tabs
+	separator := multiSeparator(fileData)
+	if separator == "" {
+		separator = string(fileSeparator(separatorData, 0, false))
+	}
+	writer := &csvRecordWriter{w: w, separator: separator, mode: fileData.quoteMode, lineEnding: fileData.lineEnding}
+
+	var headers []string
+	var cells []csvCell
+	positions := make(map[string]int)
+	for n := 1; dec.More(); n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := expectDelim(dec, '{', fmt.Sprintf("record %d to be an object", n)); err != nil {
+			return err
+		}
+
+		for i := range cells {
+			cells[i] = nullCell(fileData)
+		}
+		for dec.More() {
+			token, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := token.(string)
+			cell, err := readCell(dec, fileData)
+			if err != nil {
+				return err
+			}
+
+			position, known := positions[key]
+			if !known && n > 1 {
+				return fmt.Errorf("Record %d has the key %s, which the first record doesn't have", n, key)
+			}
+			if !known {
+				position = len(headers)
+				positions[key] = position
+				headers = append(headers, key)
+				cells = append(cells, csvCell{})
+			}
+			cells[position] = cell
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+
+		if n == 1 {
+			headerCells := make([]csvCell, len(headers))
+			for i, header := range headers {
+				headerCells[i] = csvCell{text: header}
+			}
+			if err := writer.write(headerCells); err != nil {
+				return err
+			}
+		}
+		if err := writer.write(cells); err != nil {
+			return err
+		}
+	}
+
+	// The array ends after its last record, while NDJSON just ends
+	if !ndjson {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// Converts a JSON or NDJSON file into its CSV file, for --reverse. When the conversion fails, the CSV file
+// is left like it was before it
+func reverseFile(ctx context.Context, fileData inputFile) error {
+	file, err := os.Open(fileData.filepath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	output, err := createOutput(fileData)
+	if err != nil {
+		return err
+	}
+
+	logger.infof("Writing CSV file...")
+	err = reverseTo(ctx, fileData, bufio.NewReaderSize(file, fileData.readBuffer), bufio.NewWriterSize(output, fileData.writeBuffer))
+	if errors.Is(err, context.Canceled) {
+		err = writeError(fileData, err)
+	} else if err != nil {
+		err = fmt.Errorf("File %s can't be converted: %v", fileData.filepath, err)
+	} else {
+		err = output.Close()
+	}
+	if err != nil {
+		discardOutput(output)
+		return err
+	}
+	logger.summaryf("Completed!")
+	return nil
+}
+
+// Validates that a file can be converted back into CSV
+func checkIfValidJSONFile(filename string, forceExtension bool) (bool, error) {
+	if extension := fileExtension(filename); !forceExtension && extension != ".json" && extension != ".ndjson" && extension != ".jsonl" {
+		return false, fmt.Errorf("File %s is not JSON. Use --force-extension to convert it anyway", filename)
+	}
+
+	return checkIfReadable(filename)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Converts the given JSON back into CSV, returning what was written
+func reverseString(t *testing.T, fileData inputFile, jsonData string) (string, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	err := reverseTo(context.Background(), fileData, strings.NewReader(jsonData), w)
+	return buf.String(), err
+}
+
+func Test_quoteMode(t *testing.T) {
+	jsonData := `[{"id":1,"name":"a, b","note":"say \"hi\"","active":true,"tags":["x", "y"],"empty":"","none":null},` +
+		`{"id":-2.5e3,"name":" c","note":"ok","active":false,"tags":{},"empty":""}]`
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"minimal", "id,name,note,active,tags,empty,none\n" +
+			"1,\"a, b\",\"say \"\"hi\"\"\",true,\"[\"\"x\"\",\"\"y\"\"]\",,\n" +
+			"-2.5e3,\" c\",ok,false,{},,\n"},
+		{"all", "\"id\",\"name\",\"note\",\"active\",\"tags\",\"empty\",\"none\"\n" +
+			"\"1\",\"a, b\",\"say \"\"hi\"\"\",\"true\",\"[\"\"x\"\",\"\"y\"\"]\",\"\",\"\"\n" +
+			"\"-2.5e3\",\" c\",\"ok\",\"false\",\"{}\",\"\",\"\"\n"},
+		{"nonnumeric", "\"id\",\"name\",\"note\",\"active\",\"tags\",\"empty\",\"none\"\n" +
+			"1,\"a, b\",\"say \"\"hi\"\"\",\"true\",\"[\"\"x\"\",\"\"y\"\"]\",\"\",\n" +
+			"-2.5e3,\" c\",\"ok\",\"false\",\"{}\",\"\",\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := reverseString(t, withOptions(func(f *inputFile) { f.reverse, f.outputSuffix, f.quoteMode = true, ".csv", tt.mode }), jsonData)
+			check(err)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_reverse(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileData inputFile
+		jsonData string
+		want     string
+		wantErr  bool
+	}{
+		{"Key order of the first record", defaultFileData, `[{"b":"1","a":"2"},{"a":"3"}]`, "b,a\n1,2\n,3\n", false},
+		{"Null value", withOptions(func(f *inputFile) { f.nullValue, f.nullValueGiven = "NULL", true }), `[{"a":null},{}]`, "a\nNULL\nNULL\n", false},
+		{"Tab separated output", withOptions(func(f *inputFile) { f.outputSuffix = ".tsv" }), `[{"a":"x,y","b":"z"}]`, "a\tb\nx,y\tz\n", false},
+		{"Special values quoted", defaultFileData, `[{"a":"\\."},{"a":"line\nbreak"}]`, "a\n\"\\.\"\n\"line\nbreak\"\n", false},
+		{"No records", defaultFileData, `[]`, "", false},
+		{"CRLF line endings", withOptions(func(f *inputFile) { f.lineEnding = "\r\n" }), `[{"a":"line\nbreak","b":"2"}]`, "a,b\r\n\"line\nbreak\",2\r\n", false},
+		{"New key", defaultFileData, `[{"a":"1"},{"b":"2"}]`, "", true},
+		{"NDJSON", defaultFileData, "{\"b\":\"1\",\"a\":2}\n{\"a\":\"3\"}\n", "b,a\n1,2\n,3\n", false},
+		{"NDJSON with spaces and CRLF", defaultFileData, " \r\n{\"a\":\"1\"}\r\n\r\n{\"a\":\"2\"}", "a\n1\n2\n", false},
+		{"NDJSON with an array", defaultFileData, "{\"a\":\"1\"}\n[]\n", "", true},
+		{"Empty input", defaultFileData, "", "", true},
+		{"Not an array", defaultFileData, `"records"`, "", true},
+		{"Not an object", defaultFileData, `[["1"]]`, "", true},
+		{"Truncated", defaultFileData, `[{"a":"1"},`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fileData.filepath, tt.fileData.reverse = "test.json", true
+			if tt.fileData.outputSuffix == ".json" {
+				tt.fileData.outputSuffix = ".csv"
+			}
+
+			got, err := reverseString(t, tt.fileData, tt.jsonData)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reverseTo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_reverseFile(t *testing.T) {
+	dir := t.TempDir()
+	csvData := "id,name\n1,\"a, b\"\n2,c\n"
+	check(ioutil.WriteFile(filepath.Join(dir, "data.csv"), []byte(csvData), 0666))
+
+	// The JSON written by the conversion goes back into the same CSV, once the keys are in the order of the columns
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.keyOrder = filepath.Join(dir, "data.csv"), "csv" }))
+	check(reverseFile(context.Background(), withOptions(func(f *inputFile) {
+		f.filepath, f.reverse, f.outputSuffix = filepath.Join(dir, "data.json"), true, ".back.csv"
+	})))
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "data.back.csv"))
+	check(err)
+	if string(got) != csvData {
+		t.Errorf("output = %q, want %q", got, csvData)
+	}
+}
+
+func Test_reverseNDJSONFile(t *testing.T) {
+	dir := t.TempDir()
+	ndjsonPath := filepath.Join(dir, "events.ndjson")
+	check(ioutil.WriteFile(ndjsonPath, []byte(`{"id":1,"kind":"click","tags":["a","b"]}`+"\n"+`{"id":2,"kind":null}`+"\n"), 0666))
+
+	if _, err := checkIfValidJSONFile(ndjsonPath, false); err != nil {
+		t.Fatalf("checkIfValidJSONFile() error = %v", err)
+	}
+	check(reverseFile(context.Background(), withOptions(func(f *inputFile) { f.filepath, f.reverse, f.outputSuffix = ndjsonPath, true, ".csv" })))
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "events.csv"))
+	check(err)
+	want := "id,kind,tags\n1,click,\"[\"\"a\"\",\"\"b\"\"]\"\n2,,\n"
+	if string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
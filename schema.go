@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Number of violations shown in the report of a schema check
+const maxSchemaViolations = 5
+
+// The types of a property, which JSON Schema allows to give as a single name or as a list of them
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = schemaTypes{name}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+type schemaProperty struct {
+	Type schemaTypes   `json:"type"`
+	Enum []interface{} `json:"enum"`
+}
+
+// The part of JSON Schema that applies to the flat objects we write: the types and values allowed for each
+// property, the required properties, and whether properties not in the schema are allowed
+type jsonSchema struct {
+	Properties           map[string]schemaProperty `json:"properties"`
+	Required             []string                  `json:"required"`
+	AdditionalProperties *bool                     `json:"additionalProperties"`
+}
+
+// Reads a schema without validating it, which is enough to know its properties
+func readSchema(path string) (*jsonSchema, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema jsonSchema
+	if err := json.Unmarshal(content, &schema); err != nil {
+		return nil, fmt.Errorf("Schema %s is not valid: %v", path, err)
+	}
+
+	return &schema, nil
+}
+
+// Reads a schema to validate the records with
+func loadSchema(path string) (*jsonSchema, error) {
+	schema, err := readSchema(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, property := range schema.Properties {
+		for _, t := range property.Type {
+			switch t {
+			case "string", "number", "integer", "boolean", "null":
+			default:
+				return nil, fmt.Errorf("Property %s of schema %s has type %s, which is not supported for CSV values", name, path, t)
+			}
+		}
+	}
+
+	return schema, nil
+}
+
+// Returns the names of the properties of the schema, sorted
+func (s *jsonSchema) propertyNames() []string {
+	var names []string
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// The rules of the schema for one of the keys of the records
+type schemaRule struct {
+	key      string
+	types    schemaTypes
+	enum     map[string]bool // The values allowed, as they are written in the CSV file
+	required bool
+}
+
+// Validates the records of a file against a schema, for --schema. CSV values are text, so a value matches a type
+// when it's written like a value of that type. An empty value is a missing one, which is only a violation when
+// the property is required
+type schemaCheck struct {
+	rules          []schemaRule // By the position of the keys in the records
+	fileViolations []string     // Violations of every record, because of the headers of the file
+	nullValue      string
+	nullValueGiven bool
+	passed         int64
+	failed         int64
+	examples       []string
+}
+
+func newSchemaCheck(schema *jsonSchema, keys []string, fileData inputFile) *schemaCheck {
+	c := &schemaCheck{rules: make([]schemaRule, len(keys)), nullValue: fileData.nullValue, nullValueGiven: fileData.nullValueGiven}
+
+	required := make(map[string]bool, len(schema.Required))
+	for _, name := range schema.Required {
+		required[name] = true
+		if columnIndex(keys, name) < 0 {
+			c.fileViolations = append(c.fileViolations, fmt.Sprintf("required column %s is missing", name))
+		}
+	}
+
+	for i, key := range keys {
+		property, known := schema.Properties[key]
+		if !known && schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+			c.fileViolations = append(c.fileViolations, fmt.Sprintf("column %s is not in the schema", key))
+		}
+
+		rule := schemaRule{key: key, types: property.Type, required: required[key]}
+		if property.Enum != nil {
+			rule.enum = make(map[string]bool, len(property.Enum))
+			for _, value := range property.Enum {
+				if text, ok := value.(string); ok {
+					rule.enum[text] = true
+				} else {
+					jsonData, _ := json.Marshal(value)
+					rule.enum[string(jsonData)] = true
+				}
+			}
+		}
+		c.rules[i] = rule
+	}
+
+	return c
+}
+
+// Validates if a value is written like a value of a JSON Schema type
+func (c *schemaCheck) matchesType(value string, t string) bool {
+	if t == "null" {
+		return c.nullValueGiven && value == c.nullValue
+	}
+	return matchesValueType(value, t)
+}
+
+// Validates if a value is written like a value of a JSON Schema type other than null
+func matchesValueType(value string, t string) bool {
+	switch t {
+	case "string":
+		return true
+	case "number":
+		return isJSONNumber(value)
+	case "integer":
+		if !isJSONNumber(value) {
+			return false
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		return err == nil && number == math.Trunc(number)
+	case "boolean":
+		return isJSONBoolean(value)
+	}
+
+	return false
+}
+
+// Returns what's wrong with a value, or "" when it follows its rule
+func (c *schemaCheck) violation(rule schemaRule, value string) string {
+	if value == "" {
+		if rule.required {
+			return fmt.Sprintf("column %s is required", rule.key)
+		}
+		return ""
+	}
+
+	if len(rule.types) > 0 {
+		matches := false
+		for _, t := range rule.types {
+			if c.matchesType(value, t) {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			return fmt.Sprintf("value %q of column %s is not %s", value, rule.key, strings.Join(rule.types, " or "))
+		}
+	}
+
+	if rule.enum != nil && !rule.enum[value] {
+		return fmt.Sprintf("value %q of column %s is not one of the allowed values", value, rule.key)
+	}
+
+	return ""
+}
+
+// Validates a record, given with its values in the same order as the keys
+func (c *schemaCheck) add(line int, values []string) {
+	var violations []string
+	violations = append(violations, c.fileViolations...)
+	for i, value := range values {
+		if v := c.violation(c.rules[i], value); v != "" {
+			violations = append(violations, v)
+		}
+	}
+
+	if len(violations) == 0 {
+		c.passed++
+		return
+	}
+
+	c.failed++
+	if len(c.examples) < maxSchemaViolations {
+		c.examples = append(c.examples, fmt.Sprintf("Line %d: %s", line, strings.Join(violations, ", ")))
+	}
+}
+
+// Logs how many records follow the schema, returning whether all of them do
+func (c *schemaCheck) report(path string) bool {
+	if c.failed == 0 {
+		logger.infof("All %d records of %s match the schema", c.passed, path)
+		return true
+	}
+
+	logger.warnf("%d records of %s match the schema and %d don't", c.passed, path, c.failed)
+	for _, example := range c.examples {
+		logger.warnf("%s", example)
+	}
+
+	return false
+}
+
+// What --emit-schema has seen of the values written at one place of the records: the records themselves, one of
+// their properties, or the items of one of their arrays
+type inferredValue struct {
+	count      int64 // Number of values seen
+	types      map[string]bool
+	objects    int64 // Number of the values that are objects
+	properties map[string]*inferredValue
+	items      *inferredValue
+}
+
+func newInferredValue() *inferredValue {
+	return &inferredValue{types: make(map[string]bool)}
+}
+
+// Adds a value decoded from the JSON, with its numbers as json.Number
+func (v *inferredValue) add(value interface{}) {
+	v.count++
+	switch value := value.(type) {
+	case nil:
+		v.types["null"] = true
+	case bool:
+		v.types["boolean"] = true
+	case string:
+		v.types["string"] = true
+	case json.Number:
+		if matchesValueType(value.String(), "integer") {
+			v.types["integer"] = true
+		} else {
+			v.types["number"] = true
+		}
+	case map[string]interface{}:
+		v.types["object"] = true
+		v.objects++
+		if v.properties == nil {
+			v.properties = make(map[string]*inferredValue, len(value))
+		}
+		for key, property := range value {
+			if v.properties[key] == nil {
+				v.properties[key] = newInferredValue()
+			}
+			v.properties[key].add(property)
+		}
+	case []interface{}:
+		v.types["array"] = true
+		if v.items == nil {
+			v.items = newInferredValue()
+		}
+		for _, item := range value {
+			v.items.add(item)
+		}
+	}
+}
+
+// The order of the types of a property in the schema
+var inferredTypes = []string{"object", "array", "string", "integer", "number", "boolean", "null"}
+
+// The schema of the values seen. A number covers the integers, so a property with both is only a number. A property
+// is required when every object seen has it
+func (v *inferredValue) schema() *inferredSchema {
+	schema := &inferredSchema{Type: schemaTypes{}}
+	for _, t := range inferredTypes {
+		if v.types[t] && !(t == "integer" && v.types["number"]) {
+			schema.Type = append(schema.Type, t)
+		}
+	}
+
+	if v.properties != nil {
+		schema.Properties = make(map[string]*inferredSchema, len(v.properties))
+		schema.Required = []string{}
+		for key, property := range v.properties {
+			schema.Properties[key] = property.schema()
+			if property.count == v.objects {
+				schema.Required = append(schema.Required, key)
+			}
+		}
+		sort.Strings(schema.Required)
+	}
+	if v.items != nil && v.items.count > 0 {
+		schema.Items = v.items.schema()
+	}
+
+	return schema
+}
+
+// The schema written by --emit-schema, and the ones of its properties
+type inferredSchema struct {
+	Schema     string                     `json:"$schema,omitempty"`
+	Type       schemaTypes                `json:"type"`
+	Properties map[string]*inferredSchema `json:"properties,omitempty"`
+	Required   []string                   `json:"required,omitempty"`
+	Items      *inferredSchema            `json:"items,omitempty"`
+}
+
+// Infers a schema from the records while they are converted, for --emit-schema. The records are encoded like
+// in the JSON file and read back, so the schema has the keys and the types the file has, whatever the options
+// shaping them, and it validates the file it was inferred from
+type schemaInference struct {
+	keys     int
+	jsonFunc func(record) string
+	records  *inferredValue
+}
+
+func newSchemaInference(keys []string, fileData inputFile) *schemaInference {
+	// The schema doesn't depend on the indentation, so the records are encoded compact. The values that don't fit
+	// their type are already warned about by the JSON file
+	fileData.pretty, fileData.align, fileData.silent = false, false, true
+	jsonFunc, _ := getJSONFunc(fileData, "")
+
+	return &schemaInference{keys: len(keys), jsonFunc: jsonFunc, records: newInferredValue()}
+}
+
+// Adds a record, encoding it like the JSON file does
+func (s *schemaInference) add(rec record) error {
+	decoder := json.NewDecoder(strings.NewReader(s.jsonFunc(rec)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	s.records.add(value)
+	return nil
+}
+
+// Returns the schema of the records added
+func (s *schemaInference) schema() *inferredSchema {
+	schema := s.records.schema()
+	if s.records.count == 0 {
+		schema.Type = schemaTypes{"object"}
+	}
+	schema.Schema = "https://json-schema.org/draft/2020-12/schema"
+
+	return schema
+}
+
+// Writes the schema into the file given by --emit-schema, indented like the JSON file
+func (s *schemaInference) write(fileData inputFile) error {
+	content, err := json.MarshalIndent(s.schema(), "", fileData.indent)
+	if err != nil {
+		return err
+	}
+	if err := fileData.outputMode.writeFile(fileData.emitSchema, append(content, '\n')); err != nil {
+		return err
+	}
+
+	logger.infof("Schema of %d columns written to %s", s.keys, fileData.emitSchema)
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Runs a schema check over every line of a CSV file
+func checkSchema(csvString string, fileData inputFile) *schemaCheck {
+	schema, err := loadSchema(filepath.Join("testJsonFiles", "schema.json"))
+	check(err)
+
+	reader := csv.NewReader(strings.NewReader(csvString))
+	headers, err := reader.Read()
+	check(err)
+	c := newSchemaCheck(schema, headers, fileData)
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			return c
+		}
+		check(err)
+		lineNumber, _ := reader.FieldPos(0)
+		c.add(lineNumber, line)
+	}
+}
+
+func Test_schemaCheck(t *testing.T) {
+	csvString := "id,name,price,status\n1,a,9.99,active\n2.5,b,,retired\n3,,abc,active\n4,d,NULL,sold\n5,e,10,\n"
+	c := checkSchema(csvString, withOptions(func(f *inputFile) { f.nullValue, f.nullValueGiven = "NULL", true }))
+
+	want := []string{
+		`Line 3: value "2.5" of column id is not integer`,
+		`Line 4: column name is required, value "abc" of column price is not number or null`,
+		`Line 5: value "sold" of column status is not one of the allowed values`,
+	}
+	if c.passed != 2 || c.failed != 3 || !reflect.DeepEqual(c.examples, want) {
+		t.Errorf("schema check = %d passed, %d failed %v, want 2 passed, 3 failed %v", c.passed, c.failed, c.examples, want)
+	}
+}
+
+func Test_schemaCheckHeaders(t *testing.T) {
+	c := checkSchema("id,extra\n1,x\n", defaultFileData)
+
+	want := []string{"Line 2: required column name is missing, column extra is not in the schema"}
+	if c.failed != 1 || !reflect.DeepEqual(c.examples, want) {
+		t.Errorf("schema check = %d failed %v, want 1 failed %v", c.failed, c.examples, want)
+	}
+}
+
+func Test_loadSchema(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"Valid schema", `{"properties": {"id": {"type": "integer"}}}`, false},
+		{"Not JSON", `properties: id`, true},
+		{"Unsupported type", `{"properties": {"tags": {"type": "array"}}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "schema.json")
+			check(ioutil.WriteFile(path, []byte(tt.content), 0666))
+			if _, err := loadSchema(path); (err != nil) != tt.wantErr {
+				t.Errorf("loadSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_dryValidate(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id,name\n1,a\n2,b\n"), 0666))
+	schema, err := loadSchema(filepath.Join("testJsonFiles", "schema.json"))
+	check(err)
+
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.schema, f.dryValidate = csvPath, schema, true }))
+
+	if names := listDir(t, dir); !reflect.DeepEqual(names, []string{"data.csv"}) {
+		t.Errorf("directory has %v, want no JSON file", names)
+	}
+}
+
+// Returns where a value decoded with json.Number doesn't follow a schema written by --emit-schema
+func inferredSchemaViolations(schema *inferredSchema, value interface{}, path string) []string {
+	var t string
+	switch value := value.(type) {
+	case nil:
+		t = "null"
+	case bool:
+		t = "boolean"
+	case string:
+		t = "string"
+	case json.Number:
+		t = "number"
+		if matchesValueType(value.String(), "integer") && columnIndex(schema.Type, "number") < 0 {
+			t = "integer"
+		}
+	case map[string]interface{}:
+		t = "object"
+	case []interface{}:
+		t = "array"
+	}
+	if columnIndex(schema.Type, t) < 0 {
+		return []string{fmt.Sprintf("%s is %s, not %v", path, t, schema.Type)}
+	}
+
+	var violations []string
+	if object, ok := value.(map[string]interface{}); ok {
+		for _, key := range schema.Required {
+			if _, found := object[key]; !found {
+				violations = append(violations, fmt.Sprintf("%s.%s is missing", path, key))
+			}
+		}
+		for key, property := range object {
+			if schema.Properties[key] == nil {
+				violations = append(violations, fmt.Sprintf("%s.%s is not in the schema", path, key))
+				continue
+			}
+			violations = append(violations, inferredSchemaViolations(schema.Properties[key], property, path+"."+key)...)
+		}
+	}
+	if array, ok := value.([]interface{}); ok && schema.Items != nil {
+		for i, item := range array {
+			violations = append(violations, inferredSchemaViolations(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+
+	return violations
+}
+
+func Test_schemaInference(t *testing.T) {
+	csvString := "id,price,active,name,score,lat,lon,meta\n1,9.99,true,a,10,48.85,2.35,\"{\"\"tags\"\":[\"\"x\"\"]}\"\n2,10,false,b,,1,2,\"[1,2.5]\"\n3,-1e3,true,,NULL,,,\n"
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{
+			"Every value is a string",
+			defaultFileData,
+			`{"type":["object"],"properties":{"active":{"type":["string"]},"id":{"type":["string"]},"lat":{"type":["string"]},"lon":{"type":["string"]},"meta":{"type":["string"]},"name":{"type":["string"]},"price":{"type":["string"]},"score":{"type":["string"]}},"required":["active","id","lat","lon","meta","name","price","score"]}`,
+		},
+		{
+			"Typed values",
+			withOptions(func(f *inputFile) { f.typed, f.nullValue, f.nullValueGiven = true, "NULL", true }),
+			`{"type":["object"],"properties":{"active":{"type":["boolean"]},"id":{"type":["integer"]},"lat":{"type":["string","number"]},"lon":{"type":["string","number"]},"meta":{"type":["string"]},"name":{"type":["string"]},"price":{"type":["number"]},"score":{"type":["string","integer","null"]}},"required":["active","id","lat","lon","meta","name","price","score"]}`,
+		},
+		{
+			"Prefixed and wrapped keys",
+			withOptions(func(f *inputFile) { f.keyPrefix, f.wrapKey, f.columns = "csv_", "row", []string{"id"} }),
+			`{"type":["object"],"properties":{"row":{"type":["object"],"properties":{"csv_id":{"type":["string"]}},"required":["csv_id"]}},"required":["row"]}`,
+		},
+		{
+			"Currencies, points and JSON values",
+			withOptions(func(f *inputFile) {
+				f.columns, f.geoPoints, f.geoInvalid, f.parseJSON, f.parseJSONInvalid = []string{"price", "lat", "lon", "meta"}, []geoPoint{{"lat", "lon", "location"}}, "null", []string{"meta"}, "error"
+				f.typeMap = map[string]valueType{"price": typeCurrency, "location": typePoint, "meta": typeJSON}
+			}),
+			`{"type":["object"],"properties":{"location":{"type":["object","null"],"properties":{"coordinates":{"type":["array"],"items":{"type":["number"]}},"type":{"type":["string"]}},"required":["coordinates","type"]},"meta":{"type":["object","array","null"],"properties":{"tags":{"type":["array"],"items":{"type":["string"]}}},"required":["tags"],"items":{"type":["number"]}},"price":{"type":["object","string"],"properties":{"amount":{"type":["number"]},"currency":{"type":["null"]}},"required":["amount","currency"]}},"required":["location","meta","price"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.fileData.filepath, tt.fileData.emitSchema = filepath.Join(dir, "data.csv"), filepath.Join(dir, "schema.json")
+			check(ioutil.WriteFile(tt.fileData.filepath, []byte(csvString), 0666))
+			mustConvertFile(t, tt.fileData)
+
+			content, err := ioutil.ReadFile(tt.fileData.emitSchema)
+			check(err)
+			var schema inferredSchema
+			check(json.Unmarshal(content, &schema))
+			schema.Schema = ""
+			got, err := json.Marshal(schema)
+			check(err)
+			if string(got) != tt.want {
+				t.Errorf("schema = %s, want %s", got, tt.want)
+			}
+
+			// The schema written validates every record of the JSON file written along with it
+			content, err = ioutil.ReadFile(filepath.Join(dir, "data.json"))
+			check(err)
+			decoder := json.NewDecoder(bytes.NewReader(content))
+			decoder.UseNumber()
+			var records []interface{}
+			check(decoder.Decode(&records))
+			for i, rec := range records {
+				if violations := inferredSchemaViolations(&schema, rec, fmt.Sprintf("record %d", i+1)); violations != nil {
+					t.Errorf("JSON file doesn't match its schema: %v", violations)
+				}
+			}
+		})
+	}
+}
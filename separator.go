@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// What processCsvFile needs from the reader of the records, which is a csv.Reader unless the separator
+// has more than one character or the file has fixed widths
+type recordReader interface {
+	Read() ([]string, error)
+	FieldPos(field int) (line, column int)
+}
+
+// The separators that can be given by their name
+var separatorNames = map[string]rune{"comma": ',', "semicolon": ';', "tab": '\t'}
+
+// Returns the separator given in the command line when it has more than one character, or "" when it's a single rune
+func multiSeparator(fileData inputFile) string {
+	if _, named := separatorNames[fileData.separator]; named || utf8.RuneCountInString(fileData.separator) < 2 {
+		return ""
+	}
+	return fileData.separator
+}
+
+// Parses the character given by --csv-comment, which is 0 when there's none
+func parseReaderComment(value string) (rune, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	r, size := utf8.DecodeRuneInString(value)
+	if size != len(value) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("The comment %q must be a single character other than a quote or a line break", value)
+	}
+	return r, nil
+}
+
+// Validates that the options of the CSV reader make sense with the separator of a file. A separator that is a space
+// would be ignored at the start of the fields too, and a comment can't start with the separator
+func checkReaderOptions(trimLeadingSpace bool, comment rune, comma rune) error {
+	if trimLeadingSpace && unicode.IsSpace(comma) {
+		return fmt.Errorf("The csv-trim-leading-space option can't be used with the separator %q, which is a space", comma)
+	}
+	if comment != 0 && comment == comma {
+		return fmt.Errorf("The comment character %q can't be the separator", comment)
+	}
+
+	return nil
+}
+
+// Picks the separator of a file for --separator-fallback. The separator it would be read with is tried first, then
+// the fallbacks in order, and the first one that reads the headers as more than one column is used. A separator that
+// can't read the first rows, like because of their quotes, is just as wrong. When none works, the file is read as
+// it would be without the fallbacks
+func pickSeparator(fileData inputFile) (inputFile, error) {
+	candidates := []inputFile{fileData}
+	for _, fallback := range fileData.separatorFallback {
+		candidate := fileData
+		candidate.separator, candidate.separatorGiven = fallback, true
+		candidates = append(candidates, candidate)
+	}
+
+	for i, candidate := range candidates {
+		sample, err := sampleFile(candidate)
+		var parseErr *csv.ParseError
+		if err == io.EOF {
+			// An empty file has no columns with any separator
+			return fileData, nil
+		}
+		if errors.As(err, &parseErr) || err == nil && len(sample.headers) < 2 {
+			logger.debugf("%s has a single column with the separator %s", fileData.filepath, separatorName(candidate))
+			continue
+		}
+		if err != nil {
+			return inputFile{}, err
+		}
+
+		if i > 0 {
+			logger.infof("Reading %s with the fallback separator %s", fileData.filepath, separatorName(candidate))
+		}
+		return candidate, nil
+	}
+
+	logger.warnf("No separator reads %s as more than one column, so it's read with the separator %s", fileData.filepath, separatorName(fileData))
+	return fileData, nil
+}
+
+// Creates the reader of the records of a file. The records it returns share their memory, so the values
+// kept after the next Read must be copied first
+func newRecordReader(r *bufio.Reader, comma rune, fileData inputFile) recordReader {
+	// Lines with the wrong number of fields are left for us to handle, instead of failing. Otherwise the number
+	// of fields is the one given, or the one of the first line, unless the headers are not in the file
+	fieldsPerRecord := 0
+	switch {
+	case fileData.ragged != "error":
+		fieldsPerRecord = -1
+	case fileData.fieldsPerRecord > 0:
+		fieldsPerRecord = fileData.fieldsPerRecord
+	case fileData.givenHeaders != nil:
+		fieldsPerRecord = len(fileData.givenHeaders)
+	case fileData.fixedWidths != nil:
+		fieldsPerRecord = len(fileData.fixedWidths)
+	}
+
+	if fileData.fixedWidths != nil {
+		return &fixedWidthReader{r: r, widths: fileData.fixedWidths, runes: fileData.widthUnit == "rune", fieldsPerRecord: fieldsPerRecord}
+	}
+	if separator := multiSeparator(fileData); separator != "" {
+		return &separatorReader{r: r, separator: separator, fieldsPerRecord: fieldsPerRecord}
+	}
+
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = fileData.reuseRecord
+	reader.Comma = comma
+	reader.FieldsPerRecord = fieldsPerRecord
+	reader.LazyQuotes = fileData.lazyQuotes
+	reader.TrimLeadingSpace = fileData.trimLeadingSpace
+	reader.Comment = fileData.comment
+	return reader
+}
+
+// Where a field of a record starts, as FieldPos returns it
+type fieldPosition struct {
+	line   int
+	column int
+}
+
+// Reads records whose fields are separated by more than one character, like "||", which encoding/csv can't do.
+// Quoted fields work like in encoding/csv: a separator or a line break inside the quotes is part of the value,
+// and a doubled quote is a quote. Empty lines are skipped, and the number of fields is checked the same way too
+type separatorReader struct {
+	r               *bufio.Reader
+	separator       string
+	fieldsPerRecord int
+	line            int // Number of lines read
+	record          []string
+	positions       []fieldPosition
+}
+
+// Reads the next line with its line break, which is always "\n" and is added when the last line doesn't have it
+func (s *separatorReader) readLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		line, err = line+"\n", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	s.line++
+	if strings.HasSuffix(line, "\r\n") {
+		line = line[:len(line)-2] + "\n"
+	}
+	return line, nil
+}
+
+func (s *separatorReader) Read() ([]string, error) {
+	line, err := s.readLine()
+	for err == nil && line == "\n" {
+		line, err = s.readLine()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.record, s.positions = s.record[:0], s.positions[:0]
+	startLine, pos := s.line, 0
+	for {
+		s.positions = append(s.positions, fieldPosition{s.line, pos + 1})
+
+		if !strings.HasPrefix(line[pos:], `"`) {
+			rest := line[pos : len(line)-1]
+			field := rest
+			end := strings.Index(rest, s.separator)
+			if end >= 0 {
+				field = rest[:end]
+			}
+			if quote := strings.IndexByte(field, '"'); quote >= 0 {
+				return nil, &csv.ParseError{StartLine: startLine, Line: s.line, Column: pos + quote + 1, Err: csv.ErrBareQuote}
+			}
+
+			s.record = append(s.record, field)
+			if end < 0 {
+				break
+			}
+			pos += end + len(s.separator)
+			continue
+		}
+
+		// A quoted field goes on until a quote that's not doubled, even through the next lines
+		var field strings.Builder
+		pos++
+		for {
+			quote := strings.IndexByte(line[pos:], '"')
+			if quote < 0 {
+				field.WriteString(line[pos:])
+				next, err := s.readLine()
+				if err == io.EOF {
+					return nil, &csv.ParseError{StartLine: startLine, Line: s.line, Column: len(line), Err: csv.ErrQuote}
+				}
+				if err != nil {
+					return nil, err
+				}
+				line, pos = next, 0
+				continue
+			}
+
+			field.WriteString(line[pos : pos+quote])
+			pos += quote + 1
+			if !strings.HasPrefix(line[pos:], `"`) {
+				break
+			}
+			field.WriteByte('"')
+			pos++
+		}
+		s.record = append(s.record, field.String())
+
+		// The closing quote is followed by a separator or by the end of the line
+		if line[pos:] == "\n" {
+			break
+		}
+		if !strings.HasPrefix(line[pos:], s.separator) {
+			return nil, &csv.ParseError{StartLine: startLine, Line: s.line, Column: pos + 1, Err: csv.ErrQuote}
+		}
+		pos += len(s.separator)
+	}
+
+	// Like with encoding/csv, the record is returned along with the error, so it can still be looked at
+	if s.fieldsPerRecord == 0 {
+		s.fieldsPerRecord = len(s.record)
+	} else if s.fieldsPerRecord > 0 && len(s.record) != s.fieldsPerRecord {
+		return s.record, &csv.ParseError{StartLine: startLine, Line: startLine, Column: 1, Err: csv.ErrFieldCount}
+	}
+
+	return s.record, nil
+}
+
+// Returns the line and the column where a field of the last record starts
+func (s *separatorReader) FieldPos(field int) (line, column int) {
+	if field < 0 || field >= len(s.positions) {
+		panic("out of range index passed to FieldPos")
+	}
+
+	return s.positions[field].line, s.positions[field].column
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_separatorReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    [][]string
+		wantErr error
+	}{
+		{"Plain fields", "a||b||c\n1||2||3\n", [][]string{{"a", "b", "c"}, {"1", "2", "3"}}, nil},
+		{"Empty fields", "||b||\n", [][]string{{"", "b", ""}}, nil},
+		{"Separator inside quotes", "a||\"b||c\"||d\n", [][]string{{"a", "b||c", "d"}}, nil},
+		{"Doubled quotes", "\"say \"\"hi\"\"\"||x\n", [][]string{{`say "hi"`, "x"}}, nil},
+		{"Line break inside quotes", "a||\"b\r\nc\"\nd||e", [][]string{{"a", "b\nc"}, {"d", "e"}}, nil},
+		{"Empty lines and CRLF", "a||b\r\n\r\n\n1||2\r\n", [][]string{{"a", "b"}, {"1", "2"}}, nil},
+		{"Part of the separator", "a|b||c\n", [][]string{{"a|b", "c"}}, nil},
+		{"Bare quote", "a||b\"c\n", nil, csv.ErrBareQuote},
+		{"Text after the closing quote", "\"a\"b||c\n", nil, csv.ErrQuote},
+		{"Unterminated quote", "a||\"b\n", nil, csv.ErrQuote},
+		{"Wrong number of fields", "a||b\n1\n", [][]string{{"a", "b"}}, csv.ErrFieldCount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := &separatorReader{r: bufio.NewReader(strings.NewReader(tt.data)), separator: "||"}
+
+			var got [][]string
+			var err error
+			for {
+				var line []string
+				line, err = reader.Read()
+				if err != nil {
+					break
+				}
+				got = append(got, append([]string(nil), line...))
+			}
+
+			if tt.wantErr == nil && err != io.EOF || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("records = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_separatorReaderPositions(t *testing.T) {
+	reader := &separatorReader{r: bufio.NewReader(strings.NewReader("id~|~name\n\n1~|~\"a\nb\"~|~c\n")), separator: "~|~", fieldsPerRecord: -1}
+	_, err := reader.Read()
+	check(err)
+	_, err = reader.Read()
+	check(err)
+
+	for field, want := range []fieldPosition{{3, 1}, {3, 5}, {4, 6}} {
+		if line, column := reader.FieldPos(field); line != want.line || column != want.column {
+			t.Errorf("FieldPos(%d) = %d, %d, want %d, %d", field, line, column, want.line, want.column)
+		}
+	}
+}
+
+func Test_multiCharacterSeparator(t *testing.T) {
+	tests := []struct {
+		name      string
+		separator string
+		data      string
+		want      string
+	}{
+		{"Double pipe", "||", "id||name\n1||\"a||b\"\n2||c\n", `[{"id":"1","name":"a||b"},{"id":"2","name":"c"}]` + "\n"},
+		{"Tilde pipe tilde", "~|~", "id~|~name\r\n1~|~a|b\r\n", `[{"id":"1","name":"a|b"}]` + "\n"},
+		{"Single character", "|", "id|name\n1|\"a|b\"\n", `[{"id":"1","name":"a|b"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convertString(t, tt.data, withOptions(func(f *inputFile) { f.separator, f.separatorGiven = tt.separator, true }))
+			if got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_csvReaderOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileData inputFile
+		data     string
+		want     string
+	}{
+		{"Default options", defaultFileData, "id,name\n1,a\n", `[{"id":"1","name":"a"}]` + "\n"},
+		{"Lazy quotes", withOptions(func(f *inputFile) { f.lazyQuotes = true }), "id,name\n1,a \"b\"\n", `[{"id":"1","name":"a \"b\""}]` + "\n"},
+		{"Trimmed leading space", withOptions(func(f *inputFile) { f.trimLeadingSpace = true }), "id, name\n1,  a \n", `[{"id":"1","name":"a "}]` + "\n"},
+		{"Comment lines", withOptions(func(f *inputFile) { f.comment = '#' }), "# export\nid,name\n# 1,a\n2,b\n", `[{"id":"2","name":"b"}]` + "\n"},
+		{"Records not reused", withOptions(func(f *inputFile) { f.reuseRecord = false }), "id,name\n1,a\n2,b\n", `[{"id":"1","name":"a"},{"id":"2","name":"b"}]` + "\n"},
+		{"Fields per record", withOptions(func(f *inputFile) { f.fieldsPerRecord = 2 }), "id,name\n1,a\n", `[{"id":"1","name":"a"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, tt.data, tt.fileData); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fieldsPerRecord(t *testing.T) {
+	// The headers have 2 fields, but every line must have 3
+	reader := newRecordReader(bufio.NewReader(strings.NewReader("id,name\n1,a,b\n")), ',', withOptions(func(f *inputFile) { f.fieldsPerRecord = 3 }))
+	if _, err := reader.Read(); !errors.Is(err, csv.ErrFieldCount) {
+		t.Errorf("Read() error = %v, want %v", err, csv.ErrFieldCount)
+	}
+}
+
+func Test_checkReaderOptions(t *testing.T) {
+	tests := []struct {
+		name             string
+		trimLeadingSpace bool
+		comment          rune
+		comma            rune
+		wantErr          bool
+	}{
+		{"No options", false, 0, '\t', false},
+		{"Trimmed comma", true, 0, ',', false},
+		{"Trimmed tab", true, 0, '\t', true},
+		{"Comment", false, '#', ';', false},
+		{"Comment is the separator", false, '#', '#', true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkReaderOptions(tt.trimLeadingSpace, tt.comment, tt.comma); (err != nil) != tt.wantErr {
+				t.Errorf("checkReaderOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_pickSeparator(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"Semicolon after a single column with comma", "id;name\n1;a\n2;b\n", `[{"id":"1","name":"a"},{"id":"2","name":"b"}]` + "\n"},
+		{"Comma is kept when it works", "id,name\n1,a;b\n", `[{"id":"1","name":"a;b"}]` + "\n"},
+		{"Multi-character fallback", "id||name\n1||a\n", `[{"id":"1","name":"a"}]` + "\n"},
+		{"Quotes that comma can't read", "id;na,me\n1;\"a,b\"\n", `[{"id":"1","na,me":"a,b"}]` + "\n"},
+		{"Single column is read with comma", "id\n1\n", `[{"id":"1"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csvPath := filepath.Join(t.TempDir(), "data.csv")
+			check(ioutil.WriteFile(csvPath, []byte(tt.data), 0666))
+
+			fileData := withOptions(func(f *inputFile) { f.filepath, f.separatorFallback = csvPath, []string{"semicolon", "||"} })
+			picked, err := pickSeparator(fileData)
+			if err != nil {
+				t.Fatalf("pickSeparator() error = %v", err)
+			}
+
+			var buf bytes.Buffer
+			check(convertTo(picked, &buf))
+			if buf.String() != tt.want {
+				t.Errorf("output = %s, want %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The column records are sorted by with --sort-by
+type sortOrder struct {
+	column     string
+	descending bool
+}
+
+// Parses a sort order written as COLUMN, COLUMN:asc or COLUMN:desc
+func parseSortOrder(value string) (sortOrder, error) {
+	order := sortOrder{column: value}
+	if separatorIndex := strings.LastIndex(value, ":"); separatorIndex >= 0 {
+		switch strings.ToLower(value[separatorIndex+1:]) {
+		case "asc":
+			order.column = value[:separatorIndex]
+		case "desc":
+			order.column, order.descending = value[:separatorIndex], true
+		}
+	}
+
+	if order.column == "" {
+		return sortOrder{}, fmt.Errorf("Sort order %s needs a column", value)
+	}
+
+	return order, nil
+}
+
+// The value a record is sorted by, parsed only once
+type sortKey struct {
+	isNumber bool
+	number   float64
+	text     string
+}
+
+// ParseFloat also reads NaN and the infinities, which are not numbers to sort by. NaN isn't even ordered
+func newSortKey(value string) sortKey {
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	isNumber := err == nil && !math.IsNaN(number) && !math.IsInf(number, 0)
+	return sortKey{isNumber, number, value}
+}
+
+// Numbers come before anything else, compared by their value, in both orders. The rest of the values are
+// compared as text
+func (k sortKey) less(other sortKey, descending bool) bool {
+	if k.isNumber != other.isNumber {
+		return k.isNumber
+	}
+	if descending {
+		k, other = other, k
+	}
+	if k.isNumber {
+		return k.number < other.number
+	}
+	return k.text < other.text
+}
+
+// Reads every batch and returns all their records sorted in a single batch. Records with the same value
+// keep the order they had in the file. This keeps the whole file in memory, so the memory needed
+// grows with the size of the file instead of being bounded by the batch size
+func sortRecords(ctx context.Context, order sortOrder, writerChannel <-chan recordBatch) (recordBatch, error) {
+	var sorted recordBatch
+	for {
+		var batch recordBatch
+		var more bool
+		select {
+		case batch, more = <-writerChannel:
+		case <-ctx.Done():
+			return recordBatch{}, ctx.Err()
+		}
+		if !more {
+			break
+		}
+
+		if batch.meta != nil {
+			sorted.meta = batch.meta
+		}
+		sorted.records = append(sorted.records, batch.records...)
+	}
+	if len(sorted.records) == 0 {
+		return sorted, nil
+	}
+
+	index := columnIndex(sorted.records[0].headers, order.column)
+	if index < 0 {
+		return recordBatch{}, fmt.Errorf("Column %s to sort by is not in the records", order.column)
+	}
+
+	keys := make([]sortKey, len(sorted.records))
+	for i, rec := range sorted.records {
+		keys[i] = newSortKey(rec.values[index])
+	}
+
+	// The keys are sorted along with the records, so they are swapped together
+	sort.Stable(recordSorter{sorted.records, keys, order.descending})
+
+	return sorted, nil
+}
+
+type recordSorter struct {
+	records    []record
+	keys       []sortKey
+	descending bool
+}
+
+func (s recordSorter) Len() int {
+	return len(s.records)
+}
+
+func (s recordSorter) Less(i, j int) bool {
+	return s.keys[i].less(s.keys[j], s.descending)
+}
+
+func (s recordSorter) Swap(i, j int) {
+	s.records[i], s.records[j] = s.records[j], s.records[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func Test_parseSortOrder(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    sortOrder
+		wantErr bool
+	}{
+		{"price", sortOrder{"price", false}, false},
+		{"price:asc", sortOrder{"price", false}, false},
+		{"price:DESC", sortOrder{"price", true}, false},
+		{"time:12:00", sortOrder{"time:12:00", false}, false},
+		{":desc", sortOrder{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseSortOrder(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseSortOrder() = %+v, %v, want %+v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_sortBy(t *testing.T) {
+	csvString := "name,price\nc,10\na,9.5\nb,\nd,10\ne,-1\nf,NaN\ng,inf\n"
+
+	tests := []struct {
+		name  string
+		order sortOrder
+		want  string
+	}{
+		{"String column", sortOrder{"name", false}, `[{"name":"a","price":"9.5"},{"name":"b","price":""},{"name":"c","price":"10"},{"name":"d","price":"10"},{"name":"e","price":"-1"},{"name":"f","price":"NaN"},{"name":"g","price":"inf"}]` + "\n"},
+		// NaN and the infinities are text, which comes after the numbers in both orders
+		{"Numeric column", sortOrder{"price", false}, `[{"name":"e","price":"-1"},{"name":"a","price":"9.5"},{"name":"c","price":"10"},{"name":"d","price":"10"},{"name":"b","price":""},{"name":"f","price":"NaN"},{"name":"g","price":"inf"}]` + "\n"},
+		{"Descending", sortOrder{"price", true}, `[{"name":"c","price":"10"},{"name":"d","price":"10"},{"name":"a","price":"9.5"},{"name":"e","price":"-1"},{"name":"g","price":"inf"},{"name":"f","price":"NaN"},{"name":"b","price":""}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertString(t, csvString, withOptions(func(f *inputFile) { f.sortBy, f.batchSize = tt.order, 2 })); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// A file written by --split-records, as the manifest lists it. FirstRecord is the position of its first record
+// among the records of every file, starting at 1, or 0 when the file has no records. It's not a line of the CSV file
+type shardInfo struct {
+	File        string `json:"file"`
+	Records     int64  `json:"records"`
+	FirstRecord int64  `json:"firstRecord"`
+}
+
+// Returns the suffix of the nth file written by --split-records, like .0001.json
+func shardSuffix(fileData inputFile, n int) string {
+	return fmt.Sprintf(".%04d%s", n, fileData.outputSuffix)
+}
+
+// Writes the records into files of at most fileData.splitRecords records each, returning how many were written.
+// Each file is a complete JSON file, with the metadata and the root key too. A file without records only gets
+// written when there are none at all, so the conversion still leaves some JSON behind. A failed conversion
+// keeps the files completed before the one it failed on
+func writeShards(ctx context.Context, fileData inputFile, writerChannel <-chan recordBatch) (int64, error) {
+	// The order is the one of every record, not the one of each file
+	if fileData.sortBy.column != "" {
+		sorted, err := sortRecords(ctx, fileData.sortBy, writerChannel)
+		if err != nil {
+			return 0, writeError(fileData, err)
+		}
+		sortedChannel := make(chan recordBatch, 1)
+		sortedChannel <- sorted
+		close(sortedChannel)
+		writerChannel = sortedChannel
+		fileData.sortBy = sortOrder{}
+	}
+
+	// Records received but not written into a file yet
+	var pending []record
+	var meta []metaField
+	more := true
+	next := func() bool {
+		for more && len(pending) == 0 {
+			if ctx.Err() != nil {
+				return false
+			}
+			var batch recordBatch
+			select {
+			case batch, more = <-writerChannel:
+			case <-ctx.Done():
+				return false
+			}
+			if batch.meta != nil {
+				meta = batch.meta
+			}
+			pending = batch.records
+		}
+		return len(pending) > 0
+	}
+
+	var shards []shardInfo
+	var total int64
+	for n := 1; next() || n == 1; n++ {
+		shardData := fileData
+		shardData.outputSuffix, shardData.shard = shardSuffix(fileData, n), n
+		output, err := createOutput(shardData)
+		if err != nil {
+			return total, err
+		}
+
+		// Feeding the records of this file only, while they are written
+		shardChannel := make(chan recordBatch)
+		go func() {
+			defer close(shardChannel)
+			batch := recordBatch{meta: meta}
+			for remaining := fileData.splitRecords; remaining > 0 && next(); {
+				count := int64(len(pending))
+				if count > remaining {
+					count = remaining
+				}
+				batch.records, pending = pending[:count], pending[count:]
+				remaining -= count
+				select {
+				case shardChannel <- batch:
+				case <-ctx.Done():
+					return
+				}
+				batch = recordBatch{}
+			}
+		}()
+
+		records, err := writeJSON(ctx, shardData, output, shardChannel, nil)
+		if ctx.Err() != nil {
+			// The feeder may be waiting to send
+			for range shardChannel {
+			}
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = output.Close()
+		}
+		if err != nil {
+			discardOutput(output)
+			return total, writeError(fileData, err)
+		}
+
+		shard := shardInfo{File: outputLocation(shardData, shardData.outputSuffix), Records: records}
+		if records > 0 {
+			shard.FirstRecord = total + 1
+		}
+		shards = append(shards, shard)
+		total += records
+	}
+	if err := ctx.Err(); err != nil {
+		return total, writeError(fileData, err)
+	}
+
+	if fileData.manifest != "" {
+		if err := writeManifest(fileData, shards); err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// Writes the manifest of the files written by --split-records, with their paths relative to the manifest
+func writeManifest(fileData inputFile, shards []shardInfo) error {
+	manifestDir := filepath.Dir(fileData.manifest)
+	for i := range shards {
+		if relative, err := filepath.Rel(manifestDir, shards[i].File); err == nil {
+			shards[i].File = filepath.ToSlash(relative)
+		}
+	}
+
+	content, err := json.MarshalIndent(shards, "", fileData.indent)
+	if err != nil {
+		return err
+	}
+	if fileData.createDirs {
+		if err := os.MkdirAll(manifestDir, 0777); err != nil {
+			return fmt.Errorf("The directory of %s can't be created: %v", fileData.manifest, err)
+		}
+	}
+	if err := fileData.outputMode.writeFile(fileData.manifest, append(content, '\n')); err != nil {
+		return err
+	}
+
+	logger.infof("Manifest of %d files written to %s", len(shards), fileData.manifest)
+
+	return nil
+}
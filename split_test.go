@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_splitRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		want     []shardInfo
+		wantJSON []string
+	}{
+		{"Last file is shorter", "id\n1\n2\n3\n4\n5\n",
+			[]shardInfo{{"data.0001.json", 2, 1}, {"data.0002.json", 2, 3}, {"data.0003.json", 1, 5}},
+			[]string{`[{"id":"1"},{"id":"2"}]`, `[{"id":"3"},{"id":"4"}]`, `[{"id":"5"}]`}},
+		{"Exact multiple", "id\n1\n2\n3\n4\n",
+			[]shardInfo{{"data.0001.json", 2, 1}, {"data.0002.json", 2, 3}},
+			[]string{`[{"id":"1"},{"id":"2"}]`, `[{"id":"3"},{"id":"4"}]`}},
+		{"No records", "id\n",
+			[]shardInfo{{"data.0001.json", 0, 0}},
+			[]string{`[]`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			csvPath := filepath.Join(dir, "data.csv")
+			check(ioutil.WriteFile(csvPath, []byte(tt.data), 0666))
+			manifestPath := filepath.Join(dir, "shards.json")
+
+			got := mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.splitRecords, f.manifest = csvPath, 2, manifestPath }))
+			var want int64
+			for _, shard := range tt.want {
+				want += shard.Records
+			}
+			if got != want {
+				t.Errorf("convertFile() = %d, want %d", got, want)
+			}
+
+			// The manifest lists the files that were written, and nothing else
+			content, err := ioutil.ReadFile(manifestPath)
+			check(err)
+			var shards []shardInfo
+			check(json.Unmarshal(content, &shards))
+			if !reflect.DeepEqual(shards, tt.want) {
+				t.Errorf("manifest = %+v, want %+v", shards, tt.want)
+			}
+			if !strings.Contains(string(content), `"firstRecord"`) {
+				t.Errorf("manifest %s doesn't have the position of the first records", content)
+			}
+			for i, shard := range shards {
+				got, err := ioutil.ReadFile(filepath.Join(dir, shard.File))
+				check(err)
+				if string(got) != tt.wantJSON[i]+"\n" {
+					t.Errorf("%s = %s, want %s", shard.File, got, tt.wantJSON[i])
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dir, "data.json")); !os.IsNotExist(err) {
+				t.Errorf("data.json got error %v, want it not to exist", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The placeholders of --output-template look like {name}
+var templatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// Validates the placeholders of --output-template. {n} is the number of the file written by --split-records,
+// so it's needed with it, for every file to get its own name, and it can't be used without it
+func checkOutputTemplate(template string, split bool) error {
+	for _, placeholder := range templatePlaceholder.FindAllString(template, -1) {
+		switch placeholder {
+		case "{name}", "{date}", "{sep}":
+		case "{n}":
+			if !split {
+				return errors.New("The {n} placeholder of the output template needs --split-records")
+			}
+		default:
+			return fmt.Errorf("Unknown placeholder %s in the output template. Use {name}, {date}, {sep} or {n}", placeholder)
+		}
+	}
+
+	if split && !strings.Contains(template, "{n}") {
+		return errors.New("The output template needs the {n} placeholder with --split-records")
+	}
+	if filepath.IsAbs(template) {
+		return errors.New("The output template must be a relative path, use --output-dir to write the files somewhere else")
+	}
+
+	return nil
+}
+
+// Returns the name of the separator of a file for the {sep} placeholder, like comma or tab
+func separatorName(fileData inputFile) string {
+	if separator := multiSeparator(fileData); separator != "" {
+		return separator
+	}
+
+	comma := fileSeparator(fileData, 0, false)
+	for name, r := range separatorNames {
+		if r == comma {
+			return name
+		}
+	}
+	return string(comma)
+}
+
+// Returns the location of the file written for a CSV file with --output-template, which is relative to the
+// directory of the CSV file. {name} is the name of the CSV file without its extension
+func renderOutputTemplate(fileData inputFile) string {
+	name := filepath.Base(fileData.filepath)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	replacer := strings.NewReplacer(
+		"{name}", name,
+		"{date}", fileData.runDate,
+		"{sep}", separatorName(fileData),
+		"{n}", fmt.Sprintf("%04d", fileData.shard),
+	)
+	return filepath.Join(filepath.Dir(fileData.filepath), replacer.Replace(fileData.outputTemplate))
+}
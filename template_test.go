@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_checkOutputTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		split    bool
+		wantErr  bool
+	}{
+		{"Every placeholder", "{name}_{date}_{sep}.json", false, false},
+		{"Split files", "{name}.{n}.json", true, false},
+		{"Subdirectory", "{date}/{name}.json", false, false},
+		{"Unknown placeholder", "{name}_{time}.json", false, true},
+		{"Number without split", "{name}.{n}.json", false, true},
+		{"Split without number", "{name}.json", true, true},
+		{"Absolute path", "/tmp/{name}.json", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkOutputTemplate(tt.template, tt.split); (err != nil) != tt.wantErr {
+				t.Errorf("checkOutputTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_renderOutputTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileData inputFile
+		want     string
+	}{
+		{"Name and date", withOptions(func(f *inputFile) {
+			f.filepath, f.outputTemplate, f.runDate = "data/a.csv", "{name}_{date}.json", "2024-05-01"
+		}), filepath.Join("data", "a_2024-05-01.json")},
+		{"Separator of a TSV file", withOptions(func(f *inputFile) { f.filepath, f.outputTemplate = "a.tsv", "{name}.{sep}.json" }), "a.tab.json"},
+		{"Given separator", withOptions(func(f *inputFile) {
+			f.filepath, f.outputTemplate, f.separator, f.separatorGiven = "a.csv", "{name}.{sep}.json", ";", true
+		}), "a.semicolon.json"},
+		{"Split file", withOptions(func(f *inputFile) { f.filepath, f.outputTemplate, f.shard = "a.b.csv", "{name}-{n}.json", 3 }), "a.b-0003.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderOutputTemplate(tt.fileData); got != tt.want {
+				t.Errorf("renderOutputTemplate() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_outputTemplate(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("id\n1\n2\n"), 0666))
+
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.outputTemplate, f.splitRecords = csvPath, "{name}-{n}.json", 1 }))
+	for _, name := range []string{"data-0001.json", "data-0002.json"} {
+		if _, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s got error: %v", name, err)
+		}
+	}
+
+	// Files that would get the same name are rejected before anything is converted
+	if _, err := parseArgs("--output-template=out.json", "a.csv", filepath.Join("b", "b.csv")); err != nil {
+		t.Errorf("getFileData() error = %v, want the files to be written in different directories", err)
+	}
+	if _, err := parseArgs("--output-template=out.json", "a.csv", "b.csv"); err == nil {
+		t.Errorf("getFileData() error = nil, want both files written to out.json")
+	}
+	if _, err := parseArgs("--output-dir=out", filepath.Join("east", "a.csv"), filepath.Join("west", "a.csv")); err != nil {
+		t.Errorf("getFileData() error = %v, want the directories kept under out", err)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Counts the bytes read from a file for --timing. The writer go-routine reads the count, so it's atomic
+type countingReader struct {
+	r     io.Reader
+	count *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.count, int64(n))
+	return n, err
+}
+
+// Returns the summary of --timing, like "data.csv: 1000 records and 0.05 MB in 12ms, 83333 records/s and 4.07 MB/s".
+// The bytes are the ones read from the file, so a gzip file counts with its compressed size
+func timingSummary(path string, elapsed time.Duration, records int64, bytesRead int64) string {
+	megabytes := float64(bytesRead) / (1 << 20)
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = time.Nanosecond.Seconds()
+	}
+
+	return fmt.Sprintf("%s: %d records and %.2f MB in %s, %.0f records/s and %.2f MB/s",
+		path, records, megabytes, elapsed.Round(time.Millisecond), float64(records)/seconds, megabytes/seconds)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_timingSummary(t *testing.T) {
+	tests := []struct {
+		name      string
+		elapsed   time.Duration
+		records   int64
+		bytesRead int64
+		want      string
+	}{
+		{"Two seconds", 2 * time.Second, 1000, 3 << 20, "data.csv: 1000 records and 3.00 MB in 2s, 500 records/s and 1.50 MB/s"},
+		{"Rounded duration", 1500*time.Millisecond + 300*time.Microsecond, 3, 1 << 19, "data.csv: 3 records and 0.50 MB in 1.5s, 2 records/s and 0.33 MB/s"},
+		{"No time at all", 0, 0, 0, "data.csv: 0 records and 0.00 MB in 0s, 0 records/s and 0.00 MB/s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := timingSummary("data.csv", tt.elapsed, tt.records, tt.bytesRead); got != tt.want {
+				t.Errorf("timingSummary() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_timing(t *testing.T) {
+	csvString := "id,name\n1,a\n2,b\n"
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte(csvString), 0666))
+
+	var out bytes.Buffer
+	actualOut := logger.out
+	logger.out = &out
+	defer func() { logger.out = actualOut }()
+
+	// The numbers depend on the machine, but the records and the bytes read don't
+	mustConvertFile(t, withOptions(func(f *inputFile) { f.filepath, f.timing = csvPath, true }))
+	summary := regexp.MustCompile(`info: .*data\.csv: 2 records and 0\.00 MB in \S+, \d+ records/s and \d+\.\d\d MB/s\n`)
+	if !summary.MatchString(out.String()) {
+		t.Errorf("logged %q, want the summary of the conversion", out.String())
+	}
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The types a value can be written as, by their position in registeredTypes
+type valueType int
+
+const (
+	typeString valueType = iota
+	typeNumber
+	typeBoolean
+	typeHumanNumber
+	typeCurrency
+	typePoint
+	typeJSON
+)
+
+// Appends the JSON of a value as a type, returning false when the value is not of the type
+type valueConverter func(dst []byte, value string) ([]byte, bool)
+
+// A type of --type-map. The converter is made for each conversion, since it can depend on its options
+type registeredType struct {
+	name         string
+	newConverter func(fileData inputFile) valueConverter
+}
+
+// The types of --type-map, in the order of their valueType. A new type only needs an entry here, and the
+// help of --type-map lists it
+var registeredTypes = []registeredType{
+	{"string", func(fileData inputFile) valueConverter {
+		return func(dst []byte, value string) ([]byte, bool) {
+			return fileData.escaping.appendString(dst, value), true
+		}
+	}},
+	{"number", func(fileData inputFile) valueConverter {
+		return func(dst []byte, value string) ([]byte, bool) {
+			if fileData.decimalComma {
+				number, ok := parseDecimalComma(value)
+				if !ok {
+					return dst, false
+				}
+				value = number
+			}
+			if !isJSONNumber(value) {
+				return dst, false
+			}
+			return fileData.floatFormat.appendNumber(dst, value), true
+		}
+	}},
+	{"boolean", func(fileData inputFile) valueConverter {
+		return func(dst []byte, value string) ([]byte, bool) {
+			if isJSONBoolean(value) {
+				return append(dst, value...), true
+			}
+			if b, ok := fileData.booleanTokens[strings.ToLower(value)]; ok {
+				return strconv.AppendBool(dst, b), true
+			}
+			return dst, false
+		}
+	}},
+	{"humannumber", func(fileData inputFile) valueConverter {
+		return func(dst []byte, value string) ([]byte, bool) {
+			number, ok := parseHumanNumber(value, fileData.percentMode == "raw")
+			if !ok {
+				return dst, false
+			}
+			return fileData.floatFormat.appendNumber(dst, number), true
+		}
+	}},
+	{"currency", func(fileData inputFile) valueConverter {
+		return func(dst []byte, value string) ([]byte, bool) {
+			amount, currency, ok := parseCurrency(value, fileData.currencySymbols, fileData.decimalComma)
+			if !ok {
+				return dst, false
+			}
+			if fileData.currencyAsNumber {
+				return fileData.floatFormat.appendNumber(dst, amount), true
+			}
+
+			dst = fileData.floatFormat.appendNumber(append(dst, `{"amount":`...), amount)
+			dst = append(dst, `,"currency":`...)
+			if currency == "" {
+				dst = append(dst, "null"...)
+			} else {
+				dst = fileData.escaping.appendString(dst, currency)
+			}
+			return append(dst, '}'), true
+		}
+	}},
+	{"point", func(fileData inputFile) valueConverter {
+		return func(dst []byte, value string) ([]byte, bool) {
+			separatorIndex := strings.Index(value, ",")
+			if separatorIndex < 0 {
+				return dst, false
+			}
+			lat, lon, err := parseCoordinates(value[:separatorIndex], value[separatorIndex+1:])
+			if err != nil {
+				return dst, false
+			}
+
+			dst = append(dst, `{"type":"Point","coordinates":[`...)
+			dst = fileData.floatFormat.appendNumber(append(fileData.floatFormat.appendNumber(dst, lon), ','), lat)
+			return append(dst, "]}"...), true
+		}
+	}},
+	{"json", func(fileData inputFile) valueConverter {
+		return func(dst []byte, value string) ([]byte, bool) {
+			if checkEmbeddedJSON(value, fileData.parseJSONDepth, fileData.parseJSONBytes) != nil {
+				return dst, false
+			}
+			// The value is written compact and escaped like the rest of the file, in the order of its keys
+			return fileData.escaping.appendEncoded(dst, json.RawMessage(value)), true
+		}
+	}},
+}
+
+// Validates the latitude and the longitude of a point, returning them without the spaces around them.
+// Like in GeoJSON, the latitude goes from -90 to 90 and the longitude from -180 to 180
+func parseCoordinates(lat string, lon string) (string, string, error) {
+	lat, lon = strings.TrimSpace(lat), strings.TrimSpace(lon)
+	for _, coordinate := range []struct {
+		name  string
+		value string
+		limit float64
+	}{{"latitude", lat, 90}, {"longitude", lon, 180}} {
+		if coordinate.value == "" {
+			return "", "", fmt.Errorf("The %s is empty", coordinate.name)
+		}
+		number, err := strconv.ParseFloat(coordinate.value, 64)
+		if err != nil || !isJSONNumber(coordinate.value) {
+			return "", "", fmt.Errorf("The %s %s is not a number", coordinate.name, coordinate.value)
+		}
+		if number < -coordinate.limit || number > coordinate.limit {
+			return "", "", fmt.Errorf("The %s %s is not between %v and %v", coordinate.name, coordinate.value, -coordinate.limit, coordinate.limit)
+		}
+	}
+
+	return lat, lon, nil
+}
+
+// The codes of the currency symbols the currency type knows. --currency-symbols adds others, or changes these
+var defaultCurrencySymbols = map[string]string{"$": "USD", "€": "EUR", "£": "GBP", "¥": "JPY", "₹": "INR"}
+
+// Parses --currency-symbols, a comma separated list of SYMBOL:CODE, into the default symbols with these on top.
+// The codes are ISO 4217 codes of three capital letters, like USD
+func parseCurrencySymbols(list string) (map[string]string, error) {
+	symbols := make(map[string]string, len(defaultCurrencySymbols))
+	for symbol, code := range defaultCurrencySymbols {
+		symbols[symbol] = code
+	}
+
+	for _, entry := range splitColumnList(list, ",") {
+		separatorIndex := strings.LastIndex(entry, ":")
+		if separatorIndex <= 0 {
+			return nil, fmt.Errorf("Currency symbol %s must be written as SYMBOL:CODE", entry)
+		}
+		code := strings.TrimSpace(entry[separatorIndex+1:])
+		if !isCurrencyCode(code) {
+			return nil, fmt.Errorf("Currency code %s must be three capital letters, like USD", code)
+		}
+		symbols[strings.TrimSpace(entry[:separatorIndex])] = code
+	}
+
+	return symbols, nil
+}
+
+// Validates if a text is written like an ISO 4217 code, with three capital letters
+func isCurrencyCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'A' || s[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Removes the currency at the start or the end of a value, returning its code. The currency is one of the symbols,
+// the longest one when several match, or an ISO code. An empty code means that the value has no currency
+func cutCurrency(value string, symbols map[string]string) (string, string) {
+	symbol := ""
+	for candidate := range symbols {
+		if len(candidate) > len(symbol) && (strings.HasPrefix(value, candidate) || strings.HasSuffix(value, candidate)) {
+			symbol = candidate
+		}
+	}
+	switch {
+	case symbol != "" && strings.HasPrefix(value, symbol):
+		return strings.TrimSpace(value[len(symbol):]), symbols[symbol]
+	case symbol != "":
+		return strings.TrimSpace(value[:len(value)-len(symbol)]), symbols[symbol]
+	case len(value) > 3 && isCurrencyCode(value[:3]):
+		return strings.TrimSpace(value[3:]), value[:3]
+	case len(value) > 3 && isCurrencyCode(value[len(value)-3:]):
+		return strings.TrimSpace(value[:len(value)-3]), value[len(value)-3:]
+	}
+	return value, ""
+}
+
+// Parses a value of the currency type, like $1,299.99, EUR 45,00 or (1,299.99) for a negative amount, into the
+// digits of a JSON number and the code of its currency. The amount is read with a decimal point first, and with a
+// decimal comma when that fails, or the other way around with --decimal-comma
+func parseCurrency(value string, symbols map[string]string, decimalComma bool) (string, string, bool) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")")
+	if negative {
+		value = strings.TrimSpace(value[1 : len(value)-1])
+	}
+
+	// The minus can go before or after the currency, like -$5 or $-5
+	minus := strings.HasPrefix(value, "-")
+	value, currency := cutCurrency(strings.TrimPrefix(value, "-"), symbols)
+	if strings.HasPrefix(value, "-") {
+		minus, value = !minus, value[1:]
+	}
+	if (minus && negative) || (!minus && strings.HasPrefix(value, "-")) || value == "" {
+		return "", "", false
+	}
+
+	points := []byte{'.', ','}
+	if decimalComma {
+		points = []byte{',', '.'}
+	}
+	for _, point := range points {
+		group := byte(',')
+		if point == ',' {
+			group = '.'
+		}
+		if amount, ok := parseGroupedNumber(value, point, group); ok && !strings.HasPrefix(amount, "-") {
+			if minus || negative {
+				amount = "-" + amount
+			}
+			return amount, currency, true
+		}
+	}
+	return "", "", false
+}
+
+// The multipliers of the suffixes of humannumber, as powers of ten. A lowercase m could be milli as well as
+// million, so it's not one of them
+var humanSuffixes = map[byte]int{'k': 3, 'K': 3, 'M': 6, 'B': 9}
+
+// Parses a number of the humannumber type, like 12%, 3.4k or 1.2M, into the digits of a JSON number. A percent
+// is a fraction, 0.12 for 12%, unless raw keeps it as the number written. The digits are moved rather than
+// multiplied, so 3.4k is exactly 3400. Plain numbers are returned as they are
+func parseHumanNumber(value string, raw bool) (string, bool) {
+	value = strings.TrimSpace(value)
+	if isJSONNumber(value) {
+		return value, true
+	}
+	if len(value) < 2 {
+		return "", false
+	}
+
+	places, found := humanSuffixes[value[len(value)-1]]
+	if value[len(value)-1] == '%' {
+		places, found = -2, true
+		if raw {
+			places = 0
+		}
+	}
+	number := strings.TrimSpace(value[:len(value)-1])
+	if !found || !isJSONNumber(number) || strings.ContainsAny(number, "eE") {
+		return "", false
+	}
+
+	return shiftDecimalPoint(number, places), true
+}
+
+// Moves the decimal point of a JSON number without an exponent by a number of places, to the right when positive
+func shiftDecimalPoint(number string, places int) string {
+	sign := ""
+	if strings.HasPrefix(number, "-") {
+		sign, number = "-", number[1:]
+	}
+	digits, point := number, len(number)
+	if i := strings.IndexByte(number, '.'); i >= 0 {
+		digits, point = number[:i]+number[i+1:], i
+	}
+
+	point += places
+	if point <= 0 {
+		digits, point = strings.Repeat("0", 1-point)+digits, 1
+	} else if point > len(digits) {
+		digits += strings.Repeat("0", point-len(digits))
+	}
+
+	integer := strings.TrimLeft(digits[:point], "0")
+	if integer == "" {
+		integer = "0"
+	}
+	if fraction := strings.TrimRight(digits[point:], "0"); fraction != "" {
+		return sign + integer + "." + fraction
+	}
+	return sign + integer
+}
+
+func (t valueType) String() string {
+	return registeredTypes[t].name
+}
+
+// Returns the names of the types of --type-map as a list, like "string, number or boolean"
+func typeNames() string {
+	names := make([]string, len(registeredTypes))
+	for i, registered := range registeredTypes {
+		names[i] = registered.name
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+func parseValueType(name string) (valueType, error) {
+	for i, registered := range registeredTypes {
+		if strings.EqualFold(name, registered.name) {
+			return valueType(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unknown type %s. Use %s", name, typeNames())
+}
+
+// Parses a list of column types written as COLUMN:type,COLUMN:type. An empty list gives a nil map
+func parseTypeMap(list string) (map[string]valueType, error) {
+	var typeMap map[string]valueType
+	for _, entry := range splitColumnList(list, ",") {
+		separatorIndex := strings.LastIndex(entry, ":")
+		if separatorIndex <= 0 {
+			return nil, fmt.Errorf("Column type %s must be written as COLUMN:type", entry)
+		}
+
+		t, err := parseValueType(strings.TrimSpace(entry[separatorIndex+1:]))
+		if err != nil {
+			return nil, err
+		}
+		if typeMap == nil {
+			typeMap = make(map[string]valueType)
+		}
+		typeMap[strings.TrimSpace(entry[:separatorIndex])] = t
+	}
+
+	return typeMap, nil
+}
+
+// Validates if a value is written as a JSON number. This is stricter than strconv, which also takes
+// things like "Inf", "0x1p3" or "+1" that are not valid JSON
+func isJSONNumber(s string) bool {
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+
+	// The integer part has no leading zeros
+	switch {
+	case i < len(s) && s[i] == '0':
+		i++
+	case i < len(s) && s[i] >= '1' && s[i] <= '9':
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	default:
+		return false
+	}
+
+	if i < len(s) && s[i] == '.' {
+		i++
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+
+	return i == len(s)
+}
+
+func isJSONBoolean(s string) bool {
+	return s == "true" || s == "false"
+}
+
+// Parses the tokens of --bool-true and --bool-false into the boolean each of them stands for. The tokens are
+// compared without case, so they are kept in lower case. An empty list of both gives a nil map
+func parseBooleanTokens(trueList string, falseList string) (map[string]bool, error) {
+	var tokens map[string]bool
+	for _, list := range []struct {
+		tokens string
+		value  bool
+	}{{trueList, true}, {falseList, false}} {
+		for _, token := range splitColumnList(list.tokens, ",") {
+			token = strings.ToLower(token)
+			if value, seen := tokens[token]; seen && value != list.value {
+				return nil, fmt.Errorf("The boolean token %s can't be both true and false", token)
+			}
+			if tokens == nil {
+				tokens = make(map[string]bool)
+			}
+			tokens[token] = list.value
+		}
+	}
+
+	return tokens, nil
+}
+
+// How --float-format writes the numbers with a fraction or an exponent. The zero value writes them as they are in the file
+type floatFormat struct {
+	verb      byte // 'f', 'e' or 'g', like in strconv.FormatFloat
+	precision int  // Digits after the point, or -1 for as few as give the same number back
+}
+
+// The printf verbs --float-format takes, like %.2f or %g
+var floatVerb = regexp.MustCompile(`^%(?:\.(\d+))?([feg])$`)
+
+// Parses --float-format, which is a printf verb for floats, like %.2f, or fixed:N for N digits after the point.
+// Like with printf, %f and %e have 6 digits unless they say otherwise, and %g as few as needed
+func parseFloatFormat(value string) (floatFormat, error) {
+	if value == "" {
+		return floatFormat{}, nil
+	}
+
+	if strings.HasPrefix(value, "fixed:") {
+		digits, err := strconv.Atoi(strings.TrimPrefix(value, "fixed:"))
+		if err != nil || digits < 0 {
+			return floatFormat{}, fmt.Errorf("The float format %s must have a number of digits, like fixed:2", value)
+		}
+		return floatFormat{'f', digits}, nil
+	}
+
+	match := floatVerb.FindStringSubmatch(value)
+	if match == nil {
+		return floatFormat{}, fmt.Errorf("Unknown float format %s. Use a verb like %%.2f, %%e or %%g, or fixed:N", value)
+	}
+	format := floatFormat{match[2][0], -1}
+	if match[1] != "" {
+		format.precision, _ = strconv.Atoi(match[1])
+	} else if format.verb != 'g' {
+		format.precision = 6
+	}
+	return format, nil
+}
+
+// Appends a number of the file with the format, when it has a fraction or an exponent. Integers and the numbers
+// too big for a float64 are written as they are
+func (f floatFormat) appendNumber(dst []byte, value string) []byte {
+	if f.verb == 0 || !strings.ContainsAny(value, ".eE") {
+		return append(dst, value...)
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return append(dst, value...)
+	}
+	return strconv.AppendFloat(dst, number, f.verb, f.precision, 64)
+}
+
+// Parses a number written with a decimal comma, like 1.234,56, into the digits of a JSON number, like 1234.56.
+// The dots can only group the digits of the integer part by three, so 1.5 is not a number this way
+func parseDecimalComma(value string) (string, bool) {
+	return parseGroupedNumber(value, ',', '.')
+}
+
+// Parses a number written with a decimal point and a separator grouping the digits of its integer part by three,
+// like 1,234.56 or 1.234,56, into the digits of a JSON number
+func parseGroupedNumber(value string, point byte, group byte) (string, bool) {
+	sign := ""
+	if strings.HasPrefix(value, "-") {
+		sign, value = "-", value[1:]
+	}
+
+	integer, fraction := value, ""
+	if i := strings.IndexByte(value, point); i >= 0 {
+		integer, fraction = value[:i], value[i+1:]
+		if fraction == "" || strings.ContainsAny(fraction, ".,") {
+			return "", false
+		}
+	}
+	if groups := strings.Split(integer, string(group)); len(groups) > 1 {
+		for i, group := range groups {
+			if len(group) != 3 && (i > 0 || len(group) == 0 || len(group) > 3) {
+				return "", false
+			}
+		}
+		integer = strings.Join(groups, "")
+	}
+
+	number := sign + integer
+	if fraction != "" {
+		number += "." + fraction
+	}
+	if !isJSONNumber(number) || strings.ContainsAny(number, "eE") {
+		return "", false
+	}
+	return number, true
+}
+
+// Returns a function giving the type of --type-map of a column, if it has one. With --fuzzy-columns, a column
+// without a type of its own gets the one of the name it matches loosely. The type of every column is only looked
+// for once
+func getColumnTypes(fileData inputFile) func(column string) (valueType, bool) {
+	if !fileData.fuzzyColumns || len(fileData.typeMap) == 0 {
+		return func(column string) (valueType, bool) {
+			t, mapped := fileData.typeMap[column]
+			return t, mapped
+		}
+	}
+
+	fuzzyTypes := make(map[string]valueType, len(fileData.typeMap))
+	for name, t := range fileData.typeMap {
+		fuzzyTypes[fuzzyColumnName(name)] = t
+	}
+	columnTypes := make(map[string]valueType)
+	looked := make(map[string]bool)
+	return func(column string) (valueType, bool) {
+		if t, mapped := fileData.typeMap[column]; mapped {
+			return t, true
+		}
+		if !looked[column] {
+			looked[column] = true
+			if t, mapped := fuzzyTypes[fuzzyColumnName(column)]; mapped {
+				logger.debugf("Column %s gets the type %s of --type-map", column, t)
+				columnTypes[column] = t
+			}
+		}
+		t, mapped := columnTypes[column]
+		return t, mapped
+	}
+}
+
+// Returns a function appending the JSON of the values of a column. With --typed the type is inferred from
+// each value, and --type-map gives the type of a column, which takes precedence. --stringify writes everything
+// as strings anyway, but the values still get checked against the types of --type-map.
+// Values that don't fit the type of their column are written as strings, with a warning.
+// Besides true and false, the tokens of --bool-true and --bool-false are booleans too, even if they look like numbers
+func getValueEncoder(fileData inputFile) func(dst []byte, column string, value string) []byte {
+	typed := fileData.typed || len(fileData.typeMap) > 0
+	appendString := fileData.escaping.appendString
+
+	converters := make([]valueConverter, len(registeredTypes))
+	for i, registered := range registeredTypes {
+		converters[i] = registered.newConverter(fileData)
+	}
+	var scratch []byte
+
+	// With --decimal-comma, a column is read with a decimal comma until one of its values is only a number with a
+	// decimal point, like 1.5. Its numbers are read with a decimal point from then on, instead of cell by cell
+	var pointConverters []valueConverter
+	pointColumns := make(map[string]bool)
+	if fileData.decimalComma {
+		pointData := fileData
+		pointData.decimalComma = false
+		pointConverters = make([]valueConverter, len(registeredTypes))
+		for i, registered := range registeredTypes {
+			pointConverters[i] = registered.newConverter(pointData)
+		}
+	}
+	convert := func(t valueType, dst []byte, column string, value string) ([]byte, bool) {
+		if pointColumns[column] {
+			return pointConverters[t](dst, value)
+		}
+		converted, fits := converters[t](dst, value)
+		if fits || pointConverters == nil {
+			return converted, fits
+		}
+		if converted, fits = pointConverters[t](dst, value); fits {
+			pointColumns[column] = true
+			if !fileData.silent {
+				logger.warnf("Value %q of column %s has a decimal point. Reading the numbers of the column with a decimal point", value, column)
+			}
+		}
+		return converted, fits
+	}
+
+	columnType := getColumnTypes(fileData)
+
+	return func(dst []byte, column string, value string) []byte {
+		// With --null-value, the cells with that value are written as null instead of as a string
+		if fileData.nullValueGiven && value == fileData.nullValue {
+			return append(dst, "null"...)
+		}
+
+		if !typed {
+			return appendString(dst, value)
+		}
+
+		// Empty values stay empty strings, but a point without coordinates, or a cell without JSON, is null
+		t, mapped := columnType(column)
+		if value == "" {
+			if mapped && (t == typePoint || t == typeJSON) {
+				return append(dst, "null"...)
+			}
+			return appendString(dst, value)
+		}
+		if !mapped {
+			if !fileData.typed {
+				return appendString(dst, value)
+			}
+			// The type inferred is the last of these that the value fits, so the tokens of --bool-true and
+			// --bool-false are booleans even if they look like numbers
+			t = typeString
+			for _, candidate := range []valueType{typeNumber, typeBoolean} {
+				var fits bool
+				if scratch, fits = convert(candidate, scratch[:0], column, value); fits {
+					t = candidate
+				}
+			}
+		}
+
+		converted, fits := convert(t, dst, column, value)
+		if !fits && !fileData.silent {
+			logger.warnf("Value %q of column %s is not a %s. Writing it as a string", value, column, t)
+		}
+
+		if !fits || fileData.stringify {
+			return appendString(dst, value)
+		}
+		return converted
+	}
+}
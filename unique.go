@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Number of duplicated values shown in the report of a column
+const maxDuplicateExamples = 5
+
+// A value found again after its first line
+type duplicateValue struct {
+	value     string
+	line      int
+	firstLine int
+}
+
+// Separates the values of a composite key. It's a control character, which is not expected in the data,
+// but values having it are still escaped, so different values never build the same key
+const keySeparator = '\x1f'
+
+// Appends the key made by the values of the given columns, each of them followed by keySeparator
+func appendKey(dst []byte, line []string, indexes []int) []byte {
+	for _, i := range indexes {
+		for j := 0; j < len(line[i]); j++ {
+			if c := line[i][j]; c == '\\' || c == keySeparator {
+				dst = append(dst, '\\')
+			}
+			dst = append(dst, line[i][j])
+		}
+		dst = append(dst, keySeparator)
+	}
+
+	return dst
+}
+
+// Looks for repeated values in a column, or in a combination of columns, for --unique-check. Only a hash of
+// every key is kept, so the memory doesn't depend on the size of the values. Two different keys with the same hash
+// would be seen as a duplicate, which with 64 bits is very unlikely
+type uniqueCheck struct {
+	column     string // The name of the column, or the names of the columns of a composite key
+	columns    []string
+	indexes    []int
+	skipEmpty  bool           // Keys with an empty value are not checked, instead of being values like any other
+	seen       map[uint64]int // Line where every key was first found
+	duplicates int64
+	examples   []duplicateValue
+	key        []byte // Reused between lines to build the keys
+}
+
+// Creates the check of a key. indexes holds the position of each of the columns
+func newUniqueCheck(columns []string, indexes []int, skipEmpty bool) *uniqueCheck {
+	return &uniqueCheck{column: strings.Join(columns, " + "), columns: columns, indexes: indexes, skipEmpty: skipEmpty, seen: make(map[uint64]int)}
+}
+
+// Checks the key in the last line read. An empty value is a value like any other, unless skipEmpty says a key
+// with one can't identify anything, and so is not checked
+func (u *uniqueCheck) add(reader recordReader, line []string) {
+	for n, i := range u.indexes {
+		// A line with missing columns is left for processLine to skip
+		if i >= len(line) {
+			return
+		}
+
+		if line[i] == "" && u.skipEmpty {
+			lineNumber, _ := reader.FieldPos(i)
+			if len(u.indexes) == 1 {
+				logger.warnf("Line %d: column %s is empty. It can't be checked for duplicates", lineNumber, u.column)
+			} else {
+				logger.warnf("Line %d: column %s of the key %s is empty. It can't be checked for duplicates", lineNumber, u.columns[n], u.column)
+			}
+			return
+		}
+	}
+
+	u.key = appendKey(u.key[:0], line, u.indexes)
+	h := fnv.New64a()
+	h.Write(u.key)
+	sum := h.Sum64()
+
+	lineNumber, _ := reader.FieldPos(u.indexes[0])
+	firstLine, seen := u.seen[sum]
+	if !seen {
+		u.seen[sum] = lineNumber
+		return
+	}
+
+	u.duplicates++
+	if len(u.examples) < maxDuplicateExamples {
+		u.examples = append(u.examples, duplicateValue{u.describe(line), lineNumber, firstLine})
+	}
+}
+
+// Shows the key of a line, naming every column of a composite key
+func (u *uniqueCheck) describe(line []string) string {
+	if len(u.indexes) == 1 {
+		return fmt.Sprintf("%q", line[u.indexes[0]])
+	}
+
+	parts := make([]string, len(u.indexes))
+	for n, i := range u.indexes {
+		parts[n] = fmt.Sprintf("%s=%q", u.columns[n], line[i])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Logs what was found, returning whether the column is unique
+func (u *uniqueCheck) report() bool {
+	if u.duplicates == 0 {
+		logger.infof("Column %s is unique", u.column)
+		return true
+	}
+
+	logger.warnf("Column %s has %d duplicated values", u.column, u.duplicates)
+	for _, duplicate := range u.examples {
+		logger.warnf("Line %d: value %s of column %s was already on line %d", duplicate.line, duplicate.value, u.column, duplicate.firstLine)
+	}
+
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Runs the unique checks over every line of a CSV file
+func checkUnique(csvString string, checks ...*uniqueCheck) {
+	reader := csv.NewReader(strings.NewReader(csvString))
+	reader.Read()
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			return
+		}
+		check(err)
+		for _, u := range checks {
+			u.add(reader, line)
+		}
+	}
+}
+
+func Test_uniqueCheck(t *testing.T) {
+	ids, emails := newUniqueCheck([]string{"id"}, []int{0}, false), newUniqueCheck([]string{"email"}, []int{1}, false)
+	checkUnique("id,email\n1,a@x.com\n2,b@x.com\n3,a@x.com\n4,\"a@x.com\"\n5,c@x.com\n", ids, emails)
+
+	if ids.duplicates != 0 {
+		t.Errorf("id has %d duplicates, want none", ids.duplicates)
+	}
+	want := []duplicateValue{{`"a@x.com"`, 4, 2}, {`"a@x.com"`, 5, 2}}
+	if emails.duplicates != 2 || !reflect.DeepEqual(emails.examples, want) {
+		t.Errorf("email has %d duplicates %v, want 2 duplicates %v", emails.duplicates, emails.examples, want)
+	}
+
+	// The report is only a warning, so nothing fails unless --unique-strict is given
+	var out bytes.Buffer
+	actualOut := logger.out
+	logger.out = &out
+	defer func() { logger.out = actualOut }()
+
+	if !ids.report() || emails.report() {
+		t.Errorf("report() = %v and %v, want true for id and false for email", ids.report(), emails.report())
+	}
+	if !strings.Contains(out.String(), `warn: Line 4: value "a@x.com" of column email was already on line 2`) {
+		t.Errorf("report() logged %q, want the duplicated email with its lines", out.String())
+	}
+}
+
+func Test_uniqueCheckCompositeKey(t *testing.T) {
+	var out bytes.Buffer
+	actualOut := logger.out
+	logger.out = &out
+	defer func() { logger.out = actualOut }()
+
+	// The last two lines would build the same key if the values were simply joined
+	csvString := "region,order_id\nus,1\neu,1\nus,1\n,2\na\x1fb,c\na,b\x1fc\n,2\n"
+	key := newUniqueCheck([]string{"region", "order_id"}, []int{0, 1}, false)
+	checkUnique(csvString, key)
+
+	// An empty value is a value like any other
+	want := []duplicateValue{{`region="us", order_id="1"`, 4, 2}, {`region="", order_id="2"`, 8, 5}}
+	if key.duplicates != 2 || !reflect.DeepEqual(key.examples, want) {
+		t.Errorf("key has %d duplicates %v, want 2 duplicates %v", key.duplicates, key.examples, want)
+	}
+
+	// Unless the keys with one are skipped
+	key = newUniqueCheck([]string{"region", "order_id"}, []int{0, 1}, true)
+	checkUnique(csvString, key)
+	if want := want[:1]; key.duplicates != 1 || !reflect.DeepEqual(key.examples, want) {
+		t.Errorf("key skipping the empty values has %d duplicates %v, want 1 duplicate %v", key.duplicates, key.examples, want)
+	}
+	if !strings.Contains(out.String(), "warn: Line 5: column region of the key region + order_id is empty") {
+		t.Errorf("add() logged %q, want the empty column of the key", out.String())
+	}
+}
+
+func Test_uniqueKeyConversion(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	check(ioutil.WriteFile(csvPath, []byte("\"region, zone\",order_id\nus,1\neu,1\nus,1\n"), 0666))
+
+	tests := []struct {
+		name     string
+		fileData inputFile
+		wantErr  string
+	}{
+		{"Column with a comma", withOptions(func(f *inputFile) { f.uniqueColumns, f.uniqueStrict = []string{"region, zone"}, true }), "Column region, zone is not unique"},
+		{"Composite key", withOptions(func(f *inputFile) { f.uniqueKey, f.uniqueStrict = []string{"region, zone", "order_id"}, true }), "Column region, zone + order_id is not unique"},
+		{"Key of a single column", withOptions(func(f *inputFile) { f.uniqueKey, f.uniqueStrict = []string{"order_id"}, true }), "Column order_id is not unique"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fileData.filepath = csvPath
+			if err := convertTo(tt.fileData, ioutil.Discard); err == nil || err.Error() != tt.wantErr {
+				t.Errorf("convertTo() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}